@@ -2,18 +2,37 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/idp"
 	"github.com/golang-jwt/jwt/v5"
-	"google.golang.org/api/idtoken"
 	"log"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	jwtIssuer        = "Flow App"
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+	accessTokenTTL   = 10 * time.Minute
+	refreshTokenTTL  = 7 * 24 * time.Hour
+)
+
+// MyCustomClaims are the claims of the tokens issued by Flow.
+// TokenType distinguishes access tokens from refresh tokens so one can never be used as the other,
+// FamilyId links every token issued from the same login (refresh token family).
+// Role is informative for other services, the API always authorizes with the role stored in the database.
 type MyCustomClaims struct {
 	jwt.RegisteredClaims
+	TokenType string `json:"token_type"`
+	FamilyId  string `json:"fid,omitempty"`
+	Role      string `json:"role,omitempty"`
 }
 
 type tokensResponse struct {
@@ -24,65 +43,97 @@ type tokensResponse struct {
 }
 
 // generateAccessToken generates a signed access token
-func (s *Server) generateAccessToken(userId string, expiresAt time.Time) (string, error) {
-	mySigningKey := []byte("my_dirty_secret")
-
-	// Create claims with multiple fields populated
+func (s *Server) generateAccessToken(userId, familyId, role string, expiresAt time.Time) (string, error) {
 	claims := MyCustomClaims{
-		jwt.RegisteredClaims{
-			// A usual scenario is to set the expiration time relative to the current time
+		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "Flow App",
+			Issuer:    jwtIssuer,
 			Subject:   userId,
 		},
+		TokenType: accessTokenType,
+		FamilyId:  familyId,
+		Role:      role,
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(mySigningKey)
-	if err != nil {
-		log.Fatal("Error signing jwt", err)
-		return "", err
-	}
-	return signedToken, nil
+	return s.signToken(claims)
 }
 
-func (s *Server) generateRefreshToken(userId string, expiresAt time.Time) (string, error) {
-	mySigningKey := []byte("my_dirty_secret")
+// generateRefreshToken generates a signed refresh token identified by tokenId ('jti' claim)
+func (s *Server) generateRefreshToken(userId, familyId, tokenId string, expiresAt time.Time) (string, error) {
 	claims := MyCustomClaims{
-		jwt.RegisteredClaims{
-			// A usual scenario is to set the expiration time relative to the current time
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId,
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "Flow App",
+			Issuer:    jwtIssuer,
 			Subject:   userId,
 		},
+		TokenType: refreshTokenType,
+		FamilyId:  familyId,
 	}
+	return s.signToken(claims)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(mySigningKey)
+func (s *Server) signToken(claims MyCustomClaims) (string, error) {
+	signedToken, err := s.keys.Sign(claims)
 	if err != nil {
-		log.Fatal("Error signing jwt", err)
+		log.Println("Error signing jwt", err)
 		return "", err
 	}
 	return signedToken, nil
 }
 
+// generateTokens issues a new pair of tokens for the user starting a new refresh token family
 func (s *Server) generateTokens(user *database.User) (*tokensResponse, error) {
-	uId := strconv.Itoa(user.Id)
-	accessTokenExpiresAt := time.Now().Add(10 * time.Minute)
-	accessToken, err := s.generateAccessToken(uId, accessTokenExpiresAt)
+	familyId, err := newTokenId()
+	if err != nil {
+		return nil, err
+	}
+	tokens, refreshToken, err := s.issueTokens(user, familyId)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.CreateRefreshTokenFamily(familyId, user.Id, refreshToken)
 	if err != nil {
-		log.Fatal("Error generating access token", err)
+		log.Println("Error saving refresh token family", err)
 		return nil, err
 	}
+	return tokens, nil
+}
 
-	refreshTokenExpiresAt := time.Now().Add(24 * time.Hour * 7)
-	refreshToken, err := s.generateRefreshToken(uId, refreshTokenExpiresAt)
+// rotateTokens redeems the refresh token described by claims and issues a new pair of tokens in the same family
+func (s *Server) rotateTokens(user *database.User, claims *MyCustomClaims) (*tokensResponse, error) {
+	tokens, refreshToken, err := s.issueTokens(user, claims.FamilyId)
 	if err != nil {
-		log.Fatal("Error generating refresh token", err)
 		return nil, err
 	}
+	err = s.store.RotateRefreshToken(claims.ID, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// issueTokens signs an access token and a refresh token, returning the refresh token record to persist
+func (s *Server) issueTokens(user *database.User, familyId string) (*tokensResponse, *database.RefreshToken, error) {
+	uId := strconv.Itoa(user.Id)
+	accessTokenExpiresAt := time.Now().Add(accessTokenTTL)
+	accessToken, err := s.generateAccessToken(uId, familyId, user.Role, accessTokenExpiresAt)
+	if err != nil {
+		log.Println("Error generating access token", err)
+		return nil, nil, err
+	}
+
+	refreshTokenId, err := newTokenId()
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshTokenExpiresAt := time.Now().Add(refreshTokenTTL)
+	refreshToken, err := s.generateRefreshToken(uId, familyId, refreshTokenId, refreshTokenExpiresAt)
+	if err != nil {
+		log.Println("Error generating refresh token", err)
+		return nil, nil, err
+	}
 
 	r := &tokensResponse{
 		AccessToken:           accessToken,
@@ -90,38 +141,87 @@ func (s *Server) generateTokens(user *database.User) (*tokensResponse, error) {
 		RefreshToken:          refreshToken,
 		RefreshTokenExpiresAt: refreshTokenExpiresAt,
 	}
+	record := &database.RefreshToken{
+		Id:        refreshTokenId,
+		FamilyId:  familyId,
+		UserId:    user.Id,
+		ExpiresAt: refreshTokenExpiresAt,
+	}
+	return r, record, nil
+}
+
+// newTokenId returns a random identifier for tokens and token families
+func newTokenId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("Error generating random token id", err)
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
 
-	return r, nil
+// verifyAccessToken validates an access token and returns its claims
+func (s *Server) verifyAccessToken(tokenString string) (*MyCustomClaims, error) {
+	return s.verifyToken(tokenString, accessTokenType)
 }
 
-func (s *Server) verifyGTokenId(token string) (string, error) {
-	// Verify the ID token, including the expiry, signature, issuer, and audience.
-	tokenPayload, err := idtoken.Validate(context.Background(), token, s.Config.GOauthClientId)
+// verifyRefreshToken validates a refresh token and returns its claims
+func (s *Server) verifyRefreshToken(tokenString string) (*MyCustomClaims, error) {
+	claims, err := s.verifyToken(tokenString, refreshTokenType)
 	if err != nil {
-		log.Printf("idtoken.Validate() error -> %v", err)
-		return "", err
+		return nil, err
+	}
+	if claims.ID == "" || claims.FamilyId == "" {
+		return nil, errors.New("refresh token has no id or family")
 	}
+	return claims, nil
+}
 
-	// Valid Token, you can use the token to get user information.
-	fmt.Println("Token successfully verified.")
-	email := tokenPayload.Claims["email"].(string)
-	return email, nil
+// verifyToken validates the signature, issuer, expiry and type of a token issued by Flow
+func (s *Server) verifyToken(tokenString, tokenType string) (*MyCustomClaims, error) {
+	claims := &MyCustomClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keys.Keyfunc, jwt.WithValidMethods(s.keys.ValidMethods()), jwt.WithIssuer(jwtIssuer), jwt.WithIssuedAt())
+	if err != nil {
+		return nil, err
+	}
+	// jwt/v5 only validates 'exp' when present, our tokens must always expire
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiration time")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("expected %s token but got %q", tokenType, claims.TokenType)
+	}
+	return claims, nil
 }
 
-func (s *Server) isValidExternalUserToken(token, idp string) (string, error) {
-	email := ""
-	if idp == "google" {
-		e, err := s.verifyGTokenId(token)
-		if err != nil {
-			return "", err
-		}
-		email = e
-	} else if idp == "facebook" {
-		log.Println("facebook not implemented yet")
-		return "", errors.New("facebook not implemented yet")
-	} else {
-		log.Println("Invalid idp:", idp)
-		return "", errors.New("invalid idp")
-	}
-	return email, nil
+// JWKSHandler publishes the public keys used to verify Flow tokens
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	sendJsonResponse(w, s.keys.JWKS(), http.StatusOK)
+}
+
+// getBearerToken extracts the token from the 'Authorization: Bearer <token>' header
+func getBearerToken(r *http.Request) (string, error) {
+	payloadAuthHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	if payloadAuthHeader == "" {
+		return "", errors.New("no token provided in Authorization header")
+	}
+	token, found := strings.CutPrefix(payloadAuthHeader, "Bearer ")
+	if !found || strings.TrimSpace(token) == "" {
+		return "", errors.New("authorization header must use the Bearer scheme")
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// isValidExternalUserToken verifies the token with the identity provider idpName and returns the identity of its owner
+func (s *Server) isValidExternalUserToken(token, idpName string) (*idp.Identity, error) {
+	identity, err := s.idps.Verify(context.Background(), idpName, token)
+	if err != nil {
+		log.Printf("Error verifying %v token -> %v", idpName, err)
+		return nil, err
+	}
+	return identity, nil
 }
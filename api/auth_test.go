@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// refresh redeems refreshToken through RefreshTokenHandler
+func refresh(s *Server, refreshToken string) (*tokensResponse, *ErrorMessage, int) {
+	body := strings.NewReader(`{"refresh_token": "` + refreshToken + `"}`)
+	w := serve(http.HandlerFunc(s.RefreshTokenHandler), newRequest(http.MethodPost, "/api/v1/auth/refresh", "", body))
+	if w.Code != http.StatusOK {
+		errRes := &ErrorMessage{}
+		json.NewDecoder(w.Body).Decode(errRes)
+		return nil, errRes, w.Code
+	}
+	tokens := &tokensResponse{}
+	json.NewDecoder(w.Body).Decode(tokens)
+	return tokens, nil, w.Code
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer}
+	store := newFakeStore(user)
+	s := newTestServer(t, store)
+	first := login(t, s, user)
+
+	second, errRes, status := refresh(s, first.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("refresh returned %v: %+v", status, errRes)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("refresh did not rotate the tokens: %+v", second)
+	}
+	claims, err := s.verifyRefreshToken(second.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstClaims, _ := s.verifyRefreshToken(first.RefreshToken)
+	if claims.FamilyId != firstClaims.FamilyId || claims.ID == firstClaims.ID {
+		t.Errorf("rotated token has family %q and id %q, want family %q and a new id", claims.FamilyId, claims.ID, firstClaims.FamilyId)
+	}
+
+	third, errRes, status := refresh(s, second.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("second refresh returned %v: %+v", status, errRes)
+	}
+
+	// Replaying a redeemed token revokes the family, the latest token of the family dies with it
+	_, errRes, status = refresh(s, first.RefreshToken)
+	if status != http.StatusUnauthorized || !strings.Contains(errRes.Error, "already used") {
+		t.Errorf("reusing a refresh token returned %v: %+v", status, errRes)
+	}
+	if !store.revokedFamilies[claims.FamilyId] {
+		t.Error("refresh token reuse did not revoke the family")
+	}
+	if _, errRes, status = refresh(s, third.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("refresh with a token of a revoked family returned %v: %+v", status, errRes)
+	}
+
+	// A new login starts a new family, unaffected by the revoked one
+	if _, errRes, status = refresh(s, login(t, s, user).RefreshToken); status != http.StatusOK {
+		t.Errorf("refresh in a new family returned %v: %+v", status, errRes)
+	}
+}
+
+func TestRefreshTokenOfBlockedUser(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, State: UserStateActive}
+	s := newTestServer(t, newFakeStore(user))
+	tokens := login(t, s, user)
+	user.State = UserStateBlocked
+	if _, errRes, status := refresh(s, tokens.RefreshToken); status != http.StatusForbidden {
+		t.Errorf("refresh of a blocked user returned %v: %+v", status, errRes)
+	}
+}
+
+func TestRefreshTokenRejectsOtherTokens(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer}
+	s := newTestServer(t, newFakeStore(user))
+	tokens := login(t, s, user)
+	unknown, err := s.generateRefreshToken("7", "f1", "unknown-token", tokens.RefreshTokenExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"access token", tokens.AccessToken},
+		{"malformed token", "not-a-jwt"},
+		{"token never issued", unknown},
+		{"token of a deleted user", login(t, s, &database.User{Id: 8}).RefreshToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, errRes, status := refresh(s, tt.token); status != http.StatusUnauthorized {
+				t.Errorf("got status %v, want %v: %+v", status, http.StatusUnauthorized, errRes)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer}
+	other := &database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer}
+	s := newTestServer(t, newFakeStore(user, other))
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Get("/api/v1/users/me", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.Post("/api/v1/auth/logout", s.LogoutHandler)
+	r.Post("/api/v1/auth/logout-all", s.LogoutAllHandler)
+	authorized := func(tokens *tokensResponse) bool {
+		return serve(r, newRequest(http.MethodGet, "/api/v1/users/me", tokens.AccessToken, nil)).Code == http.StatusNoContent
+	}
+
+	phone, laptop, tablet := login(t, s, user), login(t, s, user), login(t, s, user)
+	otherTokens := login(t, s, other)
+	if w := serve(r, newRequest(http.MethodPost, "/api/v1/auth/logout", phone.AccessToken, nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("logout returned %v: %s", w.Code, w.Body)
+	}
+	if authorized(phone) {
+		t.Error("the access token of a logged out session is still accepted")
+	}
+	if _, _, status := refresh(s, phone.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("the refresh token of a logged out session returned %v", status)
+	}
+	if !authorized(laptop) {
+		t.Error("logout ended the other sessions of the user")
+	}
+
+	if w := serve(r, newRequest(http.MethodPost, "/api/v1/auth/logout-all", laptop.AccessToken, nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("logout-all returned %v: %s", w.Code, w.Body)
+	}
+	for _, tokens := range []*tokensResponse{laptop, tablet} {
+		if authorized(tokens) {
+			t.Error("a session survived logout-all")
+		}
+		if _, _, status := refresh(s, tokens.RefreshToken); status != http.StatusUnauthorized {
+			t.Errorf("refresh after logout-all returned %v", status)
+		}
+	}
+	if !authorized(otherTokens) {
+		t.Error("logout-all ended the sessions of another user")
+	}
+}
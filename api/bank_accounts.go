@@ -0,0 +1,244 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/banking"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type bankAccountRequest struct {
+	AccountNumber string `json:"account_number"`
+	Cci           string `json:"cci"`
+	CurrencyType  string `json:"currency_type"`
+	BankName      string `json:"bank_name"` // derived from the CCI when omitted
+}
+
+func (b *bankAccountRequest) Validate() error {
+	// Banks print account numbers with dashes and spaces, only the digits are kept
+	b.AccountNumber = banking.NormalizeNumber(b.AccountNumber)
+	b.Cci = banking.NormalizeNumber(b.Cci)
+	b.CurrencyType = strings.ToUpper(strings.TrimSpace(b.CurrencyType))
+	b.BankName = strings.ToUpper(strings.TrimSpace(b.BankName))
+	if b.AccountNumber == "" {
+		return errors.New("missing required 'account_number' field")
+	}
+	if b.Cci == "" {
+		return errors.New("missing required 'cci' field")
+	}
+	if err := banking.ValidateCci(b.Cci); err != nil {
+		return fmt.Errorf("invalid 'cci' value: %w", err)
+	}
+	if b.CurrencyType == "" {
+		return errors.New("missing required 'currency_type' field")
+	}
+	return nil
+}
+
+// validateBank checks the request against bank, the bank_name of the entity of the CCI, empty if no bank has it
+func (b *bankAccountRequest) validateBank(bank string) error {
+	if bank == "" {
+		return fmt.Errorf("invalid 'cci' value: %w %q", banking.ErrUnknownCciEntity, banking.CciEntity(b.Cci))
+	}
+	if b.BankName == "" {
+		b.BankName = bank
+	} else if b.BankName != bank {
+		return fmt.Errorf("'cci' belongs to %v, not to %v", bank, b.BankName)
+	}
+	if err := banking.ValidateAccountNumber(b.BankName, b.AccountNumber); err != nil {
+		return fmt.Errorf("invalid 'account_number' value: %w", err)
+	}
+	return nil
+}
+
+// GetUserBankAccountsHandler HTTP Handler lists the personal bank accounts of a user
+func (s *Server) GetUserBankAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersRead)
+	if !ok {
+		return
+	}
+	accounts, err := s.store.GetUserBankAccounts(userId)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	sendJsonResponse(w, accounts, http.StatusOK)
+}
+
+// CreateUserBankAccountHandler HTTP Handler registers a personal bank account, the first one activates the user
+func (s *Server) CreateUserBankAccountHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateUserBankAccountHandler")
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersUpdate)
+	if !ok {
+		return
+	}
+	user, err := s.store.GetUserByID(userId)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	if user == nil {
+		errRes := ErrorMessage{
+			Message: "User not found",
+		}
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	s.createBankAccount(w, r, user, nil)
+}
+
+// DeleteUserBankAccountHandler HTTP Handler deletes a personal bank account of a user
+func (s *Server) DeleteUserBankAccountHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteUserBankAccountHandler")
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersUpdate)
+	if !ok {
+		return
+	}
+	s.deleteBankAccount(w, r, func(account *database.BankAccount) bool {
+		return account.CompanyId == nil && account.UserId == userId
+	})
+}
+
+// GetCompanyBankAccountsHandler HTTP Handler lists the bank accounts of a company
+func (s *Server) GetCompanyBankAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesRead)
+	if !ok {
+		return
+	}
+	accounts, err := s.store.GetCompanyBankAccounts(company.Id)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	sendJsonResponse(w, accounts, http.StatusOK)
+}
+
+// CreateCompanyBankAccountHandler HTTP Handler registers a bank account of a company on behalf of the caller
+func (s *Server) CreateCompanyBankAccountHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateCompanyBankAccountHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesUpdate)
+	if !ok {
+		return
+	}
+	s.createBankAccount(w, r, principal.User, &company.Id)
+}
+
+// DeleteCompanyBankAccountHandler HTTP Handler deletes a bank account of a company
+func (s *Server) DeleteCompanyBankAccountHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteCompanyBankAccountHandler")
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesUpdate)
+	if !ok {
+		return
+	}
+	s.deleteBankAccount(w, r, func(account *database.BankAccount) bool {
+		return account.CompanyId != nil && *account.CompanyId == company.Id
+	})
+}
+
+// createBankAccount registers the bank account of the request for user, or for the company companyId represented by user
+func (s *Server) createBankAccount(w http.ResponseWriter, r *http.Request, user *database.User, companyId *int) {
+	accountRequest := &bankAccountRequest{}
+	err := s.DecodeJsonBody(w, r, accountRequest)
+	if err == nil {
+		err = accountRequest.Validate()
+	}
+	if err == nil {
+		var bank string
+		bank, err = s.store.GetBankByCciEntity(banking.CciEntity(accountRequest.Cci))
+		if err != nil {
+			sendBankAccountError(w, err)
+			return
+		}
+		err = accountRequest.validateBank(bank)
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	account := &database.BankAccount{
+		AccountNumber: accountRequest.AccountNumber,
+		Cci:           accountRequest.Cci,
+		CurrencyType:  accountRequest.CurrencyType,
+		BankName:      accountRequest.BankName,
+		UserId:        user.Id,
+		CompanyId:     companyId,
+	}
+	err = s.store.CreateBankAccount(account)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	// Registering a personal bank account completes the onboarding of the user, company accounts cannot be traded
+	// from by the representative personally
+	if companyId == nil {
+		if err := s.activateUser(user); err != nil {
+			log.Printf("Error activating user %v: %v", user.Id, err)
+		}
+	}
+	sendJsonResponse(w, account, http.StatusCreated)
+}
+
+// deleteBankAccount deletes the bank account of the {accountId} URL parameter, owned reports whether it belongs to the resource of the route
+func (s *Server) deleteBankAccount(w http.ResponseWriter, r *http.Request, owned func(account *database.BankAccount) bool) {
+	accountId, err := strconv.Atoi(chi.URLParam(r, "accountId"))
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid bank account id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	account, err := s.store.GetBankAccount(accountId)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	// Accounts of other owners are reported as missing to not disclose them
+	if account == nil || !owned(account) {
+		sendBankAccountError(w, database.ErrBankAccountNotFound)
+		return
+	}
+	err = s.store.DeleteBankAccount(accountId)
+	if err != nil {
+		sendBankAccountError(w, err)
+		return
+	}
+	log.Printf("Bank account %v deleted", accountId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sendBankAccountError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrBankAccountNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrBankAccountExists), errors.Is(err, database.ErrBankAccountInUse):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	case errors.Is(err, database.ErrUnknownBank), errors.Is(err, database.ErrUnknownCurrency):
+		errRes.Message = "Invalid request"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+	default:
+		log.Printf("Error in bank account operation: %v", err)
+		errRes.Message = "Service unavailable"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateBankAccountHandlers(t *testing.T) {
+	registered := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, State: UserStateRegistered}
+	representative := &database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer, State: UserStateRegistered}
+	blocked := &database.User{Id: 9, Email: "rosa@example.com", Role: RoleCustomer, State: UserStateBlocked}
+	admin := &database.User{Id: 1, Email: "admin@flow.pe", Role: RoleAdmin, State: UserStateActive}
+	store := newFakeStore(registered, representative, blocked, admin)
+	store.companies[3] = &database.Company{Id: 3, Representatives: []*database.CompanyRepresentative{{UserId: 8}}}
+	s := newTestServer(t, store)
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Post("/api/v1/users/{id}/bank-accounts", s.CreateUserBankAccountHandler)
+	r.Post("/api/v1/companies/{companyId}/bank-accounts", s.CreateCompanyBankAccountHandler)
+	create := func(caller *database.User, target, body string) int {
+		w := serve(r, newRequest(http.MethodPost, target, accessToken(t, s, caller), strings.NewReader(body)))
+		return w.Code
+	}
+	const account = `{"account_number": "191-1234567-0-12", "cci": "002-193-001234567890-13", "currency_type": "pen"}`
+
+	// A company account does not complete the personal onboarding of the representative
+	if status := create(representative, "/api/v1/companies/3/bank-accounts", account); status != http.StatusCreated {
+		t.Fatalf("creating a company account got status %v", status)
+	}
+	if representative.State != UserStateRegistered {
+		t.Errorf("a company account moved its representative to %q", representative.State)
+	}
+	if status := create(representative, "/api/v1/companies/3/bank-accounts", account); status != http.StatusConflict {
+		t.Errorf("a duplicated company account got status %v, want %v", status, http.StatusConflict)
+	}
+
+	// The first personal account activates a registered user
+	if status := create(registered, "/api/v1/users/7/bank-accounts", account); status != http.StatusCreated {
+		t.Fatalf("creating a personal account got status %v", status)
+	}
+	if registered.State != UserStateActive {
+		t.Errorf("the first personal account left the user %q, want %q", registered.State, UserStateActive)
+	}
+	if status := create(registered, "/api/v1/users/7/bank-accounts", account); status != http.StatusConflict {
+		t.Errorf("a duplicated personal account got status %v, want %v", status, http.StatusConflict)
+	}
+	if status := create(registered, "/api/v1/users/7/bank-accounts", `{"account_number": "1911234567013", "cci": "00219300123456789013", "currency_type": "EUR"}`); status != http.StatusBadRequest {
+		t.Errorf("an account in an unknown currency got status %v, want %v", status, http.StatusBadRequest)
+	}
+
+	// Only registered users are activated, an admin adding an account to a blocked user does not unblock it
+	if status := create(admin, "/api/v1/users/9/bank-accounts", account); status != http.StatusCreated {
+		t.Fatalf("an admin creating an account got status %v", status)
+	}
+	if blocked.State != UserStateBlocked {
+		t.Errorf("a bank account moved a blocked user to %q", blocked.State)
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/banking"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// GetCatalogHandler HTTP Handler returns the enabled entries of a reference data catalog (banks, currencies,
+// document-types, order-types, order-states or user-states). Clients revalidate it with If-None-Match.
+func (s *Server) GetCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	catalog := chi.URLParam(r, "catalog")
+	entries, err := s.store.GetCatalog(catalog, false)
+	if err != nil {
+		sendCatalogError(w, err)
+		return
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		log.Printf("Error marshalling catalog %v: %v", catalog, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body); err != nil {
+		log.Println("Error writing http response: ", err)
+	}
+}
+
+// ifNoneMatch reports whether the If-None-Match header of the request matches etag
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type catalogEntryRequest struct {
+	Code          string `json:"code"`
+	Name          string `json:"name"`
+	CciEntityCode string `json:"cci_entity_code"` // required for banks
+}
+
+func (c *catalogEntryRequest) Validate() error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Code == "" {
+		return errors.New("missing required 'code' field")
+	}
+	if len(c.Code) > 20 {
+		return errors.New("invalid 'code' value, it must have at most 20 characters")
+	}
+	if c.Name == "" {
+		return errors.New("missing required 'name' field")
+	}
+	// banks.full_name is a VARCHAR(50)
+	if utf8.RuneCountInString(c.Name) > 50 {
+		return errors.New("invalid 'name' value, it must have at most 50 characters")
+	}
+	return nil
+}
+
+// CreateCatalogEntryHandler HTTP Handler adds a bank or a currency to the catalog
+func (s *Server) CreateCatalogEntryHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateCatalogEntryHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	catalog := chi.URLParam(r, "catalog")
+	entryRequest := &catalogEntryRequest{}
+	err := s.DecodeJsonBody(w, r, entryRequest)
+	if err == nil {
+		err = entryRequest.Validate()
+	}
+	if err == nil && catalog == "currencies" && len(entryRequest.Code) != 3 {
+		err = errors.New("invalid 'code' value, currencies use ISO 4217 codes")
+	}
+	// Bank accounts are matched to their bank by the entity code of their CCI
+	if err == nil && database.IsBankCatalog(catalog) && !banking.IsCciEntityCode(entryRequest.CciEntityCode) {
+		err = errors.New("invalid 'cci_entity_code' value, banks need the 3 digit entity code of their CCIs")
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	entry := &database.CatalogEntry{
+		Code: entryRequest.Code,
+		Name: entryRequest.Name,
+	}
+	audit := &database.AuditEntry{
+		ActorUserId: principal.User.Id,
+		Action:      "catalog.entry_added",
+		Details: map[string]interface{}{
+			"catalog": catalog,
+			"code":    entry.Code,
+			"name":    entry.Name,
+		},
+	}
+	if database.IsBankCatalog(catalog) {
+		entry.CciEntityCode = entryRequest.CciEntityCode
+		audit.Details["cci_entity_code"] = entry.CciEntityCode
+	}
+	err = s.store.CreateCatalogEntry(catalog, entry, audit)
+	if err != nil {
+		sendCatalogError(w, err)
+		return
+	}
+	log.Printf("User %v added %v to catalog %v", principal.User.Id, entry.Code, catalog)
+	sendJsonResponse(w, entry, http.StatusCreated)
+}
+
+type catalogEntryPatchRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// UpdateCatalogEntryHandler HTTP Handler enables or disables a bank or a currency of the catalog
+func (s *Server) UpdateCatalogEntryHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateCatalogEntryHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	catalog := chi.URLParam(r, "catalog")
+	code := chi.URLParam(r, "code")
+	patchRequest := &catalogEntryPatchRequest{}
+	err := s.DecodeJsonBody(w, r, patchRequest)
+	if err == nil && patchRequest.Enabled == nil {
+		err = errors.New("missing required 'enabled' field")
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	action := "catalog.entry_disabled"
+	if *patchRequest.Enabled {
+		action = "catalog.entry_enabled"
+	}
+	audit := &database.AuditEntry{
+		ActorUserId: principal.User.Id,
+		Action:      action,
+		Details: map[string]interface{}{
+			"catalog": catalog,
+			"code":    code,
+		},
+	}
+	err = s.store.SetCatalogEntryEnabled(catalog, code, *patchRequest.Enabled, audit)
+	if err != nil {
+		sendCatalogError(w, err)
+		return
+	}
+	log.Printf("User %v set %v of catalog %v enabled=%v", principal.User.Id, code, catalog, *patchRequest.Enabled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sendCatalogError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrUnknownCatalog), errors.Is(err, database.ErrCatalogEntryNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrCatalogReadOnly):
+		sendJsonResponse(w, errRes, http.StatusMethodNotAllowed)
+	case errors.Is(err, database.ErrCatalogEntryExists):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	default:
+		log.Printf("Error in catalog operation: %v", err)
+		errRes.Message = "Service unavailable"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+	}
+}
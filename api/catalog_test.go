@@ -0,0 +1,70 @@
+package api
+
+import (
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetCatalogHandlerRevalidation(t *testing.T) {
+	store := newFakeStore()
+	s := newTestServer(t, store)
+	r := chi.NewRouter()
+	r.Get("/api/v1/catalog/{catalog}", s.GetCatalogHandler)
+	get := func(catalog, ifNoneMatch string) (int, string, string) {
+		req := newRequest(http.MethodGet, "/api/v1/catalog/"+catalog, "", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := serve(r, req)
+		return w.Code, w.Header().Get("ETag"), w.Body.String()
+	}
+
+	status, etag, body := get("currencies", "")
+	if status != http.StatusOK || etag == "" || !strings.Contains(body, `"USD"`) {
+		t.Fatalf("got status %v, ETag %q and body %s", status, etag, body)
+	}
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		status, got, body := get("currencies", header)
+		if status != http.StatusNotModified || got != etag || body != "" {
+			t.Errorf("If-None-Match %s got status %v, ETag %q and body %q, want %v", header, status, got, body, http.StatusNotModified)
+		}
+	}
+	if status, _, _ := get("currencies", `"other"`); status != http.StatusOK {
+		t.Errorf("a stale ETag got status %v, want %v", status, http.StatusOK)
+	}
+
+	// Disabling an entry changes the catalog and so its ETag
+	store.catalogs["currencies"][1].Enabled = false
+	status, changed, body := get("currencies", etag)
+	if status != http.StatusOK || changed == etag || strings.Contains(body, `"USD"`) {
+		t.Errorf("a changed catalog got status %v, ETag %q and body %s", status, changed, body)
+	}
+
+	if status, _, _ := get("planets", ""); status != http.StatusNotFound {
+		t.Errorf("an unknown catalog got status %v, want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestCatalogEntryRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   catalogEntryRequest
+		wantErr bool
+	}{
+		{"valid", catalogEntryRequest{Code: "bcp", Name: "Banco de Crédito del Perú"}, false},
+		{"name at the column size", catalogEntryRequest{Code: "BCP", Name: strings.Repeat("é", 50)}, false},
+		{"name too long", catalogEntryRequest{Code: "BCP", Name: strings.Repeat("a", 51)}, true},
+		{"code too long", catalogEntryRequest{Code: strings.Repeat("A", 21), Name: "Banco"}, true},
+		{"missing code", catalogEntryRequest{Name: "Banco"}, true},
+		{"missing name", catalogEntryRequest{Code: "BCP", Name: " "}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.entry.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
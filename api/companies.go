@@ -0,0 +1,361 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/documents"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxCompanyFieldLength is the size of the legal_name and fiscal_address columns
+const maxCompanyFieldLength = 150
+
+type companyRequest struct {
+	LegalName     string `json:"legal_name"`
+	Ruc           string `json:"ruc"`
+	FiscalAddress string `json:"fiscal_address"`
+}
+
+func (c *companyRequest) Validate() error {
+	c.LegalName = strings.TrimSpace(c.LegalName)
+	c.Ruc = documents.Normalize(c.Ruc)
+	c.FiscalAddress = strings.TrimSpace(c.FiscalAddress)
+	if c.LegalName == "" {
+		return errors.New("missing required 'legal_name' field")
+	}
+	if utf8.RuneCountInString(c.LegalName) > maxCompanyFieldLength {
+		return fmt.Errorf("'legal_name' must be at most %d characters", maxCompanyFieldLength)
+	}
+	if c.Ruc == "" {
+		return errors.New("missing required 'ruc' field")
+	}
+	if err := documents.Validate(documents.TypeRuc, c.Ruc); err != nil {
+		return errors.New("invalid 'ruc' value: " + err.Error())
+	}
+	if c.FiscalAddress == "" {
+		return errors.New("missing required 'fiscal_address' field")
+	}
+	if utf8.RuneCountInString(c.FiscalAddress) > maxCompanyFieldLength {
+		return fmt.Errorf("'fiscal_address' must be at most %d characters", maxCompanyFieldLength)
+	}
+	return nil
+}
+
+func (c *companyRequest) company() *database.Company {
+	return &database.Company{
+		LegalName:     c.LegalName,
+		Ruc:           c.Ruc,
+		FiscalAddress: c.FiscalAddress,
+	}
+}
+
+// CreateCompanyHandler HTTP Handler registers a company, the caller becomes its first representative
+func (s *Server) CreateCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateCompanyHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	companyReq := &companyRequest{}
+	err := s.DecodeJsonBody(w, r, companyReq)
+	if err == nil {
+		err = companyReq.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	company := companyReq.company()
+	err = s.store.CreateCompany(company, principal.User.Id)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	company, err = s.store.GetCompany(company.Id)
+	if err != nil || company == nil {
+		log.Printf("Error getting created company: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v created company %v", principal.User.Id, company.Id)
+	sendJsonResponse(w, company, http.StatusCreated)
+}
+
+// GetCompaniesHandler HTTP Handler lists the companies the caller represents
+func (s *Server) GetCompaniesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	companies, err := s.store.GetUserCompanies(principal.User.Id)
+	if err != nil {
+		log.Printf("Error getting companies of user %v: %v", principal.User.Id, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	sendJsonResponse(w, companies, http.StatusOK)
+}
+
+// GetCompanyHandler HTTP Handler returns a company with its representatives
+func (s *Server) GetCompanyHandler(w http.ResponseWriter, r *http.Request) {
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesRead)
+	if !ok {
+		return
+	}
+	sendJsonResponse(w, company, http.StatusOK)
+}
+
+// companyInvitationTtl is how long invited users have to accept an invitation to represent a company
+const companyInvitationTtl = 7 * 24 * time.Hour
+
+type addRepresentativeRequest struct {
+	Email string `json:"email"`
+}
+
+func (a *addRepresentativeRequest) Validate() error {
+	a.Email = strings.TrimSpace(a.Email)
+	if a.Email == "" {
+		return errors.New("missing required 'email' field")
+	}
+	if !isValidEmail(a.Email) {
+		return errors.New("invalid email")
+	}
+	return nil
+}
+
+// AddCompanyRepresentativeHandler HTTP Handler invites the user of an email to represent the company, the user becomes
+// a representative when it accepts the invitation. The response is the same whether or not the email is registered.
+func (s *Server) AddCompanyRepresentativeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("AddCompanyRepresentativeHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesUpdate)
+	if !ok {
+		return
+	}
+	repRequest := &addRepresentativeRequest{}
+	err := s.DecodeJsonBody(w, r, repRequest)
+	if err == nil {
+		err = repRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	invitation := &database.CompanyInvitation{
+		CompanyId:       company.Id,
+		Email:           repRequest.Email,
+		InvitedByUserId: principal.User.Id,
+		ExpiresAt:       time.Now().UTC().Add(companyInvitationTtl),
+	}
+	entry := &database.AuditEntry{
+		ActorUserId: principal.User.Id,
+		Action:      "company.representative_invited",
+		Details: map[string]interface{}{
+			"company_id": company.Id,
+			"email":      invitation.Email,
+		},
+	}
+	err = s.store.CreateCompanyInvitation(invitation, entry)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	log.Printf("User %v invited a representative to company %v, invitation %v", principal.User.Id, company.Id, invitation.Id)
+	sendJsonResponse(w, invitation, http.StatusAccepted)
+}
+
+// GetCompanyInvitationsHandler HTTP Handler lists the open invitations to represent a company sent to the caller
+func (s *Server) GetCompanyInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	invitations, err := s.store.GetCompanyInvitations(principal.User.Email)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	sendJsonResponse(w, invitations, http.StatusOK)
+}
+
+// AcceptCompanyInvitationHandler HTTP Handler makes the caller a representative of the company that invited it
+func (s *Server) AcceptCompanyInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("AcceptCompanyInvitationHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	invitationId, ok := invitationIdParam(w, r)
+	if !ok {
+		return
+	}
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       "company.representative_added",
+		TargetUserId: &principal.User.Id,
+	}
+	invitation, err := s.store.AcceptCompanyInvitation(invitationId, principal.User, entry)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	log.Printf("User %v accepted invitation %v to represent company %v", principal.User.Id, invitation.Id, invitation.CompanyId)
+	sendJsonResponse(w, invitation, http.StatusOK)
+}
+
+// DeclineCompanyInvitationHandler HTTP Handler declines an invitation to represent a company sent to the caller
+func (s *Server) DeclineCompanyInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeclineCompanyInvitationHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	invitationId, ok := invitationIdParam(w, r)
+	if !ok {
+		return
+	}
+	invitation, err := s.store.DeclineCompanyInvitation(invitationId, principal.User.Email)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	log.Printf("User %v declined invitation %v to represent company %v", principal.User.Id, invitation.Id, invitation.CompanyId)
+	sendJsonResponse(w, invitation, http.StatusOK)
+}
+
+func invitationIdParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	invitationId, err := strconv.Atoi(chi.URLParam(r, "invitationId"))
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid invitation id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
+	}
+	return invitationId, true
+}
+
+// RemoveCompanyRepresentativeHandler HTTP Handler revokes the authorization of a representative of the company
+func (s *Server) RemoveCompanyRepresentativeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("RemoveCompanyRepresentativeHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesUpdate)
+	if !ok {
+		return
+	}
+	userId, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid user id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       "company.representative_removed",
+		TargetUserId: &userId,
+		Details: map[string]interface{}{
+			"company_id": company.Id,
+		},
+	}
+	err = s.store.RemoveCompanyRepresentative(company.Id, userId, entry)
+	if err != nil {
+		sendCompanyError(w, err)
+		return
+	}
+	log.Printf("User %v removed user %v as representative of company %v", principal.User.Id, userId, company.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeCompanyAccess loads the company of the {companyId} URL parameter, allowing its representatives
+// and callers granted perm, otherwise it sends the error response
+func (s *Server) authorizeCompanyAccess(w http.ResponseWriter, r *http.Request, perm Permission) (*database.Company, bool) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return nil, false
+	}
+	companyId, err := strconv.Atoi(chi.URLParam(r, "companyId"))
+	if err != nil {
+		log.Println("Invalid company id:", chi.URLParam(r, "companyId"))
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid company id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return nil, false
+	}
+	company, err := s.store.GetCompany(companyId)
+	if err != nil {
+		log.Printf("Error getting company from database: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return nil, false
+	}
+	if company == nil {
+		sendCompanyError(w, database.ErrCompanyNotFound)
+		return nil, false
+	}
+	if principal.HasPermission(perm) {
+		return company, true
+	}
+	for _, rep := range company.Representatives {
+		if rep.UserId == principal.User.Id {
+			return company, true
+		}
+	}
+	log.Printf("User %v is not allowed to access company %v", principal.User.Id, companyId)
+	sendForbidden(w)
+	return nil, false
+}
+
+func sendCompanyError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrCompanyNotFound), errors.Is(err, database.ErrRepresentativeNotFound), errors.Is(err, database.ErrInvitationNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrCompanyExists), errors.Is(err, database.ErrLastRepresentative):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	default:
+		log.Printf("Error in company operation: %v", err)
+		errRes.Message = "Service unavailable"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompanyRequestValidate(t *testing.T) {
+	valid := func() companyRequest {
+		return companyRequest{LegalName: "Inversiones Andinas S.A.C.", Ruc: "20131312955", FiscalAddress: "Av. Javier Prado Este 123, San Isidro"}
+	}
+	tests := []struct {
+		name    string
+		edit    func(c *companyRequest)
+		wantErr bool
+	}{
+		{"valid", func(c *companyRequest) {}, false},
+		{"names at the column size", func(c *companyRequest) {
+			c.LegalName = strings.Repeat("ñ", 150)
+			c.FiscalAddress = strings.Repeat("a", 150)
+		}, false},
+		{"missing legal name", func(c *companyRequest) { c.LegalName = "  " }, true},
+		{"legal name too long", func(c *companyRequest) { c.LegalName = strings.Repeat("a", 151) }, true},
+		{"missing ruc", func(c *companyRequest) { c.Ruc = "" }, true},
+		{"wrong ruc check digit", func(c *companyRequest) { c.Ruc = "20131312956" }, true},
+		{"missing fiscal address", func(c *companyRequest) { c.FiscalAddress = "" }, true},
+		{"fiscal address too long", func(c *companyRequest) { c.FiscalAddress = strings.Repeat("a", 151) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.edit(&c)
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -35,4 +35,5 @@ func ErrInvalidRequest(err error) render.Renderer {
 type ErrorMessage struct {
 	Message string `json:"message"`         // user-level message
 	Error   string `json:"error,omitempty"` // application-level error message, for debugging
+	Code    string `json:"code,omitempty"`  // stable identifier of the error for clients, set where they must tell errors apart
 }
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunRetentionJob anonymizes, every interval, the deleted users whose retention window has passed.
+// It blocks until ctx is done.
+func (s *Server) RunRetentionJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		retention := time.Duration(s.Config.UserRetentionDays) * 24 * time.Hour
+		anonymized, err := s.store.AnonymizeDeletedUsers(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Retention job: error anonymizing deleted users: %v", err)
+		} else if anonymized > 0 {
+			log.Printf("Retention job: %v deleted users anonymized", anonymized)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOrderExpiryJob expires, every interval, the pending orders whose transfer window has passed.
+// It blocks until ctx is done.
+func (s *Server) RunOrderExpiryJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		expired, err := s.store.ExpirePendingOrders(time.Now(), orderExpiredReason)
+		if err != nil {
+			log.Printf("Order expiry job: error expiring pending orders: %v", err)
+		} else if expired > 0 {
+			log.Printf("Order expiry job: %v pending orders expired", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
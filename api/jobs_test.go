@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"github.com/angelmotta/flow-api/database"
+	"testing"
+	"time"
+)
+
+func TestRunRetentionJob(t *testing.T) {
+	expired := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, Dni: "12345678", Name: "Ana"}
+	retained := &database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer, Dni: "87654321", Name: "Luis"}
+	active := &database.User{Id: 9, Email: "rosa@example.com", Role: RoleCustomer, Dni: "11223344", Name: "Rosa"}
+	store := newFakeStore(expired, retained, active)
+	store.deletedUsers[7] = time.Now().AddDate(0, 0, -31)
+	store.deletedUsers[8] = time.Now().AddDate(0, 0, -29)
+	s := newTestServer(t, store)
+	s.Config.UserRetentionDays = 30
+
+	// A done context makes the job return after its first run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.RunRetentionJob(ctx, time.Hour)
+
+	if expired.Email != "anonymized-7" || expired.Dni != "" || expired.Name != "" {
+		t.Errorf("the user deleted before the retention window was not anonymized: %+v", expired)
+	}
+	if retained.Dni != "87654321" || active.Dni != "11223344" {
+		t.Errorf("users within the retention window were anonymized: %+v, %+v", retained, active)
+	}
+}
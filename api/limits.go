@@ -0,0 +1,261 @@
+package api
+
+import (
+	"errors"
+	"github.com/angelmotta/flow-api/database"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultUifReportDays is the period listed by GetUifReportsHandler when 'from' is not sent
+const defaultUifReportDays = 30
+
+type kycTierRequest struct {
+	KycTier string `json:"kyc_tier"`
+	Reason  string `json:"reason"`
+}
+
+func (k *kycTierRequest) Validate() error {
+	k.KycTier = strings.TrimSpace(k.KycTier)
+	if k.KycTier == "" {
+		return errors.New("missing required 'kyc_tier' field")
+	}
+	if strings.TrimSpace(k.Reason) == "" {
+		return errors.New("missing required 'reason' field")
+	}
+	return nil
+}
+
+// ChangeUserKycTierHandler HTTP Handler sets the KYC tier of a user, which selects its transaction limits, and returns
+// the updated user. The change is recorded in the audit log.
+func (s *Server) ChangeUserKycTierHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ChangeUserKycTierHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersKyc)
+	if !ok {
+		return
+	}
+	// Owners pass authorizeUserAccess, but operators must not raise their own limits
+	if userId == principal.User.Id {
+		errRes := ErrorMessage{
+			Message: "You cannot change your own KYC tier",
+		}
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+
+	tierRequest := &kycTierRequest{}
+	err := s.DecodeJsonBody(w, r, tierRequest)
+	if err == nil {
+		err = tierRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       "user.kyc_tier_changed",
+		TargetUserId: &userId,
+		Details: map[string]interface{}{
+			"reason": tierRequest.Reason,
+		},
+	}
+	err = s.store.UpdateUserKycTier(userId, tierRequest.KycTier, entry)
+	if err != nil {
+		sendLimitsError(w, err)
+		return
+	}
+	log.Printf("User %v changed KYC tier of user %v to %v", principal.User.Id, userId, tierRequest.KycTier)
+	user, err := s.store.GetUserByID(userId)
+	if err != nil || user == nil {
+		log.Printf("Error getting updated user: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	sendJsonResponse(w, user, http.StatusOK)
+}
+
+// GetUserLimitsHandler HTTP Handler returns the limits applying to a user, by currency, and how much of them is used
+func (s *Server) GetUserLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersRead)
+	if !ok {
+		return
+	}
+	limits, err := s.store.GetUserLimits(userId, time.Now())
+	if err != nil {
+		sendLimitsError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJsonResponse(w, limits, http.StatusOK)
+}
+
+// ChangeCompanyKycTierHandler HTTP Handler sets the KYC tier of a company, which selects the limits of the orders of
+// the company, and returns the updated company. The change is recorded in the audit log.
+func (s *Server) ChangeCompanyKycTierHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ChangeCompanyKycTierHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	company, ok := s.authorizeCompanyAccess(w, r, PermUsersKyc)
+	if !ok {
+		return
+	}
+	// Representatives pass authorizeCompanyAccess, but operators must not raise the limits of their own companies
+	for _, rep := range company.Representatives {
+		if rep.UserId == principal.User.Id {
+			errRes := ErrorMessage{
+				Message: "You cannot change the KYC tier of a company you represent",
+			}
+			sendJsonResponse(w, errRes, http.StatusForbidden)
+			return
+		}
+	}
+
+	tierRequest := &kycTierRequest{}
+	err := s.DecodeJsonBody(w, r, tierRequest)
+	if err == nil {
+		err = tierRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	entry := &database.AuditEntry{
+		ActorUserId: principal.User.Id,
+		Action:      "company.kyc_tier_changed",
+		Details: map[string]interface{}{
+			"reason": tierRequest.Reason,
+		},
+	}
+	err = s.store.UpdateCompanyKycTier(company.Id, tierRequest.KycTier, entry)
+	if err != nil {
+		sendLimitsError(w, err)
+		return
+	}
+	log.Printf("User %v changed KYC tier of company %v to %v", principal.User.Id, company.Id, tierRequest.KycTier)
+	company, err = s.store.GetCompany(company.Id)
+	if err != nil || company == nil {
+		log.Printf("Error getting updated company: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	sendJsonResponse(w, company, http.StatusOK)
+}
+
+// GetCompanyLimitsHandler HTTP Handler returns the limits applying to the orders of a company, by currency, and how
+// much of them its representatives used
+func (s *Server) GetCompanyLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	company, ok := s.authorizeCompanyAccess(w, r, PermCompaniesRead)
+	if !ok {
+		return
+	}
+	limits, err := s.store.GetCompanyLimits(company.Id, time.Now())
+	if err != nil {
+		sendLimitsError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJsonResponse(w, limits, http.StatusOK)
+}
+
+// GetUifReportsHandler HTTP Handler returns a page of the orders flagged for the UIF, newest first, created between
+// 'from' (the last 30 days by default) and 'to'. It accepts the same 'state', 'company_id', 'limit' and 'cursor' as
+// GetOrdersHandler.
+func (s *Server) GetUifReportsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUifReportFilter(r)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	page, err := s.store.GetOrders(filter)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	response := ordersPageResponse{Orders: page.Orders}
+	if page.NextBeforeId > 0 {
+		response.NextCursor = strconv.Itoa(page.NextBeforeId)
+	}
+	sendJsonResponse(w, response, http.StatusOK)
+}
+
+// parseUifReportFilter adds the 'from' and 'to' query parameters (RFC 3339 or YYYY-MM-DD) to the orders listing filter
+func parseUifReportFilter(r *http.Request) (*database.OrderFilter, error) {
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	filter.UifReport = true
+	q := r.URL.Query()
+	from := time.Now().UTC().AddDate(0, 0, -defaultUifReportDays)
+	if v := q.Get("from"); v != "" {
+		from, err = parseTimeParam(v)
+		if err != nil {
+			return nil, errors.New("invalid 'from' value")
+		}
+	}
+	filter.CreatedFrom = &from
+	if v := q.Get("to"); v != "" {
+		to, err := parseTimeParam(v)
+		if err != nil {
+			return nil, errors.New("invalid 'to' value")
+		}
+		if !to.After(from) {
+			return nil, errors.New("'to' must be after 'from'")
+		}
+		filter.CreatedTo = &to
+	}
+	return filter, nil
+}
+
+func sendLimitsError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrUserNotFound):
+		errRes.Message = "User not found"
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrCompanyNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrUnknownKycTier):
+		errRes.Message = "Invalid request"
+		errRes.Error = "invalid 'kyc_tier' value"
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+	default:
+		log.Println("Error with transaction limits:", err)
+		errRes.Message = "Service unavailable"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+	}
+}
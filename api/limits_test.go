@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChangeKycTierHandlers(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	operator := &database.User{Id: 2, Email: "operator@flow.pe", Role: RoleOperator}
+	store.users[2] = operator
+	store.users[7].KycTier = "basic"
+	store.companies[3].KycTier = "basic"
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Put("/api/v1/users/{id}/kyc-tier", s.ChangeUserKycTierHandler)
+	r.Put("/api/v1/companies/{companyId}/kyc-tier", s.ChangeCompanyKycTierHandler)
+	put := func(caller *database.User, target, body string) (int, []byte) {
+		w := serve(r, newRequest(http.MethodPut, target, accessToken(t, s, caller), strings.NewReader(body)))
+		return w.Code, w.Body.Bytes()
+	}
+
+	status, body := put(operator, "/api/v1/users/7/kyc-tier", `{"kyc_tier": "verified", "reason": "address verified"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %v: %s", status, body)
+	}
+	user := &database.User{}
+	if err := json.Unmarshal(body, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 7 || user.KycTier != "verified" {
+		t.Errorf("got user %+v, want user 7 with the verified tier", user)
+	}
+
+	status, body = put(operator, "/api/v1/companies/3/kyc-tier", `{"kyc_tier": "enhanced", "reason": "source of funds"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %v: %s", status, body)
+	}
+	company := &database.Company{}
+	if err := json.Unmarshal(body, company); err != nil {
+		t.Fatal(err)
+	}
+	if company.Id != 3 || company.KycTier != "enhanced" {
+		t.Errorf("got company %+v, want company 3 with the enhanced tier", company)
+	}
+	if len(store.audit) != 2 {
+		t.Errorf("got %v audit entries, want 2", len(store.audit))
+	}
+
+	tests := []struct {
+		name   string
+		caller *database.User
+		target string
+		body   string
+		status int
+	}{
+		{"unknown tier", operator, "/api/v1/users/7/kyc-tier", `{"kyc_tier": "gold", "reason": "vip"}`, http.StatusBadRequest},
+		{"missing reason", operator, "/api/v1/users/7/kyc-tier", `{"kyc_tier": "verified"}`, http.StatusBadRequest},
+		{"own tier", operator, "/api/v1/users/2/kyc-tier", `{"kyc_tier": "enhanced", "reason": "self"}`, http.StatusForbidden},
+		{"unknown company", operator, "/api/v1/companies/9/kyc-tier", `{"kyc_tier": "verified", "reason": "review"}`, http.StatusNotFound},
+		{"company represented by the caller", store.users[7], "/api/v1/companies/3/kyc-tier", `{"kyc_tier": "verified", "reason": "review"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := put(tt.caller, tt.target, tt.body); status != tt.status {
+				t.Errorf("got status %v, want %v: %s", status, tt.status, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"github.com/angelmotta/flow-api/database"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	User      *database.User
+	SessionId string // refresh token family the access token was issued for
+}
+
+// HasPermission reports whether the role of the caller grants perm
+func (p *Principal) HasPermission(perm Permission) bool {
+	return roleHasPermission(p.User.Role, perm)
+}
+
+// CanAccessUser reports whether the caller is the owner of the given user record or is granted perm over any user
+func (p *Principal) CanAccessUser(userId int, perm Permission) bool {
+	return p.User.Id == userId || p.HasPermission(perm)
+}
+
+// PrincipalFromContext returns the authenticated caller injected by AuthMiddleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(*Principal)
+	return p, ok
+}
+
+// AuthMiddleware verifies the access token of the Authorization header and injects the caller into the request context
+func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := getBearerToken(r)
+		if err != nil {
+			log.Println("AuthMiddleware:", err)
+			sendUnauthorized(w, err.Error())
+			return
+		}
+
+		claims, err := s.verifyAccessToken(token)
+		if err != nil {
+			log.Printf("AuthMiddleware: invalid access token -> %v", err)
+			sendUnauthorized(w, "invalid access token")
+			return
+		}
+
+		userId, err := strconv.Atoi(claims.Subject)
+		if err != nil || claims.FamilyId == "" {
+			log.Printf("AuthMiddleware: invalid subject %q or session %q in access token", claims.Subject, claims.FamilyId)
+			sendUnauthorized(w, "invalid access token")
+			return
+		}
+
+		// Access tokens die with their session (logout, refresh token reuse, user blocked or deleted)
+		active, err := s.store.IsRefreshTokenFamilyActive(claims.FamilyId)
+		if err != nil {
+			log.Printf("Error getting session from database: %v", err)
+			errRes := ErrorMessage{
+				Message: "Service unavailable",
+			}
+			sendJsonResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			log.Printf("AuthMiddleware: session %v has been revoked", claims.FamilyId)
+			sendUnauthorized(w, "session has been revoked")
+			return
+		}
+
+		user, err := s.store.GetUserByID(userId)
+		if err != nil {
+			log.Printf("Error getting user from database: %v", err)
+			errRes := ErrorMessage{
+				Message: "Service unavailable",
+			}
+			sendJsonResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			log.Printf("AuthMiddleware: user %v not found", userId)
+			sendUnauthorized(w, "user not found")
+			return
+		}
+
+		if user.State == UserStateBlocked {
+			log.Printf("AuthMiddleware: user %v is blocked", userId)
+			sendUserBlocked(w)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalContextKey, &Principal{User: user, SessionId: claims.FamilyId})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getPrincipal returns the caller of an authenticated route, sending a 401 response if it is missing
+func getPrincipal(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	p, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		// Route was registered without AuthMiddleware
+		log.Println("No principal found in request context")
+		sendUnauthorized(w, "authentication required")
+		return nil, false
+	}
+	return p, true
+}
+
+func sendUnauthorized(w http.ResponseWriter, reason string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="flow-api"`)
+	errRes := ErrorMessage{
+		Message: "Unauthorized",
+		Error:   reason,
+	}
+	sendJsonResponse(w, errRes, http.StatusUnauthorized)
+}
+
+func sendForbidden(w http.ResponseWriter) {
+	errRes := ErrorMessage{
+		Message: "You are not allowed to perform this action",
+	}
+	sendJsonResponse(w, errRes, http.StatusForbidden)
+}
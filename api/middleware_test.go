@@ -0,0 +1,93 @@
+package api
+
+import (
+	"github.com/angelmotta/flow-api/database"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer}
+	blocked := &database.User{Id: 9, Email: "luis@example.com", Role: RoleCustomer, State: UserStateBlocked}
+	store := newFakeStore(user, blocked)
+	s := newTestServer(t, store)
+	var principal *Principal
+	h := s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = PrincipalFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	expired, err := s.generateAccessToken("7", "f1", RoleCustomer, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSession, err := s.generateAccessToken("7", "", RoleCustomer, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownSession, err := s.generateAccessToken("7", "unknown-session", RoleCustomer, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked := login(t, s, user).AccessToken
+	revokedClaims, err := s.verifyAccessToken(revoked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.RevokeRefreshTokenFamily(revokedClaims.FamilyId, user.Id, "logout")
+
+	claims := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Issuer:    jwtIssuer,
+		Subject:   "7",
+	}
+	otherKey, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("not the signing key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"basic scheme", "Basic YW5hOnNlY3JldA==", http.StatusUnauthorized},
+		{"malformed token", "Bearer not-a-jwt", http.StatusUnauthorized},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized},
+		{"signed with another key", "Bearer " + otherKey, http.StatusUnauthorized},
+		{"unsigned token", "Bearer " + unsigned, http.StatusUnauthorized},
+		{"refresh token", "Bearer " + login(t, s, user).RefreshToken, http.StatusUnauthorized},
+		{"token without session", "Bearer " + noSession, http.StatusUnauthorized},
+		{"unknown session", "Bearer " + unknownSession, http.StatusUnauthorized},
+		{"revoked session", "Bearer " + revoked, http.StatusUnauthorized},
+		{"unknown user", "Bearer " + accessToken(t, s, &database.User{Id: 8}), http.StatusUnauthorized},
+		{"blocked user", "Bearer " + accessToken(t, s, blocked), http.StatusForbidden},
+		{"valid token", "Bearer " + accessToken(t, s, user), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal = nil
+			r := newRequest(http.MethodGet, "/api/v1/users/7", "", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := serve(h, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+			if tt.status == http.StatusNoContent && (principal == nil || principal.User.Id != user.Id || principal.SessionId == "") {
+				t.Errorf("got principal %+v, want user %v and its session", principal, user.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultOrderQueueSize = 50
+	maxOrderQueueSize     = 100
+	maxPayoutReferenceLen = 100
+)
+
+// GetOrderQueueHandler HTTP Handler returns the orders operators have to work: 'state' pending (default) or inprogress,
+// 'pair', 'side', 'mine' (true for the in progress orders of the caller), 'sort' (age, oldest first by default, or
+// amount, largest first, which requires 'pair') and 'limit'
+func (s *Server) GetOrderQueueHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseOrderQueueFilter(r)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("mine") == "true" {
+		filter.State = OrderStateInProgress
+		filter.OperatorUserId = &principal.User.Id
+	}
+	orders, err := s.store.GetOrderQueue(filter)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	sendJsonResponse(w, orders, http.StatusOK)
+}
+
+func parseOrderQueueFilter(r *http.Request) (*database.OrderQueueFilter, error) {
+	q := r.URL.Query()
+	filter := &database.OrderQueueFilter{
+		State:  OrderStatePending,
+		Pair:   strings.ToUpper(q.Get("pair")),
+		Side:   q.Get("side"),
+		SortBy: "age",
+		Limit:  defaultOrderQueueSize,
+	}
+	if v := q.Get("state"); v != "" {
+		if v != OrderStatePending && v != OrderStateInProgress {
+			return nil, errors.New("invalid 'state' value, must be pending or inprogress")
+		}
+		filter.State = v
+	}
+	if filter.Side != "" && filter.Side != OrderSideBuy && filter.Side != OrderSideSell {
+		return nil, errors.New("invalid 'side' value, must be buy or sell")
+	}
+	if v := q.Get("sort"); v != "" {
+		if !database.IsValidOrderQueueSort(v) {
+			return nil, errors.New("invalid 'sort' value, must be age or amount")
+		}
+		filter.SortBy = v
+	}
+	// Amounts are in the main currency of their pair, they only compare within a pair
+	if filter.SortBy == "amount" && filter.Pair == "" {
+		return nil, errors.New("sorting by amount requires the 'pair' filter")
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxOrderQueueSize {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", maxOrderQueueSize)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+// ClaimNextOrderHandler HTTP Handler assigns the oldest pending order to the caller, responding 204 when the queue is empty
+func (s *Server) ClaimNextOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ClaimNextOrderHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	order, err := s.store.ClaimNextOrder(principal.User.Id)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	if order == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Printf("Order %v claimed by operator %v", order.Id, principal.User.Id)
+	sendJsonResponse(w, order, http.StatusOK)
+}
+
+// ClaimOrderHandler HTTP Handler assigns a pending order to the caller, moving it to inprogress
+func (s *Server) ClaimOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ClaimOrderHandler")
+	s.processOrder(w, r, func(orderId, operatorUserId int) (*database.Order, error) {
+		return s.store.ClaimOrder(orderId, operatorUserId)
+	})
+}
+
+// ConfirmOrderTransferHandler HTTP Handler records that the transfer of the customer arrived to the house
+func (s *Server) ConfirmOrderTransferHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ConfirmOrderTransferHandler")
+	s.processOrder(w, r, func(orderId, operatorUserId int) (*database.Order, error) {
+		return s.store.ConfirmOrderTransfer(orderId, operatorUserId)
+	})
+}
+
+type orderPayoutRequest struct {
+	Reference string `json:"reference"` // operation number of the transfer to the destination account
+}
+
+func (o *orderPayoutRequest) Validate() error {
+	o.Reference = strings.TrimSpace(o.Reference)
+	if o.Reference == "" {
+		return errors.New("missing required 'reference' field")
+	}
+	if len(o.Reference) > maxPayoutReferenceLen {
+		return fmt.Errorf("'reference' cannot be longer than %d characters", maxPayoutReferenceLen)
+	}
+	return nil
+}
+
+// RecordOrderPayoutHandler HTTP Handler records the reference of the transfer paying the counter amount to the customer
+func (s *Server) RecordOrderPayoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("RecordOrderPayoutHandler")
+	payoutRequest := &orderPayoutRequest{}
+	err := s.DecodeJsonBody(w, r, payoutRequest)
+	if err == nil {
+		err = payoutRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	s.processOrder(w, r, func(orderId, operatorUserId int) (*database.Order, error) {
+		entry := &database.AuditEntry{
+			ActorUserId: operatorUserId,
+			Action:      "order.payout_recorded",
+			Details: map[string]interface{}{
+				"order_id":  orderId,
+				"reference": payoutRequest.Reference,
+			},
+		}
+		return s.store.RecordOrderPayout(orderId, operatorUserId, payoutRequest.Reference, entry)
+	})
+}
+
+// FinishOrderHandler HTTP Handler finishes an order whose transfer and payout are recorded
+func (s *Server) FinishOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("FinishOrderHandler")
+	s.processOrder(w, r, func(orderId, operatorUserId int) (*database.Order, error) {
+		return s.store.FinishOrder(orderId, operatorUserId)
+	})
+}
+
+type rejectOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (o *rejectOrderRequest) Validate() error {
+	o.Reason = strings.TrimSpace(o.Reason)
+	if o.Reason == "" {
+		return errors.New("missing required 'reason' field")
+	}
+	return nil
+}
+
+// RejectOrderHandler HTTP Handler rejects a pending order, or an in progress order claimed by the caller
+func (s *Server) RejectOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("RejectOrderHandler")
+	rejectRequest := &rejectOrderRequest{}
+	err := s.DecodeJsonBody(w, r, rejectRequest)
+	if err == nil {
+		err = rejectRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	s.processOrder(w, r, func(orderId, operatorUserId int) (*database.Order, error) {
+		return s.store.RejectOrder(orderId, operatorUserId, rejectRequest.Reason)
+	})
+}
+
+// processOrder runs a step of the operator workflow on the order of the {orderId} URL parameter and sends the updated order
+func (s *Server) processOrder(w http.ResponseWriter, r *http.Request, step func(orderId, operatorUserId int) (*database.Order, error)) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	orderId, ok := orderIdParam(w, r)
+	if !ok {
+		return
+	}
+	order, err := step(orderId, principal.User.Id)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	log.Printf("Order %v processed by operator %v, state %v", order.Id, principal.User.Id, order.State)
+	sendJsonResponse(w, order, http.StatusOK)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseOrderQueueFilter(t *testing.T) {
+	parse := func(query string) (*database.OrderQueueFilter, error) {
+		return parseOrderQueueFilter(newRequest(http.MethodGet, "/api/v1/orders/queue?"+query, "", nil))
+	}
+	filter, err := parse("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.State != OrderStatePending || filter.SortBy != "age" || filter.Limit != defaultOrderQueueSize {
+		t.Errorf("unexpected default filter %+v", filter)
+	}
+	filter, err = parse(url.Values{"pair": {"usd-pen"}, "sort": {"amount"}, "state": {"inprogress"}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Pair != "USD-PEN" || filter.SortBy != "amount" || filter.State != OrderStateInProgress {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+
+	invalid := []string{
+		// Amounts of different pairs are in different currencies
+		"sort=amount",
+		"sort=rate",
+		"state=finished",
+		"side=swap",
+		"limit=0",
+		"limit=101",
+	}
+	for _, query := range invalid {
+		if filter, err := parse(query); err == nil {
+			t.Errorf("parseOrderQueueFilter(%q) = %+v, want an error", query, filter)
+		}
+	}
+}
+
+func TestOrderProcessingHandlers(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	ana, luis := store.users[7], store.users[8]
+	ana.Role, luis.Role = RoleOperator, RoleOperator
+	store.orders = []*database.Order{
+		{Id: 1, UserId: 8, State: OrderStatePending},
+		{Id: 2, UserId: 8, State: OrderStatePending},
+		{Id: 3, UserId: 8, State: OrderStatePending},
+		{Id: 4, UserId: 7, State: OrderStatePending},
+	}
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Post("/api/v1/orders/claim", s.ClaimNextOrderHandler)
+	r.Post("/api/v1/orders/{orderId}/claim", s.ClaimOrderHandler)
+	r.Post("/api/v1/orders/{orderId}/payout", s.RecordOrderPayoutHandler)
+	r.Post("/api/v1/orders/{orderId}/finish", s.FinishOrderHandler)
+	post := func(operator *database.User, target, body string) (int, *database.Order) {
+		w := serve(r, newRequest(http.MethodPost, target, accessToken(t, s, operator), strings.NewReader(body)))
+		order := &database.Order{}
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(order); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, order
+	}
+
+	// Order 2 is being claimed by another operator, the next claim skips it instead of waiting
+	store.lockedOrders[2] = true
+	if status, order := post(ana, "/api/v1/orders/claim", ""); status != http.StatusOK || order.Id != 1 || order.State != OrderStateInProgress {
+		t.Fatalf("the first claim got status %v and order %+v, want order 1", status, order)
+	}
+	if status, order := post(ana, "/api/v1/orders/claim", ""); status != http.StatusOK || order.Id != 3 {
+		t.Fatalf("the second claim got status %v and order %+v, want order 3", status, order)
+	}
+	// Only the locked order and an order of the operator are left
+	if status, _ := post(ana, "/api/v1/orders/claim", ""); status != http.StatusNoContent {
+		t.Errorf("claiming from a queue of locked orders got status %v, want %v", status, http.StatusNoContent)
+	}
+	if status, _ := post(luis, "/api/v1/orders/2/claim", ""); status != http.StatusConflict {
+		t.Errorf("claiming a locked order got status %v, want %v", status, http.StatusConflict)
+	}
+	if status, _ := post(luis, "/api/v1/orders/1/claim", ""); status != http.StatusConflict {
+		t.Errorf("claiming a claimed order got status %v, want %v", status, http.StatusConflict)
+	}
+
+	if status, _ := post(ana, "/api/v1/orders/1/finish", ""); status != http.StatusConflict {
+		t.Errorf("finishing an order without payout got status %v, want %v", status, http.StatusConflict)
+	}
+	if status, _ := post(ana, "/api/v1/orders/1/payout", `{"reference": " "}`); status != http.StatusBadRequest {
+		t.Errorf("a payout without reference got status %v, want %v", status, http.StatusBadRequest)
+	}
+	if status, order := post(ana, "/api/v1/orders/1/payout", `{"reference": "OP-123"}`); status != http.StatusOK || order.PayoutReference != "OP-123" {
+		t.Fatalf("recording the payout got status %v and order %+v", status, order)
+	}
+	if len(store.audit) != 1 || store.audit[0].Action != "order.payout_recorded" || store.audit[0].ActorUserId != ana.Id ||
+		store.audit[0].Details["reference"] != "OP-123" || store.audit[0].Details["order_id"] != 1 {
+		t.Errorf("got audit entries %+v, want the payout of order 1", store.audit)
+	}
+	if status, order := post(ana, "/api/v1/orders/1/finish", ""); status != http.StatusOK || order.State != OrderStateFinished {
+		t.Errorf("finishing the order got status %v and order %+v", status, order)
+	}
+}
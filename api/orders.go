@@ -0,0 +1,495 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Values of the order_state table
+const (
+	OrderStatePending    = "pending"    // placed, waiting for the transfer of the customer
+	OrderStateInProgress = "inprogress" // an operator is verifying the transfer and paying the counter amount
+	OrderStateFinished   = "finished"   // the house paid the counter amount
+	OrderStateCancelled  = "cancelled"  // cancelled by the customer before it was taken
+	OrderStateExpired    = "expired"    // the customer did not transfer within the transfer window
+	OrderStateRejected   = "rejected"   // rejected by an operator, e.g. the transfer never arrived or did not match
+)
+
+// orderStateTransitions lists the states each state can move to, finished, cancelled, expired and rejected are final
+var orderStateTransitions = map[string][]string{
+	OrderStatePending:    {OrderStateInProgress, OrderStateCancelled, OrderStateExpired, OrderStateRejected},
+	OrderStateInProgress: {OrderStateFinished, OrderStateRejected},
+	OrderStateFinished:   {},
+	OrderStateCancelled:  {},
+	OrderStateExpired:    {},
+	OrderStateRejected:   {},
+}
+
+const (
+	defaultOrdersPageSize = 20
+	maxOrdersPageSize     = 100
+	orderExpiredReason    = "transfer not received within the transfer window"
+)
+
+// errInvalidOrder reports orders whose bank accounts do not match the terms of the quote
+var errInvalidOrder = errors.New("invalid order")
+
+func isValidOrderState(state string) bool {
+	_, ok := orderStateTransitions[state]
+	return ok
+}
+
+func canTransitionOrderState(from, to string) bool {
+	for _, state := range orderStateTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+type orderRequest struct {
+	QuoteToken               string `json:"quote_token"`
+	SourceBankAccountId      int    `json:"source_bank_account_id"`
+	DestinationBankAccountId int    `json:"destination_bank_account_id"`
+	CompanyId                *int   `json:"company_id"` // places the order on behalf of a company represented by the caller
+}
+
+func (o *orderRequest) Validate() error {
+	if o.QuoteToken == "" {
+		return errors.New("missing required 'quote_token' field")
+	}
+	if o.SourceBankAccountId <= 0 {
+		return errors.New("missing required 'source_bank_account_id' field")
+	}
+	if o.DestinationBankAccountId <= 0 {
+		return errors.New("missing required 'destination_bank_account_id' field")
+	}
+	if o.SourceBankAccountId == o.DestinationBankAccountId {
+		return errors.New("'source_bank_account_id' and 'destination_bank_account_id' must be different")
+	}
+	return nil
+}
+
+// CreateOrderHandler HTTP Handler places an order with the terms of a quote of the caller, the customer sends the
+// amount from the source account and receives the counter amount in the destination account
+func (s *Server) CreateOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateOrderHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	oRequest := &orderRequest{}
+	err := s.DecodeJsonBody(w, r, oRequest)
+	if err == nil {
+		err = oRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if principal.User.State != UserStateActive {
+		errRes := ErrorMessage{
+			Message: "Register a bank account before placing orders",
+			Error:   "user is " + principal.User.State,
+		}
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+	if oRequest.CompanyId != nil {
+		isRepresentative, err := s.store.IsCompanyRepresentative(*oRequest.CompanyId, principal.User.Id)
+		if err != nil {
+			sendOrderError(w, err)
+			return
+		}
+		if !isRepresentative {
+			log.Printf("User %v is not a representative of company %v", principal.User.Id, *oRequest.CompanyId)
+			sendForbidden(w)
+			return
+		}
+	}
+
+	quote, err := s.verifyQuote(oRequest.QuoteToken, principal.User.Id)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	rate, err := s.store.GetRate(quote.Pair)
+	if err != nil || rate == nil {
+		log.Printf("Error getting exchange rate %v: %v", quote.Pair, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	// buy: the customer sends the main currency and receives the secondary one, sell: the other way around
+	sourceCurrency, destinationCurrency := rate.CurrencyMain, rate.CurrencySecondary
+	if quote.Side == OrderSideSell {
+		sourceCurrency, destinationCurrency = rate.CurrencySecondary, rate.CurrencyMain
+	}
+	err = s.checkOrderBankAccount("source_bank_account_id", oRequest.SourceBankAccountId, sourceCurrency, principal.User.Id, oRequest.CompanyId)
+	if err == nil {
+		err = s.checkOrderBankAccount("destination_bank_account_id", oRequest.DestinationBankAccountId, destinationCurrency, principal.User.Id, oRequest.CompanyId)
+	}
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+
+	uifReport, err := s.requiresUifReport(quote, rate)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+
+	order := &database.Order{
+		UserId:                   principal.User.Id,
+		CompanyId:                oRequest.CompanyId,
+		QuoteId:                  quote.Id,
+		SourceBankAccountId:      oRequest.SourceBankAccountId,
+		DestinationBankAccountId: oRequest.DestinationBankAccountId,
+		ExpiresAt:                time.Now().UTC().Add(time.Duration(s.Config.OrderTransferWindowMins) * time.Minute),
+		UifReport:                uifReport,
+	}
+	err = s.store.CreateOrder(order)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	log.Printf("Order %v placed by user %v: %v %v %v at %v", order.Id, order.UserId, order.Side, order.AmountMain, order.Pair, order.Rate)
+	if order.UifReport {
+		log.Printf("Order %v flagged for the UIF report", order.Id)
+	}
+	sendJsonResponse(w, order, http.StatusCreated)
+}
+
+// requiresUifReport tells whether the quote is a single operation above UifThresholdUsd dollars, which the UIF requires
+// to report. Amounts of pairs without USD are converted through the USD rates of their currencies, at the price giving
+// the most dollars. Quotes that cannot be converted are flagged, for the compliance team to review them.
+func (s *Server) requiresUifReport(quote *database.Quote, rate *database.ExchangeRate) (bool, error) {
+	threshold := money.Amount(s.Config.UifThresholdUsd) * 100
+	switch "USD" {
+	case rate.CurrencyMain:
+		return quote.AmountMain > threshold, nil
+	case rate.CurrencySecondary:
+		return quote.AmountSecondary > threshold, nil
+	}
+
+	rates, err := s.store.GetRates()
+	if err != nil {
+		return false, err
+	}
+	converted := false
+	amounts := map[string]money.Amount{rate.CurrencyMain: quote.AmountMain, rate.CurrencySecondary: quote.AmountSecondary}
+	for currency, amount := range amounts {
+		usd, ok, err := toUsd(rates, currency, amount)
+		if errors.Is(err, money.ErrOverflow) {
+			return true, nil
+		}
+		if ok {
+			if usd > threshold {
+				return true, nil
+			}
+			converted = true
+		}
+	}
+	if !converted {
+		log.Printf("No USD rate to convert quote %v of pair %v, flagging it for the UIF report", quote.Id, quote.Pair)
+	}
+	return !converted, nil
+}
+
+// toUsd converts amount of currency to dollars through a pair of currency and USD, ok is false if there is none
+func toUsd(rates []*database.ExchangeRate, currency string, amount money.Amount) (usd money.Amount, ok bool, err error) {
+	for _, r := range rates {
+		switch {
+		case r.CurrencyMain == "USD" && r.CurrencySecondary == currency:
+			// USD-XXX prices are units of currency per dollar, the lowest one gives the most dollars
+			price := r.BuyPrice
+			if r.SellPrice < price {
+				price = r.SellPrice
+			}
+			usd, err = amount.DivRate(price, true)
+			return usd, true, err
+		case r.CurrencyMain == currency && r.CurrencySecondary == "USD":
+			price := r.BuyPrice
+			if r.SellPrice > price {
+				price = r.SellPrice
+			}
+			usd, err = amount.MulRate(price, true)
+			return usd, true, err
+		}
+	}
+	return 0, false, nil
+}
+
+// checkOrderBankAccount verifies that the account exists, belongs to the user (or to the company when companyId is set)
+// and holds currency. Accounts of other owners are reported as missing to not disclose them.
+func (s *Server) checkOrderBankAccount(field string, accountId int, currency string, userId int, companyId *int) error {
+	account, err := s.store.GetBankAccount(accountId)
+	if err != nil {
+		return err
+	}
+	owned := account != nil && account.CompanyId == nil && account.UserId == userId
+	if companyId != nil {
+		owned = account != nil && account.CompanyId != nil && *account.CompanyId == *companyId
+	}
+	if !owned {
+		return fmt.Errorf("%w: invalid '%s' value", database.ErrBankAccountNotFound, field)
+	}
+	if account.CurrencyType != currency {
+		return fmt.Errorf("%w: '%s' must be a %v account", errInvalidOrder, field, currency)
+	}
+	return nil
+}
+
+type ordersPageResponse struct {
+	Orders     []*database.Order `json:"orders"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// GetOrdersHandler HTTP Handler returns a page of the orders placed by the caller, newest first, or of the company of
+// the 'company_id' query parameter. It accepts 'state', 'limit' and the 'cursor' of the previous page.
+func (s *Server) GetOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseOrderFilter(r)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if filter.CompanyId != nil {
+		isRepresentative, err := s.store.IsCompanyRepresentative(*filter.CompanyId, principal.User.Id)
+		if err != nil {
+			sendOrderError(w, err)
+			return
+		}
+		if !isRepresentative && !principal.HasPermission(PermOrdersRead) {
+			log.Printf("User %v is not allowed to list orders of company %v", principal.User.Id, *filter.CompanyId)
+			sendForbidden(w)
+			return
+		}
+	} else {
+		filter.UserId = &principal.User.Id
+	}
+
+	page, err := s.store.GetOrders(filter)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	response := ordersPageResponse{Orders: page.Orders}
+	if page.NextBeforeId > 0 {
+		response.NextCursor = strconv.Itoa(page.NextBeforeId)
+	}
+	sendJsonResponse(w, response, http.StatusOK)
+}
+
+// parseOrderFilter builds the orders listing filter from the query parameters: company_id, state, limit and cursor
+func parseOrderFilter(r *http.Request) (*database.OrderFilter, error) {
+	q := r.URL.Query()
+	filter := &database.OrderFilter{
+		State: q.Get("state"),
+		Limit: defaultOrdersPageSize,
+	}
+	if filter.State != "" && !isValidOrderState(filter.State) {
+		return nil, errors.New("invalid 'state' value")
+	}
+	if v := q.Get("company_id"); v != "" {
+		companyId, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.New("invalid 'company_id' value")
+		}
+		filter.CompanyId = &companyId
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxOrdersPageSize {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", maxOrdersPageSize)
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		beforeId, err := strconv.Atoi(v)
+		if err != nil || beforeId < 1 {
+			return nil, errors.New("invalid 'cursor' value")
+		}
+		filter.BeforeId = beforeId
+	}
+	return filter, nil
+}
+
+// GetOrderHandler HTTP Handler returns an order
+func (s *Server) GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.authorizeOrderAccess(w, r, PermOrdersRead)
+	if !ok {
+		return
+	}
+	sendJsonResponse(w, order, http.StatusOK)
+}
+
+type cancelOrderRequest struct {
+	Reason string `json:"reason"` // optional
+}
+
+// CancelOrderHandler HTTP Handler cancels an order of the caller, only pending orders can be cancelled
+func (s *Server) CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CancelOrderHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	// Cancelling is a decision of the customer, staff permissions do not grant it
+	order, ok := s.authorizeOrderAccess(w, r, "")
+	if !ok {
+		return
+	}
+	cancelRequest := &cancelOrderRequest{}
+	if r.ContentLength != 0 {
+		if err := s.DecodeJsonBody(w, r, cancelRequest); err != nil {
+			errRes := ErrorMessage{
+				Message: "Invalid request",
+				Error:   err.Error(),
+			}
+			sendJsonResponse(w, errRes, http.StatusBadRequest)
+			return
+		}
+	}
+	s.changeOrderState(w, order, OrderStateCancelled, &principal.User.Id, cancelRequest.Reason)
+}
+
+// changeOrderState moves the order to state to enforcing the state machine, then sends the updated order
+func (s *Server) changeOrderState(w http.ResponseWriter, order *database.Order, to string, actorUserId *int, reason string) {
+	if !canTransitionOrderState(order.State, to) {
+		errRes := ErrorMessage{
+			Message: "Invalid state transition",
+			Error:   "order cannot move from '" + order.State + "' to '" + to + "'",
+		}
+		sendJsonResponse(w, errRes, http.StatusConflict)
+		return
+	}
+	err := s.store.UpdateOrderState(order.Id, order.State, to, actorUserId, reason)
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	log.Printf("Order %v moved from %v to %v", order.Id, order.State, to)
+	updated, err := s.store.GetOrder(order.Id)
+	if err != nil || updated == nil {
+		sendOrderError(w, database.ErrOrderNotFound)
+		return
+	}
+	sendJsonResponse(w, updated, http.StatusOK)
+}
+
+// authorizeOrderAccess loads the order of the {orderId} URL parameter, allowing the user who placed it, the
+// representatives of its company and callers granted perm, otherwise it sends the error response
+func (s *Server) authorizeOrderAccess(w http.ResponseWriter, r *http.Request, perm Permission) (*database.Order, bool) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return nil, false
+	}
+	orderId, ok := orderIdParam(w, r)
+	if !ok {
+		return nil, false
+	}
+	order, err := s.store.GetOrder(orderId)
+	if err != nil {
+		sendOrderError(w, err)
+		return nil, false
+	}
+	if order == nil {
+		sendOrderError(w, database.ErrOrderNotFound)
+		return nil, false
+	}
+	if order.UserId == principal.User.Id || (perm != "" && principal.HasPermission(perm)) {
+		return order, true
+	}
+	if order.CompanyId != nil {
+		isRepresentative, err := s.store.IsCompanyRepresentative(*order.CompanyId, principal.User.Id)
+		if err != nil {
+			sendOrderError(w, err)
+			return nil, false
+		}
+		if isRepresentative {
+			return order, true
+		}
+	}
+	// Orders of other customers are reported as missing to not disclose them
+	log.Printf("User %v is not allowed to access order %v", principal.User.Id, orderId)
+	sendOrderError(w, database.ErrOrderNotFound)
+	return nil, false
+}
+
+// orderIdParam parses the {orderId} URL parameter, otherwise it sends the error response
+func orderIdParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	orderId, err := strconv.Atoi(chi.URLParam(r, "orderId"))
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid order id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
+	}
+	return orderId, true
+}
+
+func sendOrderError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrOrderNotFound), errors.Is(err, database.ErrCompanyNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, ErrQuoteInvalid), errors.Is(err, errInvalidOrder), errors.Is(err, database.ErrBankAccountNotFound):
+		errRes.Message = "Invalid request"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+	case errors.Is(err, ErrQuoteExpired), errors.Is(err, ErrQuoteConsumed), errors.Is(err, database.ErrQuoteUnavailable):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	case errors.Is(err, database.ErrOrderLocked), errors.Is(err, database.ErrTransferNotReceived), errors.Is(err, database.ErrPayoutMissing):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	case errors.Is(err, database.ErrOrderNotAssigned), errors.Is(err, database.ErrOwnOrder):
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+	case errors.Is(err, database.ErrOrderStateConflict):
+		errRes.Message = "Invalid state transition"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	case errors.Is(err, database.ErrLimitExceeded):
+		var limitError *database.LimitExceededError
+		if errors.As(err, &limitError) {
+			errRes.Code = limitError.Code()
+		}
+		errRes.Message = "Transaction limit exceeded"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusUnprocessableEntity)
+	case errors.Is(err, database.ErrUserStateConflict):
+		errRes.Message = "Register a bank account before placing orders"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+	default:
+		log.Printf("Error in order operation: %v", err)
+		errRes.Message = "Service unavailable"
+		errRes.Error = err.Error()
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanTransitionOrderState(t *testing.T) {
+	states := []string{
+		OrderStatePending, OrderStateInProgress, OrderStateFinished,
+		OrderStateCancelled, OrderStateExpired, OrderStateRejected,
+	}
+	allowed := map[[2]string]bool{
+		{OrderStatePending, OrderStateInProgress}:  true,
+		{OrderStatePending, OrderStateCancelled}:   true,
+		{OrderStatePending, OrderStateExpired}:     true,
+		{OrderStatePending, OrderStateRejected}:    true,
+		{OrderStateInProgress, OrderStateFinished}: true,
+		{OrderStateInProgress, OrderStateRejected}: true,
+	}
+	for _, from := range states {
+		for _, to := range states {
+			want := allowed[[2]string{from, to}]
+			if got := canTransitionOrderState(from, to); got != want {
+				t.Errorf("canTransitionOrderState(%q, %q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	for _, state := range states {
+		if !isValidOrderState(state) {
+			t.Errorf("isValidOrderState(%q) = false", state)
+		}
+		if canTransitionOrderState("unknown", state) || canTransitionOrderState(state, "unknown") {
+			t.Errorf("unexpected transition between 'unknown' and %q", state)
+		}
+	}
+	if isValidOrderState("") || isValidOrderState("Pending") {
+		t.Error("isValidOrderState accepted an unknown state")
+	}
+}
+
+// newOrdersTestServer returns a server where user 7 is active, has a PEN and a USD account (1 and 2) and represents
+// company 3, which has its own PEN and USD accounts (3 and 4). Luis, user 8, has a PEN account (5).
+func newOrdersTestServer(t *testing.T) (*Server, *fakeStore) {
+	t.Helper()
+	companyId := 3
+	store := newFakeStore(
+		&database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, State: UserStateActive},
+		&database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer, State: UserStateActive},
+	)
+	store.companies[3] = &database.Company{Id: 3, Representatives: []*database.CompanyRepresentative{{UserId: 7}}}
+	store.bankAccounts = []*database.BankAccount{
+		{Id: 1, UserId: 7, CurrencyType: "PEN"},
+		{Id: 2, UserId: 7, CurrencyType: "USD"},
+		{Id: 3, UserId: 7, CompanyId: &companyId, CurrencyType: "PEN"},
+		{Id: 4, UserId: 7, CompanyId: &companyId, CurrencyType: "USD"},
+		{Id: 5, UserId: 8, CurrencyType: "PEN"},
+	}
+	return newTestServer(t, store), store
+}
+
+// newQuoteToken stores a quote of the user selling 100 USD at 3.750 and returns its token
+func newQuoteToken(t *testing.T, s *Server, store *fakeStore, userId int) string {
+	t.Helper()
+	now := time.Now().Truncate(time.Second)
+	quote := &database.Quote{Id: fmt.Sprintf("q%d", len(store.quotes)+1), UserId: userId, Pair: "USD-PEN", Side: OrderSideSell, Rate: 3750,
+		AmountMain: 10000, AmountSecondary: 37500, CreatedAt: now, ExpiresAt: now.Add(5 * time.Minute)}
+	store.quotes[quote.Id] = quote
+	token, err := s.signQuote(quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestCreateOrderHandler(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	h := s.AuthMiddleware(http.HandlerFunc(s.CreateOrderHandler))
+	placeOrder := func(userId int, body string) *httptest.ResponseRecorder {
+		return serve(h, newRequest(http.MethodPost, "/api/v1/orders", accessToken(t, s, store.users[userId]), strings.NewReader(body)))
+	}
+	orderBody := func(token string, source, destination int, companyId string) string {
+		return fmt.Sprintf(`{"quote_token": %q, "source_bank_account_id": %d, "destination_bank_account_id": %d, "company_id": %s}`, token, source, destination, companyId)
+	}
+
+	// Selling USD, the customer sends PEN and receives USD
+	token := newQuoteToken(t, s, store, 7)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"source account of another user", orderBody(token, 5, 2, "null"), http.StatusBadRequest},
+		{"unknown destination account", orderBody(token, 1, 9, "null"), http.StatusBadRequest},
+		{"currencies swapped", orderBody(token, 2, 1, "null"), http.StatusBadRequest},
+		{"company accounts for a personal order", orderBody(token, 3, 4, "null"), http.StatusBadRequest},
+		{"personal accounts for a company order", orderBody(token, 1, 2, "3"), http.StatusBadRequest},
+		{"company not represented", orderBody(token, 3, 4, "4"), http.StatusForbidden},
+		{"quote of another user", orderBody(newQuoteToken(t, s, store, 8), 1, 2, "null"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := placeOrder(7, tt.body); res.Code != tt.status {
+				t.Errorf("got status %v, want %v: %s", res.Code, tt.status, res.Body)
+			}
+		})
+	}
+	if len(store.orders) != 0 || store.quotes["q1"].ConsumedAt != nil {
+		t.Fatal("a rejected order consumed its quote")
+	}
+
+	res := placeOrder(7, orderBody(token, 1, 2, "null"))
+	if res.Code != http.StatusCreated {
+		t.Fatalf("got status %v: %s", res.Code, res.Body)
+	}
+	order := &database.Order{}
+	if err := json.NewDecoder(res.Body).Decode(order); err != nil {
+		t.Fatal(err)
+	}
+	if order.QuoteId != "q1" || order.Side != OrderSideSell || order.Rate != 3750 || order.AmountMain != 10000 || order.AmountSecondary != 37500 {
+		t.Errorf("the order does not have the terms of its quote: %+v", order)
+	}
+	if store.quotes["q1"].ConsumedAt == nil {
+		t.Error("placing the order did not consume the quote")
+	}
+	// A quote places a single order
+	if res := placeOrder(7, orderBody(token, 1, 2, "null")); res.Code != http.StatusConflict {
+		t.Errorf("reusing a quote got status %v, want %v: %s", res.Code, http.StatusConflict, res.Body)
+	}
+
+	if res := placeOrder(7, orderBody(newQuoteToken(t, s, store, 7), 3, 4, "3")); res.Code != http.StatusCreated {
+		t.Errorf("a company order got status %v: %s", res.Code, res.Body)
+	}
+	store.users[8].State = UserStateRegistered
+	if res := placeOrder(8, orderBody(newQuoteToken(t, s, store, 8), 5, 2, "null")); res.Code != http.StatusForbidden {
+		t.Errorf("an order of a registered user got status %v, want %v", res.Code, http.StatusForbidden)
+	}
+}
+
+func TestDeleteUserWithOpenOrders(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	store.orders = []*database.Order{{Id: 1, UserId: 7, State: OrderStateInProgress}, {Id: 2, UserId: 7, State: OrderStateFinished}}
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Delete("/api/v1/users/{id}", s.DeleteUserHandler)
+	del := func() int {
+		return serve(r, newRequest(http.MethodDelete, "/api/v1/users/7", accessToken(t, s, store.users[7]), nil)).Code
+	}
+
+	if status := del(); status != http.StatusConflict {
+		t.Errorf("closing an account with an order in progress got status %v, want %v", status, http.StatusConflict)
+	}
+	if _, deleted := store.deletedUsers[7]; deleted {
+		t.Fatal("the user was deleted with an order in progress")
+	}
+	store.orders[0].State = OrderStateFinished
+	if status := del(); status != http.StatusNoContent {
+		t.Errorf("closing an account with finished orders got status %v", status)
+	}
+}
+
+func TestRequiresUifReport(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	s.Config.UifThresholdUsd = 10000
+	store.rates["EUR-USD"] = &database.ExchangeRate{Pair: "EUR-USD", CurrencyMain: "EUR", CurrencySecondary: "USD", BuyPrice: 1080, SellPrice: 1100}
+	pair := func(main, secondary string) *database.ExchangeRate {
+		if rate, ok := store.rates[main+"-"+secondary]; ok {
+			return rate
+		}
+		return &database.ExchangeRate{Pair: main + "-" + secondary, CurrencyMain: main, CurrencySecondary: secondary}
+	}
+
+	tests := []struct {
+		name            string
+		rate            *database.ExchangeRate
+		amountMain      money.Amount
+		amountSecondary money.Amount
+		want            bool
+	}{
+		{"dollars at the threshold", pair("USD", "PEN"), 1000000, 3750000, false},
+		{"dollars above the threshold", pair("USD", "PEN"), 1000001, 3750004, true},
+		{"dollars as the secondary currency", pair("EUR", "USD"), 910000, 1000100, true},
+		// 9,200 EUR are 10,120 USD at the highest EUR-USD price, even if the soles are below the threshold
+		{"euros converted at the price giving the most dollars", pair("EUR", "PEN"), 920000, 3500000, true},
+		{"soles converted at the lowest USD-PEN price", pair("GBP", "PEN"), 800000, 3750000, true},
+		{"both converted below the threshold", pair("EUR", "PEN"), 900000, 3600000, false},
+		// Pairs without USD rates cannot be checked, they are left to the compliance team
+		{"no USD rate", pair("GBP", "CLP"), 100, 10000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := &database.Quote{Id: "q1", Pair: tt.rate.Pair, AmountMain: tt.amountMain, AmountSecondary: tt.amountSecondary}
+			got, err := s.requiresUifReport(quote, tt.rate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendOrderError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{database.ErrOrderNotFound, http.StatusNotFound},
+		// The company of the order can be removed while the order is placed
+		{fmt.Errorf("creating order: %w", database.ErrCompanyNotFound), http.StatusNotFound},
+		{database.ErrBankAccountNotFound, http.StatusBadRequest},
+		{database.ErrQuoteUnavailable, http.StatusConflict},
+		{database.ErrOrderStateConflict, http.StatusConflict},
+		{&database.LimitExceededError{Period: database.LimitPeriodDaily, Currency: "USD"}, http.StatusUnprocessableEntity},
+		{database.ErrOwnOrder, http.StatusForbidden},
+		{errors.New("internal database error"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		sendOrderError(w, tt.err)
+		if w.Code != tt.status {
+			t.Errorf("sendOrderError(%v) sent status %v, want %v", tt.err, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	quoteTokenType = "quote"
+	// Order sides, values of the order_type table, from the point of view of the exchange house
+	OrderSideBuy  = "buy"  // the house buys the main currency: the customer sends main and receives secondary
+	OrderSideSell = "sell" // the house sells the main currency: the customer sends secondary and receives main
+	// maxQuoteAmount keeps amounts within the NUMERIC(14, 2) columns
+	maxQuoteAmount money.Amount = 99999999999999
+)
+
+var (
+	ErrQuoteInvalid  = errors.New("invalid quote")
+	ErrQuoteExpired  = errors.New("quote has expired, please request a new one")
+	ErrQuoteConsumed = errors.New("quote has already been used")
+)
+
+// quoteClaims are the claims of quote tokens: the quote id ('jti'), its owner ('sub') and the locked terms
+type quoteClaims struct {
+	jwt.RegisteredClaims
+	TokenType       string       `json:"token_type"`
+	Pair            string       `json:"pair"`
+	Side            string       `json:"side"`
+	Rate            money.Rate   `json:"rate"`
+	AmountMain      money.Amount `json:"amount_main"`
+	AmountSecondary money.Amount `json:"amount_secondary"`
+}
+
+type quoteRequest struct {
+	Pair           string        `json:"pair"`
+	Side           string        `json:"side"`
+	Amount         *money.Amount `json:"amount"`
+	AmountCurrency string        `json:"amount_currency"` // either currency of the pair
+}
+
+func (q *quoteRequest) Validate() error {
+	q.Pair = strings.ToUpper(strings.TrimSpace(q.Pair))
+	q.AmountCurrency = strings.ToUpper(strings.TrimSpace(q.AmountCurrency))
+	if q.Pair == "" {
+		return errors.New("missing required 'pair' field")
+	}
+	if q.Side != OrderSideBuy && q.Side != OrderSideSell {
+		return errors.New("invalid 'side' value, must be buy or sell")
+	}
+	if q.Amount == nil {
+		return errors.New("missing required 'amount' field")
+	}
+	if *q.Amount <= 0 || *q.Amount > maxQuoteAmount {
+		return errors.New("invalid 'amount' value, it must be positive")
+	}
+	if q.AmountCurrency == "" {
+		return errors.New("missing required 'amount_currency' field")
+	}
+	return nil
+}
+
+type quoteResponse struct {
+	*database.Quote
+	Token string `json:"token"` // signed quote, orders must send it back
+}
+
+// CreateQuoteHandler HTTP Handler locks the current price of a pair for the caller during the minimum valid time of the pair
+func (s *Server) CreateQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateQuoteHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	qRequest := &quoteRequest{}
+	err := s.DecodeJsonBody(w, r, qRequest)
+	if err == nil {
+		err = qRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	rate, err := s.store.GetRate(qRequest.Pair)
+	if err != nil {
+		log.Printf("Error getting exchange rate %v: %v", qRequest.Pair, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if rate == nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "unknown 'pair' value",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	quote, err := newQuote(principal.User.Id, rate, qRequest)
+	if err == nil {
+		quote.Id, err = newTokenId()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	token, err := s.signQuote(quote)
+	if err == nil {
+		err = s.store.CreateQuote(quote)
+	}
+	if err != nil {
+		log.Printf("Error creating quote: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Quote %v issued to user %v: %v %v at %v", quote.Id, quote.UserId, quote.Side, quote.Pair, quote.Rate)
+	sendJsonResponse(w, quoteResponse{Quote: quote, Token: token}, http.StatusCreated)
+}
+
+// newQuote computes the counter amount of the request at the current price of the side, rounding in favor of the house
+func newQuote(userId int, rate *database.ExchangeRate, q *quoteRequest) (*database.Quote, error) {
+	quote := &database.Quote{
+		UserId: userId,
+		Pair:   rate.Pair,
+		Side:   q.Side,
+		Rate:   rate.BuyPrice,
+	}
+	if q.Side == OrderSideSell {
+		quote.Rate = rate.SellPrice
+	}
+	var err error
+	switch q.AmountCurrency {
+	case rate.CurrencyMain:
+		quote.AmountMain = *q.Amount
+		// buy: the house pays the secondary amount, sell: the customer pays it
+		quote.AmountSecondary, err = quote.AmountMain.MulRate(quote.Rate, q.Side == OrderSideSell)
+	case rate.CurrencySecondary:
+		quote.AmountSecondary = *q.Amount
+		// buy: the customer sends the main amount, sell: the house sends it
+		quote.AmountMain, err = quote.AmountSecondary.DivRate(quote.Rate, q.Side == OrderSideBuy)
+	default:
+		return nil, fmt.Errorf("invalid 'amount_currency' value, must be %v or %v", rate.CurrencyMain, rate.CurrencySecondary)
+	}
+	if err != nil {
+		return nil, errors.New("invalid 'amount' value, it is too large")
+	}
+	if quote.AmountMain <= 0 || quote.AmountSecondary <= 0 {
+		return nil, errors.New("invalid 'amount' value, it is too small to be exchanged")
+	}
+	if quote.AmountMain > maxQuoteAmount || quote.AmountSecondary > maxQuoteAmount {
+		return nil, errors.New("invalid 'amount' value, it is too large")
+	}
+	// Tokens have second precision, so does the stored quote
+	quote.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	quote.ExpiresAt = quote.CreatedAt.Add(time.Duration(rate.MinimumValidTimeMins) * time.Minute)
+	return quote, nil
+}
+
+func (s *Server) signQuote(quote *database.Quote) (string, error) {
+	claims := quoteClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        quote.Id,
+			ExpiresAt: jwt.NewNumericDate(quote.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(quote.CreatedAt),
+			Issuer:    jwtIssuer,
+			Subject:   strconv.Itoa(quote.UserId),
+		},
+		TokenType:       quoteTokenType,
+		Pair:            quote.Pair,
+		Side:            quote.Side,
+		Rate:            quote.Rate,
+		AmountMain:      quote.AmountMain,
+		AmountSecondary: quote.AmountSecondary,
+	}
+	signedToken, err := s.keys.Sign(claims)
+	if err != nil {
+		log.Println("Error signing quote", err)
+		return "", err
+	}
+	return signedToken, nil
+}
+
+// verifyQuote checks the signature of the quote token, that it belongs to userId and that its terms are the
+// persisted ones. It returns ErrQuoteInvalid, ErrQuoteExpired or ErrQuoteConsumed when it cannot be used.
+func (s *Server) verifyQuote(token string, userId int) (*database.Quote, error) {
+	claims := &quoteClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, s.keys.Keyfunc, jwt.WithValidMethods(s.keys.ValidMethods()), jwt.WithIssuer(jwtIssuer))
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrQuoteExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrQuoteInvalid, err)
+	}
+	if claims.TokenType != quoteTokenType || claims.ID == "" || claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: not a quote token", ErrQuoteInvalid)
+	}
+	if claims.Subject != strconv.Itoa(userId) {
+		return nil, fmt.Errorf("%w: quote belongs to another user", ErrQuoteInvalid)
+	}
+
+	quote, err := s.store.GetQuote(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if quote == nil {
+		return nil, fmt.Errorf("%w: quote not found", ErrQuoteInvalid)
+	}
+	if quote.UserId != userId || quote.Pair != claims.Pair || quote.Side != claims.Side || quote.Rate != claims.Rate ||
+		quote.AmountMain != claims.AmountMain || quote.AmountSecondary != claims.AmountSecondary || !quote.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+		return nil, fmt.Errorf("%w: quote terms do not match", ErrQuoteInvalid)
+	}
+	if quote.ConsumedAt != nil {
+		return nil, ErrQuoteConsumed
+	}
+	if !time.Now().Before(quote.ExpiresAt) {
+		return nil, ErrQuoteExpired
+	}
+	return quote, nil
+}
+
+// GetQuoteHandler HTTP Handler returns a quote of the caller
+func (s *Server) GetQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	quote, err := s.store.GetQuote(chi.URLParam(r, "quoteId"))
+	if err != nil {
+		log.Printf("Error getting quote: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	// Quotes of other users are reported as missing to not disclose them
+	if quote == nil || quote.UserId != principal.User.Id {
+		errRes := ErrorMessage{
+			Message: "Quote not found",
+		}
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	sendJsonResponse(w, quote, http.StatusOK)
+}
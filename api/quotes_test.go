@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"github.com/angelmotta/flow-api/database"
+	"testing"
+	"time"
+)
+
+func TestVerifyQuote(t *testing.T) {
+	store := newFakeStore()
+	s := newTestServer(t, store)
+	now := time.Now().Truncate(time.Second)
+	newQuote := func(id string, expiresAt time.Time) *database.Quote {
+		quote := &database.Quote{Id: id, UserId: 7, Pair: "USDPEN", Side: OrderSideSell, Rate: 3750, AmountMain: 10000, AmountSecondary: 37500, CreatedAt: now.Add(-time.Minute), ExpiresAt: expiresAt}
+		store.quotes[id] = quote
+		return quote
+	}
+	sign := func(quote *database.Quote) string {
+		token, err := s.signQuote(quote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	valid := newQuote("q1", now.Add(10*time.Minute))
+	consumed := newQuote("q2", now.Add(10*time.Minute))
+	consumed.ConsumedAt = &now
+	expired := newQuote("q3", now.Add(-time.Second))
+	tampered := func(edit func(q *database.Quote)) string {
+		altered := *valid
+		edit(&altered)
+		return sign(&altered)
+	}
+	access, err := s.generateAccessToken("7", "f1", RoleCustomer, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if quote, err := s.verifyQuote(sign(valid), 7); err != nil || quote.Id != valid.Id {
+		t.Fatalf("verifyQuote of a valid quote = %+v, %v", quote, err)
+	}
+	tests := []struct {
+		name   string
+		token  string
+		userId int
+		want   error
+	}{
+		{"another user", sign(valid), 8, ErrQuoteInvalid},
+		{"altered rate", tampered(func(q *database.Quote) { q.Rate = 3700 }), 7, ErrQuoteInvalid},
+		{"altered amount", tampered(func(q *database.Quote) { q.AmountSecondary = 30000 }), 7, ErrQuoteInvalid},
+		{"altered side", tampered(func(q *database.Quote) { q.Side = OrderSideBuy }), 7, ErrQuoteInvalid},
+		{"extended expiration", tampered(func(q *database.Quote) { q.ExpiresAt = now.Add(time.Hour) }), 7, ErrQuoteInvalid},
+		{"unknown quote", tampered(func(q *database.Quote) { q.Id = "q9" }), 7, ErrQuoteInvalid},
+		{"access token", access, 7, ErrQuoteInvalid},
+		{"malformed token", "not-a-jwt", 7, ErrQuoteInvalid},
+		{"consumed", sign(consumed), 7, ErrQuoteConsumed},
+		{"expired", sign(expired), 7, ErrQuoteExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if quote, err := s.verifyQuote(tt.token, tt.userId); !errors.Is(err, tt.want) {
+				t.Errorf("got %+v, %v, want %v", quote, err, tt.want)
+			}
+		})
+	}
+}
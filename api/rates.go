@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRate keeps prices within the NUMERIC(6, 3) columns of exchange_currency
+const maxRate money.Rate = 999999
+
+const (
+	defaultRateHistoryRange = 24 * time.Hour
+	maxRateHistoryPoints    = 1000
+	maxRateCandles          = 1000
+)
+
+// GetRatesHandler HTTP Handler returns the current prices of every currency pair
+func (s *Server) GetRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := s.store.GetRates()
+	if err != nil {
+		log.Printf("Error getting exchange rates: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	sendJsonResponse(w, rates, http.StatusOK)
+}
+
+// GetRateHandler HTTP Handler returns the current prices of the {pair} URL parameter, e.g. USD-PEN
+func (s *Server) GetRateHandler(w http.ResponseWriter, r *http.Request) {
+	rate, ok := s.getRate(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	sendJsonResponse(w, rate, http.StatusOK)
+}
+
+type updateRateRequest struct {
+	BuyPrice  *money.Rate `json:"buy_price"`
+	SellPrice *money.Rate `json:"sell_price"`
+}
+
+func (u *updateRateRequest) Validate() error {
+	if u.BuyPrice == nil {
+		return errors.New("missing required 'buy_price' field")
+	}
+	if u.SellPrice == nil {
+		return errors.New("missing required 'sell_price' field")
+	}
+	if *u.BuyPrice <= 0 || *u.BuyPrice > maxRate {
+		return errors.New("invalid 'buy_price' value, it must be positive and lower than 1000")
+	}
+	if *u.SellPrice <= 0 || *u.SellPrice > maxRate {
+		return errors.New("invalid 'sell_price' value, it must be positive and lower than 1000")
+	}
+	// The exchange house never sells cheaper than it buys
+	if *u.SellPrice < *u.BuyPrice {
+		return errors.New("'sell_price' cannot be lower than 'buy_price'")
+	}
+	return nil
+}
+
+// UpdateRateHandler HTTP Handler sets the prices of a currency pair, starting a new validity window
+func (s *Server) UpdateRateHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateRateHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	rateRequest := &updateRateRequest{}
+	err := s.DecodeJsonBody(w, r, rateRequest)
+	if err == nil {
+		err = rateRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	previous, ok := s.getRate(w, r)
+	if !ok {
+		return
+	}
+	// Typos like 37.27 instead of 3.727 must not reach customers
+	err = checkRateChange("buy_price", previous.BuyPrice, *rateRequest.BuyPrice, s.Config.RateMaxChangePercent)
+	if err == nil {
+		err = checkRateChange("sell_price", previous.SellPrice, *rateRequest.SellPrice, s.Config.RateMaxChangePercent)
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	rate := *previous
+	rate.BuyPrice = *rateRequest.BuyPrice
+	rate.SellPrice = *rateRequest.SellPrice
+	entry := &database.AuditEntry{
+		ActorUserId: principal.User.Id,
+		Action:      "rate.updated",
+		Details: map[string]interface{}{
+			"pair":                previous.Pair,
+			"previous_buy_price":  previous.BuyPrice.String(),
+			"previous_sell_price": previous.SellPrice.String(),
+			"new_buy_price":       rate.BuyPrice.String(),
+			"new_sell_price":      rate.SellPrice.String(),
+		},
+	}
+	err = s.store.UpdateRate(&rate, previous, entry)
+	if err != nil {
+		if errors.Is(err, database.ErrRateConflict) {
+			errRes := ErrorMessage{
+				Message: err.Error(),
+				Error:   "reload the rate and try again",
+			}
+			sendJsonResponse(w, errRes, http.StatusConflict)
+			return
+		}
+		log.Printf("Error updating exchange rate %v: %v", previous.Pair, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v updated %v to buy %v sell %v", principal.User.Id, rate.Pair, rate.BuyPrice, rate.SellPrice)
+	sendJsonResponse(w, rate, http.StatusOK)
+}
+
+// GetRateHistoryHandler HTTP Handler returns the price changes of a pair between the 'from' and 'to' query parameters
+// (RFC 3339 or YYYY-MM-DD, the last 24 hours by default), starting with the price in effect at 'from'. With 'interval'
+// (minute, hour or day) the changes are aggregated in OHLC candles, otherwise up to 'limit' raw points are returned.
+func (s *Server) GetRateHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	rate, ok := s.getRate(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	to := time.Now().UTC()
+	var err error
+	if value := q.Get("to"); value != "" {
+		if to, err = parseTimeParam(value); err != nil {
+			err = errors.New("invalid 'to' value")
+		}
+	}
+	from := to.Add(-defaultRateHistoryRange)
+	if value := q.Get("from"); err == nil && value != "" {
+		if from, err = parseTimeParam(value); err != nil {
+			err = errors.New("invalid 'from' value")
+		}
+	}
+	if err == nil && !from.Before(to) {
+		err = errors.New("'from' must be before 'to'")
+	}
+	interval := q.Get("interval")
+	limit := maxRateHistoryPoints
+	if err == nil && interval != "" {
+		step, ok := database.RateCandleIntervals[interval]
+		if !ok {
+			err = errors.New("invalid 'interval' value, must be minute, hour or day")
+		} else if to.Sub(from)/step > maxRateCandles {
+			err = fmt.Errorf("too many candles, the range must span at most %d intervals", maxRateCandles)
+		}
+	}
+	if value := q.Get("limit"); err == nil && value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxRateHistoryPoints {
+			err = fmt.Errorf("invalid 'limit' value, must be between 1 and %d", maxRateHistoryPoints)
+		}
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	var history interface{}
+	if interval != "" {
+		history, err = s.store.GetRateCandles(rate.Pair, interval, from, to)
+	} else {
+		history, err = s.store.GetRateHistory(rate.Pair, from, to, limit)
+	}
+	if err != nil {
+		log.Printf("Error getting history of %v: %v", rate.Pair, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	sendJsonResponse(w, history, http.StatusOK)
+}
+
+// checkRateChange rejects prices moving more than maxChangePercent from the previous one
+func checkRateChange(field string, previous, next money.Rate, maxChangePercent int) error {
+	change := next - previous
+	if change < 0 {
+		change = -change
+	}
+	if int64(change)*100 > int64(previous)*int64(maxChangePercent) {
+		return fmt.Errorf("'%s' cannot change more than %d%% from %v in a single update", field, maxChangePercent, previous)
+	}
+	return nil
+}
+
+// getRate loads the rate of the {pair} URL parameter, otherwise it sends the error response
+func (s *Server) getRate(w http.ResponseWriter, r *http.Request) (*database.ExchangeRate, bool) {
+	pair := strings.ToUpper(chi.URLParam(r, "pair"))
+	rate, err := s.store.GetRate(pair)
+	if err != nil {
+		log.Printf("Error getting exchange rate %v: %v", pair, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return nil, false
+	}
+	if rate == nil {
+		errRes := ErrorMessage{
+			Message: "Currency pair not found",
+		}
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+		return nil, false
+	}
+	return rate, true
+}
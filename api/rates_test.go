@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/angelmotta/flow-api/internal/money"
+	"testing"
+)
+
+func TestCheckRateChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous money.Rate
+		next     money.Rate
+		valid    bool
+	}{
+		{"unchanged", 3700, 3700, true},
+		{"up to the limit", 3700, 3885, true}, // 3.700 + 5%
+		{"down to the limit", 3700, 3515, true},
+		{"above the limit", 3700, 3886, false},
+		{"below the limit", 3700, 3514, false},
+		{"to zero", 3700, 0, false},
+		{"from zero", 0, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRateChange("buy_price", tt.previous, tt.next, 5)
+			if tt.valid && err != nil {
+				t.Errorf("checkRateChange(%v, %v) returned %v", tt.previous, tt.next, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("checkRateChange(%v, %v) accepted the change", tt.previous, tt.next)
+			}
+		})
+	}
+}
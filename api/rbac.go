@@ -0,0 +1,111 @@
+package api
+
+import (
+	"log"
+	"net/http"
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+	RoleAuditor  = "auditor"
+)
+
+// Permission is an action a role is allowed to perform on resources not owned by the caller
+type Permission string
+
+const (
+	PermUsersRead        Permission = "users:read"
+	PermUsersList        Permission = "users:list"
+	PermUsersCreate      Permission = "users:create"
+	PermUsersUpdate      Permission = "users:update"
+	PermUsersDelete      Permission = "users:delete"
+	PermUsersManageRoles Permission = "users:manage_roles"
+	PermUsersBlock       Permission = "users:block"
+	PermCompaniesRead    Permission = "companies:read"
+	PermCompaniesUpdate  Permission = "companies:update"
+	PermCatalogManage    Permission = "catalog:manage"
+	PermRatesUpdate      Permission = "rates:update"
+	PermOrdersRead       Permission = "orders:read"
+	PermOrdersProcess    Permission = "orders:process"
+	PermUsersKyc         Permission = "users:kyc"
+	PermUifReportsRead   Permission = "uif_reports:read"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleCustomer: {},
+	RoleOperator: {PermUsersRead, PermUsersList, PermUsersBlock, PermCompaniesRead, PermRatesUpdate, PermOrdersRead, PermOrdersProcess, PermUsersKyc},
+	RoleAuditor:  {PermUsersRead, PermUsersList, PermCompaniesRead, PermOrdersRead, PermUifReportsRead},
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersList,
+		PermUsersCreate,
+		PermUsersUpdate,
+		PermUsersDelete,
+		PermUsersManageRoles,
+		PermUsersBlock,
+		PermCompaniesRead,
+		PermCompaniesUpdate,
+		PermCatalogManage,
+		PermRatesUpdate,
+		PermOrdersRead,
+		PermOrdersProcess,
+		PermUsersKyc,
+		PermUifReportsRead,
+	},
+}
+
+func isValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole allows the request only when the caller has one of the roles, it must be used after AuthMiddleware
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := getPrincipal(w, r)
+			if !ok {
+				return
+			}
+			for _, role := range roles {
+				if principal.User.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			log.Printf("User %v with role %v requires one of roles %v", principal.User.Id, principal.User.Role, roles)
+			sendForbidden(w)
+		})
+	}
+}
+
+// RequirePermission allows the request only when the caller has all the permissions, it must be used after AuthMiddleware
+func RequirePermission(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := getPrincipal(w, r)
+			if !ok {
+				return
+			}
+			for _, perm := range perms {
+				if !principal.HasPermission(perm) {
+					log.Printf("User %v with role %v lacks permission %v", principal.User.Id, principal.User.Role, perm)
+					sendForbidden(w)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"testing"
+)
+
+func TestRequirePermission(t *testing.T) {
+	users := map[string]*database.User{
+		RoleCustomer: {Id: 1, Role: RoleCustomer},
+		RoleOperator: {Id: 2, Role: RoleOperator},
+		RoleAuditor:  {Id: 3, Role: RoleAuditor},
+		RoleAdmin:    {Id: 4, Role: RoleAdmin},
+		"unknown":    {Id: 5, Role: "unknown"},
+	}
+	store := newFakeStore()
+	for _, u := range users {
+		store.users[u.Id] = u
+	}
+	s := newTestServer(t, store)
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.With(RequirePermission(PermUsersList)).Get("/list", ok)
+	r.With(RequirePermission(PermUsersList, PermUsersDelete)).Delete("/delete", ok)
+
+	tests := []struct {
+		role   string
+		method string
+		target string
+		status int
+	}{
+		{RoleCustomer, http.MethodGet, "/list", http.StatusForbidden},
+		{RoleOperator, http.MethodGet, "/list", http.StatusNoContent},
+		{RoleAuditor, http.MethodGet, "/list", http.StatusNoContent},
+		{RoleAdmin, http.MethodGet, "/list", http.StatusNoContent},
+		{"unknown", http.MethodGet, "/list", http.StatusForbidden},
+		// Every permission is required
+		{RoleOperator, http.MethodDelete, "/delete", http.StatusForbidden},
+		{RoleAuditor, http.MethodDelete, "/delete", http.StatusForbidden},
+		{RoleAdmin, http.MethodDelete, "/delete", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role+" "+tt.target, func(t *testing.T) {
+			token := accessToken(t, s, users[tt.role])
+			if w := serve(r, newRequest(tt.method, tt.target, token, nil)); w.Code != tt.status {
+				t.Errorf("got status %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// The role of the database is authoritative, not the one stamped in the token
+	token := accessToken(t, s, users[RoleAdmin])
+	users[RoleAdmin].Role = RoleCustomer
+	if w := serve(r, newRequest(http.MethodGet, "/list", token, nil)); w.Code != http.StatusForbidden {
+		t.Errorf("demoted admin got status %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestRequirePermissionWithoutPrincipal(t *testing.T) {
+	h := RequirePermission(PermUsersList)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called without an authenticated caller")
+	}))
+	if w := serve(h, newRequest(http.MethodGet, "/list", "", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -5,24 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/blob"
 	"github.com/angelmotta/flow-api/internal/config"
+	"github.com/angelmotta/flow-api/internal/documents"
+	"github.com/angelmotta/flow-api/internal/idp"
+	"github.com/angelmotta/flow-api/internal/keys"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"io"
 	"log"
 	"net/http"
 	"net/mail"
+	"strconv"
 	"strings"
 )
 
 type Server struct {
 	store  database.Store // store is a dependency defined as an interface
+	keys   *keys.Manager  // keys signs and verifies the tokens issued by Flow
+	idps   *idp.Registry  // idps verifies the tokens of external identity providers used to login and signup
+	blobs  blob.Store     // blobs stores the files uploaded by users
 	Config *config.Config
 }
 
 type userCreateRequest struct {
 	Email             string `json:"email"`
-	Dni               string `json:"dni"`
+	DocumentType      string `json:"document_type"` // dni when omitted
+	Dni               string `json:"dni"`           // number of the identity document
 	Name              string `json:"name"`
 	LastnameMain      string `json:"lastname_main"`
 	LastnameSecondary string `json:"lastname_secondary"`
@@ -59,23 +68,8 @@ func (u *userCreateRequest) validateRequestFields() error {
 	if !isValidEmail(u.Email) {
 		return errors.New("invalid email")
 	}
-	if u.Dni == "" {
-		return errors.New("missing required 'dni' field")
-	}
-	if u.Name == "" {
-		return errors.New("missing required 'name' field")
-	}
-	if u.LastnameMain == "" {
-		return errors.New("missing required 'lastname_main' field")
-	}
-	if u.LastnameSecondary == "" {
-		return errors.New("missing required 'lastname_secondary' field")
-	}
-	if u.Address == "" {
-		return errors.New("missing required 'address' field")
-	}
-	// We can perform more validations here
-	return nil
+	u.DocumentType, u.Dni = normalizeDocument(u.DocumentType, u.Dni)
+	return validateUserInfo(u.DocumentType, u.Dni, u.Name, u.LastnameMain, u.LastnameSecondary, u.Address)
 }
 
 func isValidEmail(email string) bool {
@@ -84,11 +78,26 @@ func isValidEmail(email string) bool {
 }
 
 // NewServer receive an Interface Store and creates a new API Server Object
-func NewServer(store database.Store, c *config.Config) *Server {
+func NewServer(store database.Store, c *config.Config) (*Server, error) {
+	km, err := keys.NewManager(c)
+	if err != nil {
+		return nil, err
+	}
+	idps, err := idp.NewRegistryFromConfig(c, idp.NewHttpClient())
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := blob.NewFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
 	return &Server{
 		store:  store,
+		keys:   km,
+		idps:   idps,
+		blobs:  blobs,
 		Config: c,
-	}
+	}, nil
 }
 
 func (s *Server) getUser(email string) (*database.User, error) {
@@ -112,9 +121,9 @@ func (s *Server) createUser(user *database.User) error {
 	return nil
 }
 
-func (s *Server) deleteUser(id int) error {
+func (s *Server) deleteUser(id int, entry *database.AuditEntry) error {
 	log.Printf("Deleting User with ID: %v", id)
-	err := s.store.DeleteUser(id)
+	err := s.store.DeleteUser(id, entry)
 	if err != nil {
 		return err
 	}
@@ -167,15 +176,14 @@ func (s *Server) DecodeJsonBody(w http.ResponseWriter, r *http.Request, payload
 
 // GetUserHandler HTTP Handler returns a specific user
 func (s *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	email := chi.URLParam(r, "email")
-	if !isValidEmail(email) {
-		log.Println("Invalid email")
-		w.WriteHeader(http.StatusBadRequest)
+	// Only the owner or a back-office role may read the user
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersRead)
+	if !ok {
 		return
 	}
 
 	// Get user from database
-	user, err := s.store.GetUser(email)
+	user, err := s.store.GetUserByID(userId)
 	if err != nil {
 		log.Printf("Error getting user from database: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -186,25 +194,29 @@ func (s *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	sendUser(w, user)
+}
 
-	respJson, err := json.Marshal(user)
-	if err != nil {
-		log.Printf("Error marshalling user: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+// GetMeHandler HTTP Handler returns the user of the access token
+func (s *Server) GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
 		return
 	}
+	// AuthMiddleware has just loaded the user from the database
+	sendUser(w, principal.User)
+}
 
-	//_, err = w.Write([]byte("User found: " + user.Email + " DNI: " + user.Dni))
-	_, err = w.Write(respJson)
-	if err != nil {
-		log.Println("Error writing http response: ", err)
-		return
-	}
+// sendUser sends the user with its ETag, to be used as If-Match in updates
+func sendUser(w http.ResponseWriter, user *database.User) {
+	w.Header().Set("ETag", userETag(user))
+	sendJsonResponse(w, user, http.StatusOK)
 }
 
 type successfulUserAccessResponse struct {
 	*database.User `json:"user_info"`
 	tokensResponse `json:"tokens"`
+	Company        *database.Company `json:"company,omitempty"` // set by company signups
 }
 
 // CreateUserHandler HTTP Handler creates a user from a Signup request
@@ -239,7 +251,9 @@ func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Create requestCreateUser Object based on HTTP request
 	u := &database.User{
 		Email:             uCreateRequest.Email,
-		Role:              "customer",
+		Role:              RoleCustomer,
+		State:             UserStateRegistered,
+		DocumentType:      uCreateRequest.DocumentType,
 		Dni:               uCreateRequest.Dni,
 		Name:              uCreateRequest.Name,
 		LastnameMain:      uCreateRequest.LastnameMain,
@@ -287,45 +301,117 @@ func getCreateUserHttpCode(errMsg string) int {
 	return http.StatusInternalServerError
 }
 
-// DeleteUserHandler HTTP Handler deletes a user
+// DeleteUserHandler HTTP Handler closes the account of a user (soft delete)
 func (s *Server) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("DeleteUserHandler")
-	_, err := w.Write([]byte("DeleteUserHandler"))
-	if err != nil {
-		log.Println("Error writing http response: ", err)
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersDelete)
+	if !ok {
 		return
 	}
-}
 
-// UpdateUserHandler HTTP Handler updates user fields as Bank Account
-func (s *Server) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("TODO: UpdateUserHandler")
-	_, err := w.Write([]byte("UpdateUserHandler"))
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       "user.closed",
+		TargetUserId: &userId,
+	}
+	err := s.deleteUser(userId, entry)
 	if err != nil {
-		log.Println("Error writing http response: ", err)
+		if errors.Is(err, database.ErrUserNotFound) {
+			errRes := ErrorMessage{
+				Message: "User not found",
+			}
+			sendJsonResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrUserHasOpenOrders) {
+			errRes := ErrorMessage{
+				Message: err.Error(),
+			}
+			sendJsonResponse(w, errRes, http.StatusConflict)
+			return
+		}
+		log.Printf("Error deleting user %v: %v", userId, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// GetUsersHandler HTTP Handler returns a list of all users
+// GetUsersHandler HTTP Handler returns a page of the users matching the filters of the query string
 func (s *Server) GetUsersHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("TODO: GetUsersHandler")
-	_, err := w.Write([]byte("GetUsersHandler"))
+	log.Println("GetUsersHandler")
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.store.GetUsers(filter)
 	if err != nil {
-		log.Println("Error writing http response: ", err)
+		log.Printf("Error getting users from database: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
 		return
 	}
+
+	response := usersPageResponse{
+		Users: page.Users,
+		Total: page.Total,
+	}
+	if page.NextCursor != nil {
+		response.NextCursor = encodeUserCursor(page.NextCursor)
+	}
+	sendJsonResponse(w, response, http.StatusOK)
+}
+
+// authorizeUserAccess reads the {id} URL parameter and verifies the caller is the owner of that user or is granted perm
+func (s *Server) authorizeUserAccess(w http.ResponseWriter, r *http.Request, perm Permission) (int, bool) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return 0, false
+	}
+	userId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println("Invalid user id:", chi.URLParam(r, "id"))
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid user id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return 0, false
+	}
+	if !principal.CanAccessUser(userId, perm) {
+		log.Printf("User %v is not allowed to access user %v", principal.User.Id, userId)
+		sendForbidden(w)
+		return 0, false
+	}
+	return userId, true
 }
 
 type LoginRequest struct {
 	Idp string `json:"idp"`
 }
 
-func (l *LoginRequest) Validate() error {
+func (l *LoginRequest) Validate(idps *idp.Registry) error {
 	if l.Idp == "" {
 		return errors.New("missing required 'idp' field")
 	}
-	if l.Idp != "facebook" && l.Idp != "google" {
+	if _, ok := idps.Get(l.Idp); !ok {
 		return errors.New("invalid 'idp' value")
 	}
 	return nil
@@ -352,18 +438,19 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := loginRequest.Validate(); err != nil {
+	if err := loginRequest.Validate(s.idps); err != nil {
 		log.Println("validate loginRequest error -> ", err)
 		sendJsonResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	email, err := s.isValidExternalUserToken(token, loginRequest.Idp)
+	identity, err := s.isValidExternalUserToken(token, loginRequest.Idp)
 	if err != nil {
 		log.Println("Invalid credential")
 		sendJsonResponse(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	email := identity.Email
 
 	// Get user from database
 	user, err := s.store.GetUser(email)
@@ -380,6 +467,11 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		sendJsonResponse(w, errResponse, http.StatusNotFound)
 		return
 	}
+	if user.State == UserStateBlocked {
+		log.Printf("User %v is blocked, login rejected", user.Id)
+		sendUserBlocked(w)
+		return
+	}
 
 	// Create App tokens for user: access token and refresh token
 	tokensResponse, err := s.generateTokens(user)
@@ -402,31 +494,189 @@ func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	sendJsonResponse(w, responseMessage, http.StatusOK)
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (rt *RefreshTokenRequest) Validate() error {
+	if rt.RefreshToken == "" {
+		return errors.New("missing required 'refresh_token' field")
+	}
+	return nil
+}
+
+// RefreshTokenHandler redeems a refresh token for a new pair of access and refresh tokens
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("RefreshTokenHandler")
+	refreshRequest := &RefreshTokenRequest{}
+	err := s.DecodeJsonBody(w, r, refreshRequest)
+	if err != nil {
+		errResponse := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errResponse, http.StatusBadRequest)
+		return
+	}
+	if err := refreshRequest.Validate(); err != nil {
+		errResponse := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errResponse, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.verifyRefreshToken(refreshRequest.RefreshToken)
+	if err != nil {
+		log.Printf("Invalid refresh token -> %v", err)
+		sendUnauthorized(w, "invalid refresh token")
+		return
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		log.Printf("Invalid subject %q in refresh token", claims.Subject)
+		sendUnauthorized(w, "invalid refresh token")
+		return
+	}
+	user, err := s.store.GetUserByID(userId)
+	if err != nil {
+		log.Printf("Error getting user from database: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		log.Printf("User %v of refresh token not found", userId)
+		sendUnauthorized(w, "invalid refresh token")
+		return
+	}
+	if user.State == UserStateBlocked {
+		log.Printf("User %v is blocked, refresh rejected", user.Id)
+		sendUserBlocked(w)
+		return
+	}
+
+	tokensResponse, err := s.rotateTokens(user, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRefreshTokenReused):
+			log.Printf("Refresh token reuse detected for user %v, family %v revoked", user.Id, claims.FamilyId)
+			sendUnauthorized(w, "refresh token already used, please login again")
+		case errors.Is(err, database.ErrRefreshTokenRevoked), errors.Is(err, database.ErrRefreshTokenNotFound):
+			log.Printf("Refresh token rejected -> %v", err)
+			sendUnauthorized(w, "invalid refresh token")
+		default:
+			log.Println("Error trying to rotate tokens -> ", err)
+			errResponse := ErrorMessage{
+				Message: "Error while generating access to App Flow",
+				Error:   "Error while refreshing tokens for user",
+			}
+			sendJsonResponse(w, errResponse, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	log.Println("Tokens successfully refreshed: sending response message")
+	sendJsonResponse(w, tokensResponse, http.StatusOK)
+}
+
+// LogoutHandler ends the session of the access token used in the request
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("LogoutHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	err := s.store.RevokeRefreshTokenFamily(principal.SessionId, principal.User.Id, "logout")
+	if err != nil {
+		log.Printf("Error revoking session %v: %v", principal.SessionId, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v logged out of session %v", principal.User.Id, principal.SessionId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAllHandler ends every session of the user on all devices
+func (s *Server) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("LogoutAllHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	revoked, err := s.store.RevokeUserRefreshTokenFamilies(principal.User.Id, "logout_all")
+	if err != nil {
+		log.Printf("Error revoking sessions of user %v: %v", principal.User.Id, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v logged out of %v sessions", principal.User.Id, revoked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const (
+	accountTypePersonal = "personal"
+	accountTypeCompany  = "company"
+)
+
 type UserSignupRequest struct {
-	Step     string                 `json:"step"`
-	Idp      string                 `json:"idp"`
-	UserInfo *UserInfoSignupRequest `json:"user_info"`
+	Step        string                 `json:"step"`
+	Idp         string                 `json:"idp"`
+	AccountType string                 `json:"account_type"` // personal when omitted, company registers the user as representative of Company
+	UserInfo    *UserInfoSignupRequest `json:"user_info"`
+	Company     *companyRequest        `json:"company"`
 }
 
-func (u *UserSignupRequest) Validate() error {
+func (u *UserSignupRequest) Validate(idps *idp.Registry) error {
 	if u.Idp == "" {
 		return errors.New("missing required 'idp' field")
 	}
+	if _, ok := idps.Get(u.Idp); !ok {
+		return errors.New("invalid 'idp' value")
+	}
 	if u.Step != "1" && u.Step != "2" {
 		return errors.New("invalid 'step' value")
 	}
+	if u.AccountType == "" {
+		u.AccountType = accountTypePersonal
+	}
+	if u.AccountType != accountTypePersonal && u.AccountType != accountTypeCompany {
+		return errors.New("invalid 'account_type' value")
+	}
 	if u.Step == "2" && u.UserInfo == nil {
 		return errors.New("missing User Information in 'user_info' field")
 	}
+	if u.Step == "2" && u.AccountType == accountTypeCompany && u.Company == nil {
+		return errors.New("missing Company Information in 'company' field")
+	}
 	if u.Step == "2" && u.UserInfo != nil {
-		err := u.UserInfo.Validate()
-		return err
+		if err := u.UserInfo.Validate(); err != nil {
+			return err
+		}
+	}
+	if u.Step == "2" && u.AccountType == accountTypeCompany {
+		if err := u.Company.Validate(); err != nil {
+			return fmt.Errorf("company %w", err)
+		}
 	}
 	return nil
 }
 
 type UserInfoSignupRequest struct {
-	Dni               string `json:"dni"`
+	DocumentType      string `json:"document_type"` // dni when omitted
+	Dni               string `json:"dni"`           // number of the identity document
 	Name              string `json:"name"`
 	LastnameMain      string `json:"lastname_main"`
 	LastnameSecondary string `json:"lastname_secondary"`
@@ -434,24 +684,49 @@ type UserInfoSignupRequest struct {
 }
 
 func (u *UserInfoSignupRequest) Validate() error {
-	if u.Dni == "" {
-		return errors.New("user_info missing required 'dni' field")
+	u.DocumentType, u.Dni = normalizeDocument(u.DocumentType, u.Dni)
+	err := validateUserInfo(u.DocumentType, u.Dni, u.Name, u.LastnameMain, u.LastnameSecondary, u.Address)
+	if err != nil {
+		return fmt.Errorf("user_info %w", err)
 	}
-	if u.Name == "" {
-		return errors.New("user_info missing required 'name' field")
+	return nil
+}
+
+// validateUserInfo validates the profile fields of a user, shared by signup and profile updates
+func validateUserInfo(documentType, dni, name, lastnameMain, lastnameSecondary, address string) error {
+	if !documents.IsValidType(documentType) {
+		return fmt.Errorf("invalid 'document_type' value, must be one of %v", documents.Types())
 	}
-	if u.LastnameMain == "" {
-		return errors.New("user_info missing required 'lastname_main' field")
+	if dni == "" {
+		return errors.New("missing required 'dni' field")
 	}
-	if u.LastnameSecondary == "" {
-		return errors.New("user_info missing required 'lastname_secondary' field")
+	if err := documents.Validate(documentType, dni); err != nil {
+		return fmt.Errorf("invalid 'dni' value: %w", err)
 	}
-	if u.Address == "" {
-		return errors.New("user_info missing required 'address' field")
+	if name == "" {
+		return errors.New("missing required 'name' field")
+	}
+	if lastnameMain == "" {
+		return errors.New("missing required 'lastname_main' field")
+	}
+	if lastnameSecondary == "" {
+		return errors.New("missing required 'lastname_secondary' field")
+	}
+	if address == "" {
+		return errors.New("missing required 'address' field")
 	}
 	return nil
 }
 
+// normalizeDocument defaults the document type to DNI and normalizes the document number
+func normalizeDocument(documentType, number string) (string, string) {
+	documentType = strings.ToLower(strings.TrimSpace(documentType))
+	if documentType == "" {
+		documentType = documents.TypeDni
+	}
+	return documentType, documents.Normalize(number)
+}
+
 // UserSignupHandler creates a user from a Signup request according to the step of onboarding
 func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("UserSignupHandler")
@@ -483,7 +758,7 @@ func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println(userSignupRequest)
 
 	// Validate Input
-	err = userSignupRequest.Validate()
+	err = userSignupRequest.Validate(s.idps)
 	if err != nil {
 		log.Println("validate userSignupRequest error ->", err)
 		errResponse := ErrorMessage{
@@ -495,7 +770,7 @@ func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify token according to idp specified in body request
-	email, err := s.isValidExternalUserToken(token, userSignupRequest.Idp)
+	identity, err := s.isValidExternalUserToken(token, userSignupRequest.Idp)
 	if err != nil {
 		log.Printf("isValidExternalUserToken error -> %v ", err)
 		errResponse := ErrorMessage{
@@ -505,6 +780,7 @@ func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 		sendJsonResponse(w, &errResponse, http.StatusUnauthorized)
 		return
 	}
+	email := identity.Email
 	log.Println("User email: ", email)
 
 	// Handle Signup step flow
@@ -537,14 +813,27 @@ func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 		// Create user in database
 		user := &database.User{
 			Email:             email,
-			Role:              "customer",
+			Role:              RoleCustomer,
+			State:             UserStateRegistered,
+			DocumentType:      userSignupRequest.UserInfo.DocumentType,
 			Dni:               userSignupRequest.UserInfo.Dni,
 			Name:              userSignupRequest.UserInfo.Name,
 			LastnameMain:      userSignupRequest.UserInfo.LastnameMain,
 			LastnameSecondary: userSignupRequest.UserInfo.LastnameSecondary,
 			Address:           userSignupRequest.UserInfo.Address,
 		}
-		err = s.store.CreateUser(user)
+		var company *database.Company
+		if userSignupRequest.AccountType == accountTypeCompany {
+			// The user is created as the first representative of the company
+			company = userSignupRequest.Company.company()
+			err = s.store.CreateUserWithCompany(user, company)
+		} else {
+			err = s.store.CreateUser(user)
+		}
+		if errors.Is(err, database.ErrCompanyExists) {
+			sendCompanyError(w, err)
+			return
+		}
 		if err != nil {
 			log.Printf("Error creating user: %v", err)
 			errorHttpCode := getCreateUserHttpCode(err.Error())
@@ -574,6 +863,7 @@ func (s *Server) UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 		responseMessage := successfulUserAccessResponse{
 			User:           user,
 			tokensResponse: *tokensResponse,
+			Company:        company,
 		}
 		sendJsonResponse(w, responseMessage, http.StatusOK)
 		return
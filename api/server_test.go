@@ -0,0 +1,431 @@
+package api
+
+import (
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/config"
+	"github.com/angelmotta/flow-api/internal/keys"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeStore is an in-memory database.Store for handler tests, the methods it does not implement panic
+type fakeStore struct {
+	database.Store
+	users           map[int]*database.User
+	deletedUsers    map[int]time.Time // deletion time of the soft deleted users, kept in users while not anonymized
+	families        map[string]int    // user id of the refresh token families
+	revokedFamilies map[string]bool
+	refreshTokens   map[string]*database.RefreshToken
+	audit           []*database.AuditEntry
+	companies       map[int]*database.Company
+	bankAccounts    []*database.BankAccount
+	catalogs        map[string][]*database.CatalogEntry
+	quotes          map[string]*database.Quote
+	rates           map[string]*database.ExchangeRate
+	orders          []*database.Order
+	lockedOrders    map[int]bool // orders whose rows are locked by another transaction
+	transferProofs  []*database.TransferProof
+}
+
+func newFakeStore(users ...*database.User) *fakeStore {
+	f := &fakeStore{
+		users:           map[int]*database.User{},
+		deletedUsers:    map[int]time.Time{},
+		families:        map[string]int{},
+		revokedFamilies: map[string]bool{},
+		refreshTokens:   map[string]*database.RefreshToken{},
+		companies:       map[int]*database.Company{},
+		quotes:          map[string]*database.Quote{},
+		lockedOrders:    map[int]bool{},
+		rates: map[string]*database.ExchangeRate{
+			"USD-PEN": {Pair: "USD-PEN", CurrencyMain: "USD", CurrencySecondary: "PEN", BuyPrice: 3700, SellPrice: 3750, MinimumValidTimeMins: 5},
+		},
+		catalogs: map[string][]*database.CatalogEntry{
+			"banks":      {{Code: "BCP", Name: "Banco de Crédito del Perú", Enabled: true, CciEntityCode: "002"}},
+			"currencies": {{Code: "PEN", Name: "Sol peruano", Enabled: true}, {Code: "USD", Name: "Dólar estadounidense", Enabled: true}},
+		},
+	}
+	for _, u := range users {
+		f.users[u.Id] = u
+	}
+	return f
+}
+
+func (f *fakeStore) GetUserByID(id int) (*database.User, error) {
+	user, ok := f.users[id]
+	if _, deleted := f.deletedUsers[id]; !ok || deleted {
+		return nil, nil
+	}
+	// Handlers modify the users they get, as they would modify a row read from the database
+	copied := *user
+	return &copied, nil
+}
+
+func (f *fakeStore) UpdateUser(user *database.User, expectedVersion int, entry *database.AuditEntry) error {
+	current, ok := f.users[user.Id]
+	if _, deleted := f.deletedUsers[user.Id]; !ok || deleted {
+		return database.ErrUserNotFound
+	}
+	if current.Version != expectedVersion {
+		return database.ErrVersionConflict
+	}
+	user.Version++
+	*current = *user
+	if entry != nil {
+		f.audit = append(f.audit, entry)
+	}
+	return nil
+}
+
+func (f *fakeStore) DeleteUser(id int, entry *database.AuditEntry) error {
+	if _, deleted := f.deletedUsers[id]; deleted || f.users[id] == nil {
+		return database.ErrUserNotFound
+	}
+	for _, order := range f.orders {
+		if order.UserId == id && (order.State == OrderStatePending || order.State == OrderStateInProgress) {
+			return database.ErrUserHasOpenOrders
+		}
+	}
+	f.deletedUsers[id] = time.Now()
+	f.users[id].Version++
+	if entry != nil {
+		f.audit = append(f.audit, entry)
+	}
+	return nil
+}
+
+func (f *fakeStore) AnonymizeDeletedUsers(deletedBefore time.Time) (int64, error) {
+	var anonymized int64
+	for id, deletedAt := range f.deletedUsers {
+		user := f.users[id]
+		if deletedAt.Before(deletedBefore) && user.Dni != "" {
+			*user = database.User{Id: id, Email: fmt.Sprintf("anonymized-%d", id), Role: user.Role, CreatedAt: user.CreatedAt, Version: user.Version}
+			anonymized++
+		}
+	}
+	return anonymized, nil
+}
+
+func (f *fakeStore) UpdateUserState(userId int, from, to string, entry *database.AuditEntry) error {
+	user, ok := f.users[userId]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	if user.State != from {
+		return database.ErrUserStateConflict
+	}
+	user.State = to
+	if entry != nil {
+		f.audit = append(f.audit, entry)
+	}
+	return nil
+}
+
+// kycTiers are the tiers of the fake, as rows of the kyc_tiers table
+var kycTiers = map[string]bool{"basic": true, "verified": true, "enhanced": true}
+
+func (f *fakeStore) UpdateUserKycTier(userId int, tier string, entry *database.AuditEntry) error {
+	user, ok := f.users[userId]
+	if !ok {
+		return database.ErrUserNotFound
+	}
+	if !kycTiers[tier] {
+		return database.ErrUnknownKycTier
+	}
+	user.KycTier = tier
+	user.Version++
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeStore) UpdateCompanyKycTier(companyId int, tier string, entry *database.AuditEntry) error {
+	company, ok := f.companies[companyId]
+	if !ok {
+		return database.ErrCompanyNotFound
+	}
+	if !kycTiers[tier] {
+		return database.ErrUnknownKycTier
+	}
+	company.KycTier = tier
+	f.audit = append(f.audit, entry)
+	return nil
+}
+
+func (f *fakeStore) GetCompany(id int) (*database.Company, error) {
+	return f.companies[id], nil
+}
+
+// CreateBankAccount enforces the unique account numbers per owner of the database and knows the PEN and USD currencies
+func (f *fakeStore) CreateBankAccount(account *database.BankAccount) error {
+	if account.CurrencyType != "PEN" && account.CurrencyType != "USD" {
+		return database.ErrUnknownCurrency
+	}
+	for _, a := range f.bankAccounts {
+		sameOwner := a.CompanyId == nil && account.CompanyId == nil && a.UserId == account.UserId ||
+			a.CompanyId != nil && account.CompanyId != nil && *a.CompanyId == *account.CompanyId
+		if sameOwner && a.AccountNumber == account.AccountNumber {
+			return database.ErrBankAccountExists
+		}
+	}
+	account.Id = len(f.bankAccounts) + 1
+	f.bankAccounts = append(f.bankAccounts, account)
+	return nil
+}
+
+func (f *fakeStore) GetBankByCciEntity(entityCode string) (string, error) {
+	for _, bank := range f.catalogs["banks"] {
+		if bank.CciEntityCode == entityCode && bank.Enabled {
+			return bank.Code, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeStore) GetCatalog(catalog string, includeDisabled bool) ([]*database.CatalogEntry, error) {
+	entries, ok := f.catalogs[catalog]
+	if !ok {
+		return nil, database.ErrUnknownCatalog
+	}
+	enabled := []*database.CatalogEntry{}
+	for _, e := range entries {
+		if e.Enabled || includeDisabled {
+			enabled = append(enabled, e)
+		}
+	}
+	return enabled, nil
+}
+
+func (f *fakeStore) GetQuote(id string) (*database.Quote, error) {
+	quote, ok := f.quotes[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *quote
+	return &copied, nil
+}
+
+func (f *fakeStore) IsCompanyRepresentative(companyId, userId int) (bool, error) {
+	if company, ok := f.companies[companyId]; ok {
+		for _, rep := range company.Representatives {
+			if rep.UserId == userId {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeStore) GetBankAccount(id int) (*database.BankAccount, error) {
+	for _, account := range f.bankAccounts {
+		if account.Id == id {
+			return account, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStore) GetRate(pair string) (*database.ExchangeRate, error) {
+	return f.rates[pair], nil
+}
+
+func (f *fakeStore) GetRates() ([]*database.ExchangeRate, error) {
+	rates := []*database.ExchangeRate{}
+	for _, rate := range f.rates {
+		rates = append(rates, rate)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Pair < rates[j].Pair })
+	return rates, nil
+}
+
+// CreateOrder consumes the quote of the order and copies its terms, as the database does
+func (f *fakeStore) CreateOrder(order *database.Order) error {
+	quote, ok := f.quotes[order.QuoteId]
+	if !ok || quote.UserId != order.UserId || quote.ConsumedAt != nil || !time.Now().Before(quote.ExpiresAt) {
+		return database.ErrQuoteUnavailable
+	}
+	now := time.Now()
+	quote.ConsumedAt = &now
+	order.Pair, order.Side, order.Rate = quote.Pair, quote.Side, quote.Rate
+	order.AmountMain, order.AmountSecondary = quote.AmountMain, quote.AmountSecondary
+	order.Id = len(f.orders) + 1
+	order.State = OrderStatePending
+	f.orders = append(f.orders, order)
+	return nil
+}
+
+func (f *fakeStore) GetOrder(id int) (*database.Order, error) {
+	for _, order := range f.orders {
+		if order.Id == id {
+			found := *order
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStore) CreateTransferProof(proof *database.TransferProof) error {
+	for _, p := range f.transferProofs {
+		if p.OrderId == proof.OrderId && p.OperationNumber == proof.OperationNumber {
+			return database.ErrTransferProofExists
+		}
+	}
+	proof.Id = len(f.transferProofs) + 1
+	f.transferProofs = append(f.transferProofs, proof)
+	return nil
+}
+
+// ClaimNextOrder skips the locked orders, as SELECT ... FOR UPDATE SKIP LOCKED does
+func (f *fakeStore) ClaimNextOrder(operatorUserId int) (*database.Order, error) {
+	for _, order := range f.orders {
+		if order.State == OrderStatePending && order.UserId != operatorUserId && !f.lockedOrders[order.Id] {
+			return f.ClaimOrder(order.Id, operatorUserId)
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeStore) ClaimOrder(orderId, operatorUserId int) (*database.Order, error) {
+	return f.withOrder(orderId, func(order *database.Order) error {
+		if order.State != OrderStatePending {
+			return database.ErrOrderStateConflict
+		}
+		order.State = OrderStateInProgress
+		order.OperatorUserId = &operatorUserId
+		return nil
+	})
+}
+
+func (f *fakeStore) RecordOrderPayout(orderId, operatorUserId int, reference string, entry *database.AuditEntry) (*database.Order, error) {
+	return f.withOrder(orderId, func(order *database.Order) error {
+		order.PayoutReference = reference
+		f.audit = append(f.audit, entry)
+		return nil
+	})
+}
+
+func (f *fakeStore) FinishOrder(orderId, operatorUserId int) (*database.Order, error) {
+	return f.withOrder(orderId, func(order *database.Order) error {
+		if order.PayoutReference == "" {
+			return database.ErrPayoutMissing
+		}
+		order.State = OrderStateFinished
+		return nil
+	})
+}
+
+// withOrder runs update on the order unless it is locked by another transaction
+func (f *fakeStore) withOrder(orderId int, update func(order *database.Order) error) (*database.Order, error) {
+	for _, order := range f.orders {
+		if order.Id != orderId {
+			continue
+		}
+		if f.lockedOrders[orderId] {
+			return nil, database.ErrOrderLocked
+		}
+		if err := update(order); err != nil {
+			return nil, err
+		}
+		updated := *order
+		return &updated, nil
+	}
+	return nil, database.ErrOrderNotFound
+}
+
+func (f *fakeStore) CreateRefreshTokenFamily(familyId string, userId int, token *database.RefreshToken) error {
+	f.families[familyId] = userId
+	f.refreshTokens[token.Id] = token
+	return nil
+}
+
+func (f *fakeStore) RotateRefreshToken(oldId string, newToken *database.RefreshToken) error {
+	old, ok := f.refreshTokens[oldId]
+	if !ok || old.FamilyId != newToken.FamilyId {
+		return database.ErrRefreshTokenNotFound
+	}
+	if f.revokedFamilies[old.FamilyId] {
+		return database.ErrRefreshTokenRevoked
+	}
+	if old.UsedAt != nil {
+		f.revokedFamilies[old.FamilyId] = true
+		return database.ErrRefreshTokenReused
+	}
+	now := time.Now()
+	old.UsedAt = &now
+	f.refreshTokens[newToken.Id] = newToken
+	return nil
+}
+
+func (f *fakeStore) IsRefreshTokenFamilyActive(familyId string) (bool, error) {
+	_, ok := f.families[familyId]
+	return ok && !f.revokedFamilies[familyId], nil
+}
+
+func (f *fakeStore) RevokeRefreshTokenFamily(familyId string, userId int, reason string) error {
+	if f.families[familyId] == userId {
+		f.revokedFamilies[familyId] = true
+	}
+	return nil
+}
+
+func (f *fakeStore) RevokeUserRefreshTokenFamilies(userId int, reason string) (int64, error) {
+	var revoked int64
+	for familyId, owner := range f.families {
+		if owner == userId && !f.revokedFamilies[familyId] {
+			f.revokedFamilies[familyId] = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// newTestServer returns a server on store signing its tokens with an HS256 test key
+func newTestServer(t *testing.T, store database.Store) *Server {
+	t.Helper()
+	c := &config.Config{
+		JwtSigningAlg:   "HS256",
+		JwtSigningKeyId: "test-1",
+		JwtSigningKey:   "a test secret of at least 32 bytes",
+	}
+	manager, err := keys.NewManager(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{store: store, keys: manager, Config: c}
+}
+
+// login issues the tokens of a new session of user
+func login(t *testing.T, s *Server, user *database.User) *tokensResponse {
+	t.Helper()
+	tokens, err := s.generateTokens(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// accessToken returns a valid access token of user
+func accessToken(t *testing.T, s *Server, user *database.User) string {
+	t.Helper()
+	return login(t, s, user).AccessToken
+}
+
+// newRequest returns a request authenticated with token, if not empty
+func newRequest(method, target, token string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// serve sends r to h and returns the response
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
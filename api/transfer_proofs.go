@@ -0,0 +1,307 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/blob"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// proofFormOverheadBytes is the room left in proof uploads for the form fields and multipart boundaries
+	proofFormOverheadBytes = 64 * 1024
+	proofFormMemoryBytes   = 1024 * 1024 // larger files are buffered on disk while parsing the form
+	maxOperationNumberLen  = 50
+	maxProofFileNameLen    = 255
+	// proofClockSkew tolerates customer clocks slightly ahead of ours in 'transferred_at'
+	proofClockSkew = 5 * time.Minute
+)
+
+// proofContentTypes are the file types accepted as proofs, detected from the content and not from the declared type
+var proofContentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+var errProofTooLarge = errors.New("proof file is too large")
+
+type transferProofRequest struct {
+	OperationNumber string
+	Amount          money.Amount
+	TransferredAt   time.Time
+}
+
+// parseTransferProofForm reads the fields of the multipart form: operation_number, amount and transferred_at
+// (RFC 3339 or YYYY-MM-DD)
+func parseTransferProofForm(form *multipart.Form) (*transferProofRequest, error) {
+	value := func(name string) string {
+		if values := form.Value[name]; len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+		return ""
+	}
+	p := &transferProofRequest{OperationNumber: value("operation_number")}
+	if p.OperationNumber == "" {
+		return nil, errors.New("missing required 'operation_number' field")
+	}
+	if len(p.OperationNumber) > maxOperationNumberLen {
+		return nil, fmt.Errorf("'operation_number' cannot be longer than %d characters", maxOperationNumberLen)
+	}
+	amount := value("amount")
+	if amount == "" {
+		return nil, errors.New("missing required 'amount' field")
+	}
+	var err error
+	p.Amount, err = money.ParseAmount(amount)
+	if err != nil || p.Amount <= 0 {
+		return nil, errors.New("invalid 'amount' value, it must be positive with at most 2 decimals")
+	}
+	// transfer_proofs.amount is a NUMERIC(14, 2) as the amounts of quotes
+	if p.Amount > maxQuoteAmount {
+		return nil, fmt.Errorf("invalid 'amount' value, it must be at most %v", maxQuoteAmount)
+	}
+	transferredAt := value("transferred_at")
+	if transferredAt == "" {
+		return nil, errors.New("missing required 'transferred_at' field")
+	}
+	p.TransferredAt, err = parseTimeParam(transferredAt)
+	if err != nil {
+		return nil, errors.New("invalid 'transferred_at' value")
+	}
+	if p.TransferredAt.After(time.Now().Add(proofClockSkew)) {
+		return nil, errors.New("'transferred_at' cannot be in the future")
+	}
+	return p, nil
+}
+
+// UploadTransferProofHandler HTTP Handler attaches a proof of the transfer of the customer to a pending or in progress
+// order. It is a multipart/form-data request with the operation_number, amount and transferred_at fields and a 'file'
+// holding a JPEG, PNG or WebP image or a PDF of up to ProofMaxBytes.
+func (s *Server) UploadTransferProofHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UploadTransferProofHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	// Proofs are sent by the customer side of the order, staff permissions do not grant it
+	order, ok := s.authorizeOrderAccess(w, r, "")
+	if !ok {
+		return
+	}
+	if order.State != OrderStatePending && order.State != OrderStateInProgress {
+		sendOrderError(w, fmt.Errorf("%w: order is %v", database.ErrOrderStateConflict, order.State))
+		return
+	}
+	if order.State == OrderStatePending && !order.ExpiresAt.After(time.Now()) {
+		sendOrderError(w, fmt.Errorf("%w: the transfer window of the order ended", database.ErrOrderStateConflict))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.Config.ProofMaxBytes+proofFormOverheadBytes)
+	err := r.ParseMultipartForm(proofFormMemoryBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			sendProofTooLarge(w, s.Config.ProofMaxBytes)
+			return
+		}
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid multipart form: " + err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	pRequest, err := parseTransferProofForm(r.MultipartForm)
+	var file multipart.File
+	var header *multipart.FileHeader
+	if err == nil {
+		file, header, err = r.FormFile("file")
+		if errors.Is(err, http.ErrMissingFile) {
+			err = errors.New("missing required 'file' field")
+		}
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > s.Config.ProofMaxBytes {
+		sendProofTooLarge(w, s.Config.ProofMaxBytes)
+		return
+	}
+	contentType, err := detectProofContentType(file)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fileId, err := newTokenId()
+	if err != nil {
+		sendOrderError(w, err)
+		return
+	}
+	proof := &database.TransferProof{
+		OrderId:         order.Id,
+		UserId:          principal.User.Id,
+		OperationNumber: pRequest.OperationNumber,
+		Amount:          pRequest.Amount,
+		TransferredAt:   pRequest.TransferredAt,
+		FileKey:         fmt.Sprintf("proofs/%d/%s%s", order.Id, fileId, proofContentTypes[contentType]),
+		FileName:        proofFileName(header.Filename, proofContentTypes[contentType]),
+		ContentType:     contentType,
+		FileSize:        header.Size,
+	}
+	ctx := r.Context()
+	if err := s.blobs.Put(ctx, proof.FileKey, file); err != nil {
+		log.Printf("Error storing transfer proof of order %v: %v", order.Id, err)
+		sendOrderError(w, err)
+		return
+	}
+	if err := s.store.CreateTransferProof(proof); err != nil {
+		// The file has no record pointing to it, it would never be served
+		if err := s.blobs.Delete(context.Background(), proof.FileKey); err != nil {
+			log.Printf("Error deleting orphan blob %v: %v", proof.FileKey, err)
+		}
+		sendTransferProofError(w, err)
+		return
+	}
+	log.Printf("User %v attached transfer proof %v to order %v", principal.User.Id, proof.Id, order.Id)
+	sendJsonResponse(w, proof, http.StatusCreated)
+}
+
+// detectProofContentType sniffs the type of the file from its first bytes and rewinds it
+func detectProofContentType(file multipart.File) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	contentType := http.DetectContentType(buf[:n])
+	if _, ok := proofContentTypes[contentType]; !ok {
+		return "", fmt.Errorf("unsupported file type %v, proofs must be JPEG, PNG or WebP images or PDF documents", contentType)
+	}
+	return contentType, nil
+}
+
+// proofFileName keeps the base name sent by the client for display, with the extension of the detected type
+func proofFileName(name, ext string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if name == "" || name == "." || name == "/" {
+		name = "proof"
+	}
+	if len(name) > maxProofFileNameLen-len(ext) {
+		name = strings.ToValidUTF8(name[:maxProofFileNameLen-len(ext)], "")
+	}
+	return name + ext
+}
+
+// GetTransferProofsHandler HTTP Handler lists the transfer proofs of an order
+func (s *Server) GetTransferProofsHandler(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.authorizeOrderAccess(w, r, PermOrdersRead)
+	if !ok {
+		return
+	}
+	proofs, err := s.store.GetTransferProofs(order.Id)
+	if err != nil {
+		sendTransferProofError(w, err)
+		return
+	}
+	sendJsonResponse(w, proofs, http.StatusOK)
+}
+
+// DownloadTransferProofHandler HTTP Handler sends the file of a transfer proof, to the customer side of the order
+// and to operators verifying the transfer
+func (s *Server) DownloadTransferProofHandler(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.authorizeOrderAccess(w, r, PermOrdersRead)
+	if !ok {
+		return
+	}
+	proofId, err := strconv.Atoi(chi.URLParam(r, "proofId"))
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   "invalid proof id",
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	proof, err := s.store.GetTransferProof(order.Id, proofId)
+	if err != nil {
+		sendTransferProofError(w, err)
+		return
+	}
+	if proof == nil {
+		sendTransferProofError(w, database.ErrTransferProofNotFound)
+		return
+	}
+	content, err := s.blobs.Get(r.Context(), proof.FileKey)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			log.Printf("File %v of transfer proof %v is missing", proof.FileKey, proof.Id)
+		}
+		sendTransferProofError(w, err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", proof.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(proof.FileSize, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": proof.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Error sending transfer proof %v: %v", proof.Id, err)
+	}
+}
+
+func sendProofTooLarge(w http.ResponseWriter, maxBytes int64) {
+	errRes := ErrorMessage{
+		Message: "Invalid request",
+		Error:   fmt.Sprintf("%v, the maximum size is %d bytes", errProofTooLarge, maxBytes),
+	}
+	sendJsonResponse(w, errRes, http.StatusRequestEntityTooLarge)
+}
+
+func sendTransferProofError(w http.ResponseWriter, err error) {
+	errRes := ErrorMessage{
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, database.ErrTransferProofNotFound):
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+	case errors.Is(err, database.ErrTransferProofExists):
+		sendJsonResponse(w, errRes, http.StatusConflict)
+	default:
+		sendOrderError(w, err)
+	}
+}
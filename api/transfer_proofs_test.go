@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/angelmotta/flow-api/internal/blob"
+	"github.com/go-chi/chi/v5"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProofMaxBytes = 1024
+
+// pngHeader is the signature http.DetectContentType recognizes as image/png
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// proofForm encodes the fields and the file of a transfer proof upload
+func proofForm(t *testing.T, fields map[string]string, fileName string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if content != nil {
+		part, err := mw.CreateFormFile("file", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, mw.FormDataContentType()
+}
+
+func TestParseTransferProofForm(t *testing.T) {
+	valid := map[string]string{"operation_number": "00123456", "amount": "375.00", "transferred_at": "2026-10-01T10:00:00-05:00"}
+	with := func(name, value string) map[string][]string {
+		form := map[string][]string{}
+		for k, v := range valid {
+			form[k] = []string{v}
+		}
+		form[name] = []string{value}
+		return form
+	}
+
+	p, err := parseTransferProofForm(&multipart.Form{Value: with("operation_number", " 00123456 ")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OperationNumber != "00123456" || p.Amount != 37500 || !p.TransferredAt.Equal(time.Date(2026, 10, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected proof request %+v", p)
+	}
+	if _, err := parseTransferProofForm(&multipart.Form{Value: with("amount", "999999999999.99")}); err != nil {
+		t.Errorf("the largest amount was rejected: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		value string
+	}{
+		{"missing operation number", "operation_number", ""},
+		{"long operation number", "operation_number", strings.Repeat("1", maxOperationNumberLen+1)},
+		{"missing amount", "amount", ""},
+		{"zero amount", "amount", "0"},
+		{"three decimals", "amount", "375.001"},
+		{"amount beyond NUMERIC(14, 2)", "amount", "1000000000000.00"},
+		{"invalid date", "transferred_at", "01/10/2026"},
+		{"future date", "transferred_at", time.Now().Add(time.Hour).Format(time.RFC3339)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p, err := parseTransferProofForm(&multipart.Form{Value: with(tt.field, tt.value)}); err == nil {
+				t.Errorf("got %+v, want an error", p)
+			}
+		})
+	}
+}
+
+func TestUploadTransferProofHandler(t *testing.T) {
+	s, store := newOrdersTestServer(t)
+	s.Config.ProofMaxBytes = testProofMaxBytes
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.blobs = blobs
+	now := time.Now()
+	store.orders = []*database.Order{
+		{Id: 1, UserId: 7, State: OrderStatePending, ExpiresAt: now.Add(10 * time.Minute)},
+		{Id: 2, UserId: 7, State: OrderStatePending, ExpiresAt: now.Add(-time.Minute)},
+		{Id: 3, UserId: 7, State: OrderStateFinished, ExpiresAt: now.Add(-time.Hour)},
+	}
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Post("/api/v1/orders/{orderId}/proofs", s.UploadTransferProofHandler)
+	fields := map[string]string{"operation_number": "00123456", "amount": "375.00", "transferred_at": now.Format(time.RFC3339)}
+	png := []byte(pngHeader + strings.Repeat("x", 100))
+	upload := func(userId int, orderId string, fileName string, content []byte) *httptest.ResponseRecorder {
+		body, contentType := proofForm(t, fields, fileName, content)
+		req := newRequest(http.MethodPost, "/api/v1/orders/"+orderId+"/proofs", accessToken(t, s, store.users[userId]), body)
+		req.Header.Set("Content-Type", contentType)
+		return serve(r, req)
+	}
+
+	tests := []struct {
+		name     string
+		userId   int
+		orderId  string
+		fileName string
+		content  []byte
+		status   int
+	}{
+		{"order of another customer", 8, "1", "proof.png", png, http.StatusNotFound},
+		{"expired transfer window", 7, "2", "proof.png", png, http.StatusConflict},
+		{"finished order", 7, "3", "proof.png", png, http.StatusConflict},
+		{"missing file", 7, "1", "", nil, http.StatusBadRequest},
+		// The declared name and type are ignored, the content decides
+		{"text named as an image", 7, "1", "proof.png", []byte("this is not an image"), http.StatusUnsupportedMediaType},
+		{"html", 7, "1", "proof.pdf", []byte("<html><script>alert(1)</script></html>"), http.StatusUnsupportedMediaType},
+		{"file over the limit", 7, "1", "proof.png", []byte(pngHeader + strings.Repeat("x", testProofMaxBytes)), http.StatusRequestEntityTooLarge},
+		{"body over the limit", 7, "1", "proof.png", []byte(pngHeader + strings.Repeat("x", proofFormOverheadBytes+testProofMaxBytes)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := upload(tt.userId, tt.orderId, tt.fileName, tt.content); w.Code != tt.status {
+				t.Errorf("got status %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if len(store.transferProofs) != 0 {
+		t.Fatalf("rejected uploads stored %v proofs", len(store.transferProofs))
+	}
+
+	if w := upload(7, "1", `C:\Users\ana\voucher.jpeg`, png); w.Code != http.StatusCreated {
+		t.Fatalf("got status %v: %s", w.Code, w.Body)
+	}
+	if len(store.transferProofs) != 1 {
+		t.Fatalf("got %v proofs, want 1", len(store.transferProofs))
+	}
+	proof := store.transferProofs[0]
+	if proof.ContentType != "image/png" || proof.FileName != "voucher.png" || proof.FileSize != int64(len(png)) {
+		t.Errorf("unexpected proof %+v", proof)
+	}
+	stored, err := blobs.Get(context.Background(), proof.FileKey)
+	if err != nil {
+		t.Fatalf("the file of the proof was not stored: %v", err)
+	}
+	stored.Close()
+
+	if w := upload(7, "1", "proof.png", png); w.Code != http.StatusConflict {
+		t.Errorf("a repeated operation number got status %v: %s", w.Code, w.Body)
+	}
+}
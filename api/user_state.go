@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"github.com/angelmotta/flow-api/database"
+	"log"
+	"net/http"
+)
+
+// Values of the users_state enum
+const (
+	UserStateRegistered = "registered" // signed up, no bank account yet
+	UserStateActive     = "active"     // has at least one bank account, can place orders
+	UserStateBlocked    = "blocked"    // blocked by an operator, cannot log in
+	UserStateDeleted    = "deleted"    // account closed, see DeleteUserHandler
+)
+
+// userStateTransitions lists the states each state can move to, deleted is final
+var userStateTransitions = map[string][]string{
+	UserStateRegistered: {UserStateActive, UserStateBlocked, UserStateDeleted},
+	UserStateActive:     {UserStateBlocked, UserStateDeleted},
+	UserStateBlocked:    {UserStateRegistered, UserStateActive, UserStateDeleted},
+	UserStateDeleted:    {},
+}
+
+func isValidUserState(state string) bool {
+	_, ok := userStateTransitions[state]
+	return ok
+}
+
+func canTransitionUserState(from, to string) bool {
+	for _, state := range userStateTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// activateUser promotes a registered user to active, it is called when the user adds its first bank account
+func (s *Server) activateUser(user *database.User) error {
+	if user.State != UserStateRegistered {
+		return nil
+	}
+	err := s.store.UpdateUserState(user.Id, UserStateRegistered, UserStateActive, nil)
+	if errors.Is(err, database.ErrUserStateConflict) {
+		// The user was activated or blocked concurrently, nothing to promote
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("User %v promoted to %v", user.Id, UserStateActive)
+	user.State = UserStateActive
+	return nil
+}
+
+type userStateRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (u *userStateRequest) Validate() error {
+	if u.Reason == "" {
+		return errors.New("missing required 'reason' field")
+	}
+	return nil
+}
+
+// BlockUserHandler HTTP Handler blocks a user: its sessions are revoked and it cannot log in until unblocked
+func (s *Server) BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("BlockUserHandler")
+	s.changeUserState(w, r, UserStateBlocked, "user.blocked")
+}
+
+// UnblockUserHandler HTTP Handler unblocks a user, restoring it to active if it has bank accounts or to registered otherwise
+func (s *Server) UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UnblockUserHandler")
+	s.changeUserState(w, r, UserStateRegistered, "user.unblocked")
+}
+
+// changeUserState moves the user of the {id} URL parameter to state to, recording action in the audit log
+func (s *Server) changeUserState(w http.ResponseWriter, r *http.Request, to string, action string) {
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersBlock)
+	if !ok {
+		return
+	}
+	if userId == principal.User.Id {
+		errRes := ErrorMessage{
+			Message: "You cannot change the state of your own user",
+		}
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+
+	stateRequest := &userStateRequest{}
+	err := s.DecodeJsonBody(w, r, stateRequest)
+	if err == nil {
+		err = stateRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUserByID(userId)
+	if err != nil {
+		log.Printf("Error getting user from database: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		errRes := ErrorMessage{
+			Message: "User not found",
+		}
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+
+	if user.State == UserStateBlocked && to == UserStateRegistered {
+		// Unblocked users go back to where they were: active once they have a bank account
+		hasAccounts, err := s.store.UserHasBankAccounts(userId)
+		if err != nil {
+			log.Printf("Error getting bank accounts of user %v: %v", userId, err)
+			errRes := ErrorMessage{
+				Message: "Service unavailable",
+			}
+			sendJsonResponse(w, errRes, http.StatusInternalServerError)
+			return
+		}
+		if hasAccounts {
+			to = UserStateActive
+		}
+	}
+	if !canTransitionUserState(user.State, to) {
+		errRes := ErrorMessage{
+			Message: "Invalid state transition",
+			Error:   "user cannot move from '" + user.State + "' to '" + to + "'",
+		}
+		sendJsonResponse(w, errRes, http.StatusConflict)
+		return
+	}
+
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       action,
+		TargetUserId: &userId,
+		Details: map[string]interface{}{
+			"reason":         stateRequest.Reason,
+			"previous_state": user.State,
+			"new_state":      to,
+		},
+	}
+	err = s.store.UpdateUserState(userId, user.State, to, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrUserNotFound):
+			errRes := ErrorMessage{
+				Message: "User not found",
+			}
+			sendJsonResponse(w, errRes, http.StatusNotFound)
+		case errors.Is(err, database.ErrUserStateConflict):
+			errRes := ErrorMessage{
+				Message: "Invalid state transition",
+				Error:   err.Error(),
+			}
+			sendJsonResponse(w, errRes, http.StatusConflict)
+		default:
+			log.Printf("Error changing state of user %v: %v", userId, err)
+			errRes := ErrorMessage{
+				Message: "Service unavailable",
+				Error:   err.Error(),
+			}
+			sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %v moved user %v from %v to %v", principal.User.Id, userId, user.State, to)
+	sendJsonResponse(w, entry, http.StatusOK)
+}
+
+func sendUserBlocked(w http.ResponseWriter) {
+	errRes := ErrorMessage{
+		Message: "User is blocked, please contact support",
+		Error:   "user blocked",
+	}
+	sendJsonResponse(w, errRes, http.StatusForbidden)
+}
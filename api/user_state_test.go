@@ -0,0 +1,51 @@
+package api
+
+import "testing"
+
+func TestCanTransitionUserState(t *testing.T) {
+	states := []string{UserStateRegistered, UserStateActive, UserStateBlocked, UserStateDeleted}
+	allowed := map[[2]string]bool{
+		{UserStateRegistered, UserStateActive}:  true,
+		{UserStateRegistered, UserStateBlocked}: true,
+		{UserStateRegistered, UserStateDeleted}: true,
+		{UserStateActive, UserStateBlocked}:     true,
+		{UserStateActive, UserStateDeleted}:     true,
+		{UserStateBlocked, UserStateRegistered}: true,
+		{UserStateBlocked, UserStateActive}:     true,
+		{UserStateBlocked, UserStateDeleted}:    true,
+	}
+	for _, from := range states {
+		for _, to := range states {
+			want := allowed[[2]string{from, to}]
+			if got := canTransitionUserState(from, to); got != want {
+				t.Errorf("canTransitionUserState(%q, %q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	// Unknown states never transition
+	for _, state := range states {
+		if canTransitionUserState("unknown", state) || canTransitionUserState(state, "unknown") {
+			t.Errorf("unexpected transition between 'unknown' and %q", state)
+		}
+	}
+}
+
+func TestIsValidUserState(t *testing.T) {
+	tests := []struct {
+		state string
+		want  bool
+	}{
+		{UserStateRegistered, true},
+		{UserStateActive, true},
+		{UserStateBlocked, true},
+		{UserStateDeleted, true},
+		{"", false},
+		{"Active", false},
+		{"suspended", false},
+	}
+	for _, tt := range tests {
+		if got := isValidUserState(tt.state); got != tt.want {
+			t.Errorf("isValidUserState(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
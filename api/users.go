@@ -1 +1,447 @@
 package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/database"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type changeUserRoleRequest struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
+
+func (c *changeUserRoleRequest) Validate() error {
+	if c.Role == "" {
+		return errors.New("missing required 'role' field")
+	}
+	if !isValidRole(c.Role) {
+		return errors.New("invalid 'role' value")
+	}
+	if c.Reason == "" {
+		return errors.New("missing required 'reason' field")
+	}
+	return nil
+}
+
+// ChangeUserRoleHandler HTTP Handler changes the role of a user, the change is recorded in the audit log
+func (s *Server) ChangeUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("ChangeUserRoleHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersManageRoles)
+	if !ok {
+		return
+	}
+	// Owners pass authorizeUserAccess, but nobody may change their own role (admins would be able to lock themselves out)
+	if userId == principal.User.Id {
+		errRes := ErrorMessage{
+			Message: "You cannot change your own role",
+		}
+		sendJsonResponse(w, errRes, http.StatusForbidden)
+		return
+	}
+
+	roleRequest := &changeUserRoleRequest{}
+	err := s.DecodeJsonBody(w, r, roleRequest)
+	if err == nil {
+		err = roleRequest.Validate()
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	entry := &database.AuditEntry{
+		ActorUserId:  principal.User.Id,
+		Action:       "user.role_changed",
+		TargetUserId: &userId,
+		Details: map[string]interface{}{
+			"reason": roleRequest.Reason,
+		},
+	}
+	err = s.store.UpdateUserRole(userId, roleRequest.Role, entry)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			errRes := ErrorMessage{
+				Message: "User not found",
+			}
+			sendJsonResponse(w, errRes, http.StatusNotFound)
+			return
+		}
+		log.Printf("Error changing role of user %v: %v", userId, err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %v changed role of user %v to %v", principal.User.Id, userId, roleRequest.Role)
+	sendJsonResponse(w, entry, http.StatusOK)
+}
+
+const (
+	defaultUsersPageSize = 20
+	maxUsersPageSize     = 100
+)
+
+type usersPageResponse struct {
+	Users      []*database.User `json:"users"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// parseUserFilter builds the users listing filter from the query parameters:
+// state, role, created_from, created_to (RFC 3339 or YYYY-MM-DD), email, email_prefix, dni_prefix, name_prefix,
+// sort (id, created_at, email or name, '-' prefix for descending order), limit and cursor
+func parseUserFilter(r *http.Request) (*database.UserFilter, error) {
+	q := r.URL.Query()
+	filter := &database.UserFilter{
+		State:       q.Get("state"),
+		Role:        q.Get("role"),
+		Email:       q.Get("email"),
+		EmailPrefix: q.Get("email_prefix"),
+		DniPrefix:   q.Get("dni_prefix"),
+		NamePrefix:  q.Get("name_prefix"),
+		SortBy:      "created_at",
+		Limit:       defaultUsersPageSize,
+	}
+	if filter.State != "" && !isValidUserState(filter.State) {
+		return nil, errors.New("invalid 'state' value")
+	}
+	if filter.Email != "" && !isValidEmail(filter.Email) {
+		return nil, errors.New("invalid 'email' value")
+	}
+	if filter.Role != "" && !isValidRole(filter.Role) {
+		return nil, errors.New("invalid 'role' value")
+	}
+	if v := q.Get("created_from"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return nil, errors.New("invalid 'created_from' value")
+		}
+		filter.CreatedFrom = &t
+	}
+	if v := q.Get("created_to"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return nil, errors.New("invalid 'created_to' value")
+		}
+		filter.CreatedTo = &t
+	}
+	if v := q.Get("sort"); v != "" {
+		filter.SortDesc = strings.HasPrefix(v, "-")
+		filter.SortBy = strings.TrimPrefix(v, "-")
+		if !database.IsValidUserSort(filter.SortBy) {
+			return nil, errors.New("invalid 'sort' value")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxUsersPageSize {
+			return nil, fmt.Errorf("'limit' must be between 1 and %d", maxUsersPageSize)
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := decodeUserCursor(v, filter.SortBy)
+		if err != nil {
+			return nil, errors.New("invalid 'cursor' value")
+		}
+		filter.After = cursor
+	}
+	return filter, nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and plain dates
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// Cursors are opaque to clients: base64 encoded JSON of the position of the last user of a page
+func encodeUserCursor(cursor *database.UserCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeUserCursor decodes a cursor sent by the client, whose value must match the type of the sort column
+func decodeUserCursor(value, sortBy string) (*database.UserCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &database.UserCursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, err
+	}
+	if err := cursor.Validate(sortBy); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// optionalString is a merge patch field: Set tells an absent field apart from one set to null (Value nil)
+type optionalString struct {
+	Set   bool
+	Value *string
+}
+
+func (o *optionalString) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	return json.Unmarshal(b, &o.Value)
+}
+
+// userPatchRequest is a JSON merge patch (RFC 7386) of the mutable fields of a user
+type userPatchRequest struct {
+	Email             optionalString `json:"email"`
+	Role              optionalString `json:"role"`
+	DocumentType      optionalString `json:"document_type"`
+	Dni               optionalString `json:"dni"`
+	Name              optionalString `json:"name"`
+	LastnameMain      optionalString `json:"lastname_main"`
+	LastnameSecondary optionalString `json:"lastname_secondary"`
+	Address           optionalString `json:"address"`
+}
+
+// apply merges the patch into user and returns the names of the fields it changed
+func (p *userPatchRequest) apply(user *database.User) ([]string, error) {
+	var changed []string
+	fields := []struct {
+		name  string
+		patch optionalString
+		value *string
+	}{
+		{"email", p.Email, &user.Email},
+		{"role", p.Role, &user.Role},
+		{"document_type", p.DocumentType, &user.DocumentType},
+		{"dni", p.Dni, &user.Dni},
+		{"name", p.Name, &user.Name},
+		{"lastname_main", p.LastnameMain, &user.LastnameMain},
+		{"lastname_secondary", p.LastnameSecondary, &user.LastnameSecondary},
+		{"address", p.Address, &user.Address},
+	}
+	for _, f := range fields {
+		if !f.patch.Set {
+			continue
+		}
+		// Every field is required, removing them is not allowed
+		if f.patch.Value == nil {
+			return nil, fmt.Errorf("'%s' cannot be null", f.name)
+		}
+		value := strings.TrimSpace(*f.patch.Value)
+		if value != *f.value {
+			*f.value = value
+			changed = append(changed, f.name)
+		}
+	}
+	return changed, nil
+}
+
+// userETag is the entity tag of the current version of a user
+func userETag(user *database.User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// parseIfMatch returns the user version of an If-Match header holding a single entity tag
+func parseIfMatch(header string) (int, error) {
+	tag := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, errors.New("invalid If-Match header")
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil {
+		return 0, errors.New("invalid If-Match header")
+	}
+	return version, nil
+}
+
+// UpdateUserHandler HTTP Handler partially updates a user with a JSON merge patch.
+// The If-Match header must hold the ETag of the version being modified to prevent lost updates.
+func (s *Server) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateUserHandler")
+	principal, ok := getPrincipal(w, r)
+	if !ok {
+		return
+	}
+	userId, ok := s.authorizeUserAccess(w, r, PermUsersUpdate)
+	if !ok {
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		errRes := ErrorMessage{
+			Message: "Missing If-Match header with the ETag of the user",
+		}
+		sendJsonResponse(w, errRes, http.StatusPreconditionRequired)
+		return
+	}
+	expectedVersion, err := parseIfMatch(ifMatch)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	patch := &userPatchRequest{}
+	err = s.DecodeJsonBody(w, r, patch)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.store.GetUserByID(userId)
+	if err != nil {
+		log.Printf("Error getting user from database: %v", err)
+		errRes := ErrorMessage{
+			Message: "Service unavailable",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		errRes := ErrorMessage{
+			Message: "User not found",
+		}
+		sendJsonResponse(w, errRes, http.StatusNotFound)
+		return
+	}
+	if user.Version != expectedVersion {
+		sendPreconditionFailed(w, user)
+		return
+	}
+
+	previousRole := user.Role
+	changed, err := patch.apply(user)
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+	if len(changed) == 0 {
+		w.Header().Set("ETag", userETag(user))
+		sendJsonResponse(w, user, http.StatusOK)
+		return
+	}
+
+	// Customers may only change their profile, identity fields and roles are managed by the back-office
+	for _, field := range changed {
+		allowed := true
+		switch field {
+		case "email", "document_type", "dni":
+			allowed = principal.HasPermission(PermUsersUpdate)
+		case "role":
+			allowed = principal.HasPermission(PermUsersManageRoles) && principal.User.Id != userId
+		}
+		if !allowed {
+			log.Printf("User %v is not allowed to change '%v' of user %v", principal.User.Id, field, userId)
+			errRes := ErrorMessage{
+				Message: fmt.Sprintf("You are not allowed to change the '%s' field", field),
+			}
+			sendJsonResponse(w, errRes, http.StatusForbidden)
+			return
+		}
+	}
+
+	user.DocumentType, user.Dni = normalizeDocument(user.DocumentType, user.Dni)
+	err = validateUserInfo(user.DocumentType, user.Dni, user.Name, user.LastnameMain, user.LastnameSecondary, user.Address)
+	if err == nil && !isValidEmail(user.Email) {
+		err = errors.New("invalid email")
+	}
+	if err == nil && !isValidRole(user.Role) {
+		err = errors.New("invalid 'role' value")
+	}
+	if err != nil {
+		errRes := ErrorMessage{
+			Message: "Invalid request",
+			Error:   err.Error(),
+		}
+		sendJsonResponse(w, errRes, http.StatusBadRequest)
+		return
+	}
+
+	// Changes made by the back-office on behalf of a user are audited
+	var entry *database.AuditEntry
+	if principal.User.Id != userId {
+		entry = &database.AuditEntry{
+			ActorUserId:  principal.User.Id,
+			Action:       "user.updated",
+			TargetUserId: &userId,
+			Details: map[string]interface{}{
+				"fields": changed,
+			},
+		}
+		// Role changes are audited as in ChangeUserRoleHandler
+		if user.Role != previousRole {
+			entry.Details["previous_role"] = previousRole
+			entry.Details["new_role"] = user.Role
+		}
+	}
+	err = s.store.UpdateUser(user, expectedVersion, entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrVersionConflict):
+			current, getErr := s.store.GetUserByID(userId)
+			if getErr == nil && current != nil {
+				sendPreconditionFailed(w, current)
+				return
+			}
+			errRes := ErrorMessage{
+				Message: "User was modified by another request",
+			}
+			sendJsonResponse(w, errRes, http.StatusPreconditionFailed)
+		case errors.Is(err, database.ErrUserNotFound):
+			errRes := ErrorMessage{
+				Message: "User not found",
+			}
+			sendJsonResponse(w, errRes, http.StatusNotFound)
+		default:
+			log.Printf("Error updating user %v: %v", userId, err)
+			errRes := ErrorMessage{
+				Message: err.Error(),
+			}
+			sendJsonResponse(w, errRes, getCreateUserHttpCode(err.Error()))
+		}
+		return
+	}
+
+	log.Printf("User %v updated fields %v of user %v", principal.User.Id, changed, userId)
+	w.Header().Set("ETag", userETag(user))
+	sendJsonResponse(w, user, http.StatusOK)
+}
+
+func sendPreconditionFailed(w http.ResponseWriter, current *database.User) {
+	w.Header().Set("ETag", userETag(current))
+	errRes := ErrorMessage{
+		Message: "User was modified by another request, get it again and retry",
+	}
+	sendJsonResponse(w, errRes, http.StatusPreconditionFailed)
+}
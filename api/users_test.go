@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/angelmotta/flow-api/database"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeUserCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	tests := []struct {
+		name   string
+		value  string
+		sortBy string
+		want   *database.UserCursor // nil when the cursor must be rejected
+	}{
+		{"created_at", encode(`{"v":"2026-10-01T12:30:00.123456","id":42}`), "created_at", &database.UserCursor{Value: "2026-10-01T12:30:00.123456", Id: 42}},
+		{"email", encode(`{"v":"ana@example.com","id":42}`), "email", &database.UserCursor{Value: "ana@example.com", Id: 42}},
+		{"id ignores the value", encode(`{"v":"anything","id":42}`), "id", &database.UserCursor{Value: "anything", Id: 42}},
+		{"round trip", encodeUserCursor(&database.UserCursor{Value: "Ana", Id: 7}), "name", &database.UserCursor{Value: "Ana", Id: 7}},
+		{"text value when sorting by created_at", encode(`{"v":"yesterday","id":42}`), "created_at", nil},
+		{"date without time", encode(`{"v":"2026-10-01","id":42}`), "created_at", nil},
+		{"null byte in text", encode(`{"v":"ana\u0000","id":42}`), "email", nil},
+		{"zero id", encode(`{"v":"ana@example.com","id":0}`), "email", nil},
+		{"id out of range", encode(`{"v":"ana@example.com","id":2147483648}`), "email", nil},
+		{"unknown sort", encode(`{"v":"ana@example.com","id":42}`), "password", nil},
+		{"not json", encode(`ana@example.com`), "email", nil},
+		{"not base64", "***", "email", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeUserCursor(tt.value, tt.sortBy)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("got cursor %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("got cursor %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUserFilter(t *testing.T) {
+	parse := func(query string) (*database.UserFilter, error) {
+		return parseUserFilter(newRequest(http.MethodGet, "/api/v1/users?"+query, "", nil))
+	}
+
+	filter, err := parse("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.SortBy != "created_at" || filter.SortDesc || filter.Limit != defaultUsersPageSize || filter.After != nil {
+		t.Errorf("unexpected default filter %+v", filter)
+	}
+
+	cursor := encodeUserCursor(&database.UserCursor{Value: "ana@example.com", Id: 7})
+	query := url.Values{
+		"state":        {"active"},
+		"role":         {RoleOperator},
+		"created_from": {"2026-01-01"},
+		"created_to":   {"2026-02-01T10:00:00-05:00"},
+		"email_prefix": {"ana"},
+		"sort":         {"-email"},
+		"limit":        {"50"},
+		"cursor":       {cursor},
+	}
+	filter, err = parse(query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdTo := time.Date(2026, 2, 1, 15, 0, 0, 0, time.UTC)
+	if filter.State != "active" || filter.Role != RoleOperator || filter.EmailPrefix != "ana" {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+	if !filter.CreatedFrom.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || !filter.CreatedTo.Equal(createdTo) {
+		t.Errorf("got created range %v to %v", filter.CreatedFrom, filter.CreatedTo)
+	}
+	if filter.SortBy != "email" || !filter.SortDesc || filter.Limit != 50 {
+		t.Errorf("got sort %q desc %v limit %v", filter.SortBy, filter.SortDesc, filter.Limit)
+	}
+	if filter.After == nil || filter.After.Id != 7 {
+		t.Errorf("got cursor %+v", filter.After)
+	}
+
+	invalid := []string{
+		"state=sleeping",
+		"role=root",
+		"email=ana",
+		"created_from=01/01/2026",
+		"created_to=tomorrow",
+		"sort=password",
+		"sort=--email",
+		"limit=0",
+		"limit=101",
+		"limit=ten",
+		// The cursor of an email listing does not fit a listing sorted by creation time
+		"cursor=" + cursor,
+	}
+	for _, query := range invalid {
+		if filter, err := parse(query); err == nil {
+			t.Errorf("parseUserFilter(%q) = %+v, want an error", query, filter)
+		}
+	}
+}
+
+func TestUpdateUserHandlerAuditsRoleChanges(t *testing.T) {
+	admin := &database.User{Id: 1, Email: "admin@flow.pe", Role: RoleAdmin}
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, Dni: "12345678", Name: "Ana", LastnameMain: "Flores", LastnameSecondary: "Rojas", Address: "Av. Arequipa 123", Version: 3}
+	store := newFakeStore(admin, user)
+	s := newTestServer(t, store)
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Patch("/api/v1/users/{id}", s.UpdateUserHandler)
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := newRequest(http.MethodPatch, "/api/v1/users/7", accessToken(t, s, admin), strings.NewReader(body))
+		req.Header.Set("If-Match", userETag(store.users[7]))
+		return serve(r, req)
+	}
+
+	if w := patch(`{"name": "Ana María", "role": "operator"}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %v: %s", w.Code, w.Body)
+	}
+	if user.Role != RoleOperator || user.Version != 4 {
+		t.Errorf("got role %q and version %v, want %q and 4", user.Role, user.Version, RoleOperator)
+	}
+	if len(store.audit) != 1 {
+		t.Fatalf("got %v audit entries, want 1", len(store.audit))
+	}
+	details := store.audit[0].Details
+	if details["previous_role"] != RoleCustomer || details["new_role"] != RoleOperator {
+		t.Errorf("got audit details %v, want the previous and the new role", details)
+	}
+
+	// Changes leaving the role as is do not record roles
+	if w := patch(`{"address": "Jr. Cusco 456"}`); w.Code != http.StatusOK {
+		t.Fatalf("got status %v: %s", w.Code, w.Body)
+	}
+	if details := store.audit[1].Details; details["previous_role"] != nil || details["new_role"] != nil {
+		t.Errorf("got audit details %v without a role change", details)
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	admin := &database.User{Id: 1, Email: "admin@flow.pe", Role: RoleAdmin}
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, Dni: "12345678"}
+	other := &database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer, Dni: "87654321"}
+	store := newFakeStore(admin, user, other)
+	s := newTestServer(t, store)
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Delete("/api/v1/users/{id}", s.DeleteUserHandler)
+	del := func(caller *database.User, target string) int {
+		return serve(r, newRequest(http.MethodDelete, "/api/v1/users/"+target, accessToken(t, s, caller), nil)).Code
+	}
+
+	if status := del(other, "7"); status != http.StatusForbidden {
+		t.Errorf("a customer closing the account of another user got status %v", status)
+	}
+	if status := del(user, "7"); status != http.StatusNoContent {
+		t.Fatalf("closing their own account got status %v", status)
+	}
+	// The account is soft deleted, its data is kept until the retention window passes
+	if _, deleted := store.deletedUsers[7]; !deleted || store.users[7].Dni != "12345678" {
+		t.Errorf("the user was not soft deleted: %+v", store.users[7])
+	}
+	if len(store.audit) != 1 || store.audit[0].Action != "user.closed" || *store.audit[0].TargetUserId != 7 {
+		t.Errorf("got audit entries %+v, want the closing of user 7", store.audit)
+	}
+	if status := del(admin, "7"); status != http.StatusNotFound {
+		t.Errorf("closing a closed account got status %v", status)
+	}
+	if status := del(admin, "9"); status != http.StatusNotFound {
+		t.Errorf("closing an unknown account got status %v", status)
+	}
+	if status := del(admin, "8"); status != http.StatusNoContent {
+		t.Errorf("an admin closing an account got status %v", status)
+	}
+}
+
+func TestGetUserHandlers(t *testing.T) {
+	user := &database.User{Id: 7, Email: "ana@example.com", Role: RoleCustomer, Version: 2}
+	other := &database.User{Id: 8, Email: "luis@example.com", Role: RoleCustomer}
+	operator := &database.User{Id: 2, Email: "operator@flow.pe", Role: RoleOperator}
+	s := newTestServer(t, newFakeStore(user, other, operator))
+	r := chi.NewRouter()
+	r.Use(s.AuthMiddleware)
+	r.Get("/api/v1/users/me", s.GetMeHandler)
+	r.Get("/api/v1/users/{id}", s.GetUserHandler)
+
+	tests := []struct {
+		name   string
+		caller *database.User
+		target string
+		status int
+		userId int
+	}{
+		{"me", user, "me", http.StatusOK, 7},
+		{"me of an operator", operator, "me", http.StatusOK, 2},
+		{"owner", user, "7", http.StatusOK, 7},
+		{"another customer", other, "7", http.StatusForbidden, 0},
+		{"operator", operator, "7", http.StatusOK, 7},
+		{"unknown user", operator, "9", http.StatusNotFound, 0},
+		{"invalid id", operator, "ana@example.com", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(r, newRequest(http.MethodGet, "/api/v1/users/"+tt.target, accessToken(t, s, tt.caller), nil))
+			if w.Code != tt.status {
+				t.Fatalf("got status %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			got := &database.User{}
+			if err := json.NewDecoder(w.Body).Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Id != tt.userId {
+				t.Errorf("got user %v, want %v", got.Id, tt.userId)
+			}
+			if etag := w.Header().Get("ETag"); etag != userETag(got) {
+				t.Errorf("got ETag %q, want %q", etag, userETag(got))
+			}
+		})
+	}
+}
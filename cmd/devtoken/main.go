@@ -0,0 +1,34 @@
+// Command devtoken issues tokens for the "dev" identity provider, to exercise signup and login without network:
+//
+//	DEVIDPSECRET=... go run ./cmd/devtoken -email jane@example.com -name Jane
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/idp"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the identity (required)")
+	name := flag.String("name", "", "name of the identity")
+	ttl := flag.Duration("ttl", time.Hour, "token lifetime")
+	flag.Parse()
+
+	secret := os.Getenv("DEVIDPSECRET")
+	if secret == "" {
+		log.Fatal("you must set 'DEVIDPSECRET' Environment Variable")
+	}
+	if *email == "" {
+		log.Fatal("missing required -email flag")
+	}
+
+	token, err := idp.IssueDevToken([]byte(secret), *email, *name, *ttl)
+	if err != nil {
+		log.Fatalf("Error issuing dev token: %v", err)
+	}
+	fmt.Println(token)
+}
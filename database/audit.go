@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+// AuditEntry records a privileged action performed by a user (the actor) on another user
+type AuditEntry struct {
+	Id           int                    `json:"audit_id"`
+	ActorUserId  int                    `json:"actor_user_id"`
+	Action       string                 `json:"action"`
+	TargetUserId *int                   `json:"target_user_id,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+}
+
+// CreateAuditEntry records an audit entry outside of any other change
+func (s *storePostgres) CreateAuditEntry(entry *AuditEntry) error {
+	return insertAuditEntry(context.Background(), s.db, entry)
+}
+
+// UpdateUserRole changes the role of a user and records the change in the audit log in the same transaction. The
+// version is bumped so updates based on an earlier read of the user fail their If-Match check.
+func (s *storePostgres) UpdateUserRole(userId int, role string, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateUserRole:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	var previousRole string
+	err = tx.QueryRow(ctx, "select role from users where id = $1 and deleted_at is null for update", userId).Scan(&previousRole)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		log.Println("Error reading user role:", err)
+		return errors.New("internal database error")
+	}
+	_, err = tx.Exec(ctx, "update users set role = $2, version = version + 1 where id = $1", userId, role)
+	if err != nil {
+		log.Println("Error updating user role:", err)
+		return errors.New("internal database error")
+	}
+
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["previous_role"] = previousRole
+	entry.Details["new_role"] = role
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing user role change:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// queryRower is implemented by both the pool and transactions
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func insertAuditEntry(ctx context.Context, db queryRower, entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		log.Println("Error encoding audit entry details:", err)
+		return errors.New("internal database error")
+	}
+	err = db.QueryRow(ctx, "insert into audit_log (actor_user_id, action, target_user_id, details) values ($1, $2, $3, $4) returning id, created_at", entry.ActorUserId, entry.Action, entry.TargetUserId, details).Scan(&entry.Id, &entry.CreatedAt)
+	if err != nil {
+		log.Println("Error creating audit entry:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
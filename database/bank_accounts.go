@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+	"time"
+)
+
+var (
+	ErrBankAccountNotFound = errors.New("bank account not found")
+	ErrBankAccountExists   = errors.New("A bank account already exists using the same account number")
+	ErrUnknownBank         = errors.New("unknown or disabled bank")
+	ErrUnknownCurrency     = errors.New("unknown or disabled currency")
+	ErrBankAccountInUse    = errors.New("bank account is used by orders in progress")
+)
+
+// BankAccount is an account where a user, or a company through its representatives, sends and receives money
+type BankAccount struct {
+	Id            int       `json:"id"`
+	AccountNumber string    `json:"account_number"`
+	Cci           string    `json:"cci"` // 20 digit interbank account code
+	CurrencyType  string    `json:"currency_type"`
+	BankName      string    `json:"bank_name"`
+	UserId        int       `json:"user_id"`              // owner, or representative who registered a company account
+	CompanyId     *int      `json:"company_id,omitempty"` // set for company accounts
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const bankAccountColumns = "id, account_number, cci, currency_type, bank_name, user_id, company_id, created_at"
+
+func scanBankAccount(row pgx.Row) (*BankAccount, error) {
+	var account BankAccount
+	err := row.Scan(&account.Id, &account.AccountNumber, &account.Cci, &account.CurrencyType, &account.BankName, &account.UserId, &account.CompanyId, &account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// CreateBankAccount registers a personal account, or a company account when CompanyId is set
+func (s *storePostgres) CreateBankAccount(account *BankAccount) error {
+	ctx := context.Background()
+	// Disabled banks and currencies still satisfy the foreign keys, new accounts must not use them
+	var bankEnabled, currencyEnabled bool
+	err := s.db.QueryRow(ctx, "select exists (select 1 from banks where bank_name = $1 and enabled), exists (select 1 from currencies where currency_type = $2 and enabled)", account.BankName, account.CurrencyType).Scan(&bankEnabled, &currencyEnabled)
+	if err != nil {
+		log.Println("Error captured from database layer in CreateBankAccount:", err)
+		return errors.New("internal database error")
+	}
+	if !bankEnabled {
+		return ErrUnknownBank
+	}
+	if !currencyEnabled {
+		return ErrUnknownCurrency
+	}
+
+	err = s.db.QueryRow(ctx, "insert into bank_accounts (account_number, cci, currency_type, bank_name, user_id, company_id) values ($1, $2, $3, $4, $5, $6) returning id, created_at", account.AccountNumber, account.Cci, account.CurrencyType, account.BankName, account.UserId, account.CompanyId).Scan(&account.Id, &account.CreatedAt)
+	if err != nil {
+		log.Println("Error captured from database layer in CreateBankAccount")
+		if bankAccountError := bankAccountPgError(err); bankAccountError != nil {
+			return bankAccountError
+		}
+		log.Println(err)
+		return errors.New("internal database error")
+	}
+	log.Printf("Bank account %v successfully created for user %v", account.Id, account.UserId)
+	return nil
+}
+
+func bankAccountPgError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil // not a pg error
+	}
+	log.Println("pgError:", pgErr)
+	switch pgErr.Code {
+	case "23505":
+		switch pgErr.ConstraintName {
+		case "bank_accounts_user_id_account_number_key", "bank_accounts_company_id_account_number_key":
+			return ErrBankAccountExists
+		}
+	case "23503":
+		switch pgErr.ConstraintName {
+		case "bank_accounts_bank_name_fkey":
+			return ErrUnknownBank
+		case "bank_accounts_currency_type_fkey":
+			return ErrUnknownCurrency
+		}
+	}
+	return nil
+}
+
+// GetBankAccount returns the bank account, or nil if it does not exist or was deleted
+func (s *storePostgres) GetBankAccount(id int) (*BankAccount, error) {
+	row := s.db.QueryRow(context.Background(), "select "+bankAccountColumns+" from bank_accounts where id = $1 and deleted_at is null", id)
+	account, err := scanBankAccount(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting bank account:", err)
+		return nil, errors.New("internal database error")
+	}
+	return account, nil
+}
+
+// GetBankByCciEntity returns the bank_name of the bank with the CCI entity code, or an empty string if there is none
+func (s *storePostgres) GetBankByCciEntity(entityCode string) (string, error) {
+	var bankName string
+	err := s.db.QueryRow(context.Background(), "select bank_name from banks where cci_entity_code = $1", entityCode).Scan(&bankName)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		log.Println("Error getting bank by CCI entity code:", err)
+		return "", errors.New("internal database error")
+	}
+	return bankName, nil
+}
+
+// GetUserBankAccounts returns the personal bank accounts of the user
+func (s *storePostgres) GetUserBankAccounts(userId int) ([]*BankAccount, error) {
+	return s.getBankAccounts("select "+bankAccountColumns+" from bank_accounts where user_id = $1 and company_id is null and deleted_at is null order by created_at", userId)
+}
+
+// GetCompanyBankAccounts returns the bank accounts of the company
+func (s *storePostgres) GetCompanyBankAccounts(companyId int) ([]*BankAccount, error) {
+	return s.getBankAccounts("select "+bankAccountColumns+" from bank_accounts where company_id = $1 and deleted_at is null order by created_at", companyId)
+}
+
+func (s *storePostgres) getBankAccounts(query string, args ...interface{}) ([]*BankAccount, error) {
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Println("Error listing bank accounts:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	accounts := []*BankAccount{}
+	for rows.Next() {
+		account, err := scanBankAccount(rows)
+		if err != nil {
+			log.Println("Error scanning bank account:", err)
+			return nil, errors.New("internal database error")
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing bank accounts:", err)
+		return nil, errors.New("internal database error")
+	}
+	return accounts, nil
+}
+
+// DeleteBankAccount soft deletes the bank account, its number can then be registered again.
+// Accounts used by pending or in progress orders cannot be deleted, it returns ErrBankAccountInUse.
+func (s *storePostgres) DeleteBankAccount(id int) error {
+	ctx := context.Background()
+	query := `update bank_accounts set deleted_at = now() where id = $1 and deleted_at is null
+		and not exists (select 1 from orders where (source_bank_account_id = $1 or destination_bank_account_id = $1) and state in ('pending', 'inprogress'))`
+	commandTag, err := s.db.Exec(ctx, query, id)
+	if err != nil {
+		log.Println("Error captured from database layer in DeleteBankAccount:", err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		var exists bool
+		if err := s.db.QueryRow(ctx, "select exists (select 1 from bank_accounts where id = $1 and deleted_at is null)", id).Scan(&exists); err != nil {
+			log.Println("Error captured from database layer in DeleteBankAccount:", err)
+			return errors.New("internal database error")
+		}
+		if exists {
+			return ErrBankAccountInUse
+		}
+		return ErrBankAccountNotFound
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"testing"
+)
+
+func TestBankAccountPgError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"duplicated personal account", &pgconn.PgError{Code: "23505", ConstraintName: "bank_accounts_user_id_account_number_key"}, ErrBankAccountExists},
+		{"duplicated company account", &pgconn.PgError{Code: "23505", ConstraintName: "bank_accounts_company_id_account_number_key"}, ErrBankAccountExists},
+		{"unknown bank", &pgconn.PgError{Code: "23503", ConstraintName: "bank_accounts_bank_name_fkey"}, ErrUnknownBank},
+		{"unknown currency", &pgconn.PgError{Code: "23503", ConstraintName: "bank_accounts_currency_type_fkey"}, ErrUnknownCurrency},
+		{"other unique constraint", &pgconn.PgError{Code: "23505", ConstraintName: "bank_accounts_pkey"}, nil},
+		{"not a pg error", errors.New("connection reset"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bankAccountPgError(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+)
+
+var (
+	ErrUnknownCatalog       = errors.New("unknown catalog")
+	ErrCatalogReadOnly      = errors.New("catalog cannot be modified")
+	ErrCatalogEntryNotFound = errors.New("catalog entry not found")
+	ErrCatalogEntryExists   = errors.New("catalog entry already exists")
+)
+
+// CatalogEntry is a row of a reference data table
+type CatalogEntry struct {
+	Code          string `json:"code"`
+	Name          string `json:"name"`
+	Enabled       bool   `json:"enabled"`
+	CciEntityCode string `json:"cci_entity_code,omitempty"` // banks only, identifies the bank in CCIs
+}
+
+// catalogTable describes the lookup table behind a catalog, editable tables have an enabled column.
+// entityCode is the column of the CCI entity code, only banks have one.
+type catalogTable struct {
+	table, code, name, entityCode string
+	editable                      bool
+}
+
+var catalogTables = map[string]catalogTable{
+	"banks":          {"banks", "bank_name", "full_name", "cci_entity_code", true},
+	"currencies":     {"currencies", "currency_type", "description", "", true},
+	"document-types": {"document_types", "document_type", "description", "", false},
+	"order-types":    {"order_type", "order_type", "description", "", false},
+	"order-states":   {"order_state", "order_state", "description", "", false},
+	"user-states":    {"users_state", "user_state", "description", "", false},
+	"kyc-tiers":      {"kyc_tiers", "kyc_tier", "description", "", false},
+}
+
+// IsBankCatalog reports whether the catalog entries need a CCI entity code
+func IsBankCatalog(catalog string) bool {
+	return catalogTables[catalog].entityCode != ""
+}
+
+// GetCatalog returns the entries of the catalog ordered by code, disabled entries are only returned if includeDisabled
+func (s *storePostgres) GetCatalog(catalog string, includeDisabled bool) ([]*CatalogEntry, error) {
+	t, ok := catalogTables[catalog]
+	if !ok {
+		return nil, ErrUnknownCatalog
+	}
+	enabled, where := "true", ""
+	if t.editable {
+		enabled = "enabled"
+		if !includeDisabled {
+			where = " where enabled"
+		}
+	}
+	entityCode := "''"
+	if t.entityCode != "" {
+		entityCode = t.entityCode
+	}
+	query := fmt.Sprintf("select %s, coalesce(%s, ''), %s, %s from %s%s order by %s", t.code, t.name, enabled, entityCode, t.table, where, t.code)
+	rows, err := s.db.Query(context.Background(), query)
+	if err != nil {
+		log.Printf("Error getting catalog %v: %v", catalog, err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	entries := []*CatalogEntry{}
+	for rows.Next() {
+		entry := &CatalogEntry{}
+		if err := rows.Scan(&entry.Code, &entry.Name, &entry.Enabled, &entry.CciEntityCode); err != nil {
+			log.Printf("Error scanning catalog %v: %v", catalog, err)
+			return nil, errors.New("internal database error")
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error getting catalog %v: %v", catalog, err)
+		return nil, errors.New("internal database error")
+	}
+	return entries, nil
+}
+
+// CreateCatalogEntry adds an enabled entry to an editable catalog and records it in the audit log
+func (s *storePostgres) CreateCatalogEntry(catalog string, entry *CatalogEntry, audit *AuditEntry) error {
+	t, err := editableCatalogTable(catalog)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateCatalogEntry:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	query := fmt.Sprintf("insert into %s (%s, %s, enabled) values ($1, $2, true)", t.table, t.code, t.name)
+	args := []interface{}{entry.Code, entry.Name}
+	if t.entityCode != "" {
+		query = fmt.Sprintf("insert into %s (%s, %s, %s, enabled) values ($1, $2, $3, true)", t.table, t.code, t.name, t.entityCode)
+		args = append(args, entry.CciEntityCode)
+	}
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrCatalogEntryExists
+		}
+		log.Printf("Error adding entry to catalog %v: %v", catalog, err)
+		return errors.New("internal database error")
+	}
+	entry.Enabled = true
+	if err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing catalog entry:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// SetCatalogEntryEnabled enables or disables an entry of an editable catalog and records it in the audit log.
+// Disabled entries are kept for the records referencing them but cannot be used by new ones.
+func (s *storePostgres) SetCatalogEntryEnabled(catalog, code string, enabled bool, audit *AuditEntry) error {
+	t, err := editableCatalogTable(catalog)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in SetCatalogEntryEnabled:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	query := fmt.Sprintf("update %s set enabled = $2 where %s = $1", t.table, t.code)
+	commandTag, err := tx.Exec(ctx, query, code, enabled)
+	if err != nil {
+		log.Printf("Error updating entry of catalog %v: %v", catalog, err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrCatalogEntryNotFound
+	}
+	if err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing catalog entry:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+func editableCatalogTable(catalog string) (catalogTable, error) {
+	t, ok := catalogTables[catalog]
+	if !ok {
+		return t, ErrUnknownCatalog
+	}
+	if !t.editable {
+		return t, ErrCatalogReadOnly
+	}
+	return t, nil
+}
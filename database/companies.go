@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+	"time"
+)
+
+var (
+	ErrCompanyNotFound        = errors.New("company not found")
+	ErrCompanyExists          = errors.New("A company already exists using the same RUC")
+	ErrRepresentativeNotFound = errors.New("user is not a representative of the company")
+	ErrLastRepresentative     = errors.New("a company must keep at least one representative")
+)
+
+// Company is a business customer operating under a RUC through its authorized representatives
+type Company struct {
+	Id              int                      `json:"id"`
+	LegalName       string                   `json:"legal_name"`
+	Ruc             string                   `json:"ruc"`
+	FiscalAddress   string                   `json:"fiscal_address"`
+	KycTier         string                   `json:"kyc_tier"` // selects the limits of the orders of the company
+	CreatedAt       time.Time                `json:"created_at"`
+	Representatives []*CompanyRepresentative `json:"representatives,omitempty"`
+}
+
+// CompanyRepresentative is a user authorized to operate on behalf of a company
+type CompanyRepresentative struct {
+	UserId       int       `json:"user_id"`
+	Email        string    `json:"email"`
+	Name         string    `json:"name"`
+	LastnameMain string    `json:"lastname_main"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateCompany creates the company with the user representativeUserId as its first representative
+func (s *storePostgres) CreateCompany(company *Company, representativeUserId int) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateCompany:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertCompany(ctx, tx, company, representativeUserId); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing company creation:", err)
+		return errors.New("internal database error")
+	}
+	log.Printf("Company %v successfully created with id %v", company.Ruc, company.Id)
+	return nil
+}
+
+// CreateUserWithCompany creates a user and the company it represents in the same transaction, used by company signups
+func (s *storePostgres) CreateUserWithCompany(user *User, company *Company) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateUserWithCompany:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertUser(ctx, tx, user); err != nil {
+		log.Println("Error captured from database layer in CreateUserWithCompany")
+		if createUserError := s.userPgError(err); createUserError != nil {
+			return createUserError
+		}
+		log.Println(err)
+		return errors.New("internal database error")
+	}
+	if err := insertCompany(ctx, tx, company, user.Id); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing company signup:", err)
+		return errors.New("internal database error")
+	}
+	log.Printf("User %v successfully created with company %v", user.Id, company.Id)
+	return nil
+}
+
+func insertCompany(ctx context.Context, tx pgx.Tx, company *Company, representativeUserId int) error {
+	err := tx.QueryRow(ctx, "insert into companies (legal_name, ruc, fiscal_address) values ($1, $2, $3) returning id, kyc_tier, created_at", company.LegalName, company.Ruc, company.FiscalAddress).Scan(&company.Id, &company.KycTier, &company.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "companies_ruc_key" {
+			return ErrCompanyExists
+		}
+		log.Println("Error creating company:", err)
+		return errors.New("internal database error")
+	}
+	_, err = tx.Exec(ctx, "insert into company_representatives (company_id, user_id) values ($1, $2)", company.Id, representativeUserId)
+	if err != nil {
+		log.Println("Error adding company representative:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// GetCompany returns the company with its representatives, or nil if it does not exist
+func (s *storePostgres) GetCompany(id int) (*Company, error) {
+	ctx := context.Background()
+	company := &Company{}
+	err := s.db.QueryRow(ctx, "select id, legal_name, ruc, fiscal_address, kyc_tier, created_at from companies where id = $1", id).Scan(&company.Id, &company.LegalName, &company.Ruc, &company.FiscalAddress, &company.KycTier, &company.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting company:", err)
+		return nil, errors.New("internal database error")
+	}
+
+	rows, err := s.db.Query(ctx, "select u.id, u.email, u.name, u.lastname_main, cr.created_at from company_representatives cr join users u on u.id = cr.user_id where cr.company_id = $1 and u.deleted_at is null order by cr.created_at", id)
+	if err != nil {
+		log.Println("Error getting company representatives:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	company.Representatives = []*CompanyRepresentative{}
+	for rows.Next() {
+		rep := &CompanyRepresentative{}
+		if err := rows.Scan(&rep.UserId, &rep.Email, &rep.Name, &rep.LastnameMain, &rep.CreatedAt); err != nil {
+			log.Println("Error scanning company representative:", err)
+			return nil, errors.New("internal database error")
+		}
+		company.Representatives = append(company.Representatives, rep)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error getting company representatives:", err)
+		return nil, errors.New("internal database error")
+	}
+	return company, nil
+}
+
+// GetUserCompanies returns the companies the user represents, without their representatives
+func (s *storePostgres) GetUserCompanies(userId int) ([]*Company, error) {
+	rows, err := s.db.Query(context.Background(), "select c.id, c.legal_name, c.ruc, c.fiscal_address, c.kyc_tier, c.created_at from companies c join company_representatives cr on cr.company_id = c.id where cr.user_id = $1 order by c.legal_name", userId)
+	if err != nil {
+		log.Println("Error listing user companies:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	companies := []*Company{}
+	for rows.Next() {
+		company := &Company{}
+		if err := rows.Scan(&company.Id, &company.LegalName, &company.Ruc, &company.FiscalAddress, &company.KycTier, &company.CreatedAt); err != nil {
+			log.Println("Error scanning company:", err)
+			return nil, errors.New("internal database error")
+		}
+		companies = append(companies, company)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing user companies:", err)
+		return nil, errors.New("internal database error")
+	}
+	return companies, nil
+}
+
+// IsCompanyRepresentative reports whether the user is authorized to operate on behalf of the company
+func (s *storePostgres) IsCompanyRepresentative(companyId, userId int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(context.Background(), "select exists (select 1 from company_representatives where company_id = $1 and user_id = $2)", companyId, userId).Scan(&exists)
+	if err != nil {
+		log.Println("Error captured from database layer in IsCompanyRepresentative:", err)
+		return false, errors.New("internal database error")
+	}
+	return exists, nil
+}
+
+// RemoveCompanyRepresentative revokes the authorization of the user, the last representative of a company cannot be removed
+func (s *storePostgres) RemoveCompanyRepresentative(companyId, userId int, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in RemoveCompanyRepresentative:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the company serializes concurrent removals, so two representatives cannot remove each other
+	if err := lockCompany(ctx, tx, companyId); err != nil {
+		return err
+	}
+	commandTag, err := tx.Exec(ctx, "delete from company_representatives where company_id = $1 and user_id = $2", companyId, userId)
+	if err != nil {
+		log.Println("Error removing company representative:", err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrRepresentativeNotFound
+	}
+	var remaining int
+	err = tx.QueryRow(ctx, "select count(*) from company_representatives cr join users u on u.id = cr.user_id where cr.company_id = $1 and u.deleted_at is null", companyId).Scan(&remaining)
+	if err != nil {
+		log.Println("Error counting company representatives:", err)
+		return errors.New("internal database error")
+	}
+	if remaining == 0 {
+		return ErrLastRepresentative
+	}
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing company representative removal:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+func lockCompany(ctx context.Context, tx pgx.Tx, companyId int) error {
+	var id int
+	err := tx.QueryRow(ctx, "select id from companies where id = $1 for update", companyId).Scan(&id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrCompanyNotFound
+		}
+		log.Println("Error locking company:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+var ErrInvitationNotFound = errors.New("invitation not found")
+
+// CompanyInvitation offers the user of an email to become a representative of a company, it takes effect only when
+// that user accepts it
+type CompanyInvitation struct {
+	Id              int        `json:"id"`
+	CompanyId       int        `json:"company_id"`
+	LegalName       string     `json:"legal_name,omitempty"` // of the company, when listed to the invited user
+	Email           string     `json:"email"`
+	InvitedByUserId int        `json:"invited_by_user_id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	AcceptedAt      *time.Time `json:"accepted_at,omitempty"`
+	DeclinedAt      *time.Time `json:"declined_at,omitempty"`
+}
+
+// CreateCompanyInvitation invites the email to represent the company and records it in the audit log. Inviting an
+// email with an open invitation renews it, whether the email belongs to a user is never checked.
+func (s *storePostgres) CreateCompanyInvitation(invitation *CompanyInvitation, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateCompanyInvitation:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	query := `insert into company_invitations (company_id, email, invited_by_user_id, expires_at) values ($1, $2, $3, $4)
+		on conflict (company_id, lower(email)) where accepted_at is null and declined_at is null
+		do update set invited_by_user_id = excluded.invited_by_user_id, expires_at = excluded.expires_at
+		returning id, created_at`
+	err = tx.QueryRow(ctx, query, invitation.CompanyId, invitation.Email, invitation.InvitedByUserId, invitation.ExpiresAt.UTC()).Scan(&invitation.Id, &invitation.CreatedAt)
+	if err != nil {
+		log.Println("Error creating company invitation:", err)
+		return errors.New("internal database error")
+	}
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["invitation_id"] = invitation.Id
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing company invitation:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// GetCompanyInvitations returns the open invitations sent to the email, newest first
+func (s *storePostgres) GetCompanyInvitations(email string) ([]*CompanyInvitation, error) {
+	query := `select i.id, i.company_id, c.legal_name, i.email, i.invited_by_user_id, i.created_at, i.expires_at
+		from company_invitations i join companies c on c.id = i.company_id
+		where lower(i.email) = lower($1) and i.accepted_at is null and i.declined_at is null and i.expires_at > now()
+		order by i.id desc`
+	rows, err := s.db.Query(context.Background(), query, email)
+	if err != nil {
+		log.Println("Error listing company invitations:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	invitations := []*CompanyInvitation{}
+	for rows.Next() {
+		i := &CompanyInvitation{}
+		if err := rows.Scan(&i.Id, &i.CompanyId, &i.LegalName, &i.Email, &i.InvitedByUserId, &i.CreatedAt, &i.ExpiresAt); err != nil {
+			log.Println("Error scanning company invitation:", err)
+			return nil, errors.New("internal database error")
+		}
+		invitations = append(invitations, i)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing company invitations:", err)
+		return nil, errors.New("internal database error")
+	}
+	return invitations, nil
+}
+
+// AcceptCompanyInvitation makes the user a representative of the company of its open invitation and records it in the
+// audit log. It returns ErrInvitationNotFound if the invitation is not open or was sent to another email.
+func (s *storePostgres) AcceptCompanyInvitation(invitationId int, user *User, entry *AuditEntry) (*CompanyInvitation, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in AcceptCompanyInvitation:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	invitation, err := closeCompanyInvitation(ctx, tx, "accepted_at", invitationId, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockCompany(ctx, tx, invitation.CompanyId); err != nil {
+		return nil, err
+	}
+	// Accepting is idempotent for users who became representatives by other means
+	_, err = tx.Exec(ctx, "insert into company_representatives (company_id, user_id) values ($1, $2) on conflict do nothing", invitation.CompanyId, user.Id)
+	if err != nil {
+		log.Println("Error adding company representative:", err)
+		return nil, errors.New("internal database error")
+	}
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["company_id"] = invitation.CompanyId
+	entry.Details["invitation_id"] = invitation.Id
+	entry.Details["invited_by_user_id"] = invitation.InvitedByUserId
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing company invitation:", err)
+		return nil, errors.New("internal database error")
+	}
+	return invitation, nil
+}
+
+// DeclineCompanyInvitation closes the open invitation sent to the email, returning ErrInvitationNotFound if there is none
+func (s *storePostgres) DeclineCompanyInvitation(invitationId int, email string) (*CompanyInvitation, error) {
+	return closeCompanyInvitation(context.Background(), s.db, "declined_at", invitationId, email)
+}
+
+// closeCompanyInvitation sets the accepted_at or declined_at column of the open invitation sent to the email
+func closeCompanyInvitation(ctx context.Context, db queryRower, column string, invitationId int, email string) (*CompanyInvitation, error) {
+	query := `update company_invitations set ` + column + ` = now()
+		where id = $1 and lower(email) = lower($2) and accepted_at is null and declined_at is null and expires_at > now()
+		returning id, company_id, email, invited_by_user_id, created_at, expires_at, accepted_at, declined_at`
+	i := &CompanyInvitation{}
+	err := db.QueryRow(ctx, query, invitationId, email).Scan(&i.Id, &i.CompanyId, &i.Email, &i.InvitedByUserId, &i.CreatedAt, &i.ExpiresAt, &i.AcceptedAt, &i.DeclinedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		log.Println("Error closing company invitation:", err)
+		return nil, errors.New("internal database error")
+	}
+	return i, nil
+}
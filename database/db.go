@@ -10,24 +10,91 @@ import (
 	"time"
 )
 
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrVersionConflict   = errors.New("user was modified by another request")
+	ErrUserStateConflict = errors.New("user state was changed by another request")
+	ErrUserHasOpenOrders = errors.New("user has orders in progress, the account can be closed once they are finished")
+)
+
 type User struct {
 	Id                int       `json:"user_id"`
 	Email             string    `json:"email"`
 	Role              string    `json:"role"`
-	Dni               string    `json:"dni"`
+	DocumentType      string    `json:"document_type"` // dni, ce, passport or ruc
+	Dni               string    `json:"dni"`           // number of the identity document
 	Name              string    `json:"name"`
 	LastnameMain      string    `json:"lastname_main"`
 	LastnameSecondary string    `json:"lastname_secondary"`
 	Address           string    `json:"address"`
 	CreatedAt         time.Time `json:"createdAt"`
+	State             string    `json:"state"`    // lifecycle state: registered, active, blocked or deleted
+	Version           int       `json:"version"`  // incremented on every update, used for optimistic concurrency
+	KycTier           string    `json:"kyc_tier"` // identity verification level, it sets the transaction limits of the user
 }
 
 type Store interface {
 	GetUser(email string) (*User, error)
+	GetUserByID(id int) (*User, error)
 	CreateUser(user *User) error
-	DeleteUser(id int) error
-	//GetUsers() ([]*User, error)
-	//UpdateUser(user *User) error
+	DeleteUser(id int, entry *AuditEntry) error
+	AnonymizeDeletedUsers(deletedBefore time.Time) (int64, error)
+	UpdateUserState(userId int, from, to string, entry *AuditEntry) error
+	UserHasBankAccounts(userId int) (bool, error)
+	GetUsers(filter *UserFilter) (*UserPage, error)
+	CreateRefreshTokenFamily(familyId string, userId int, token *RefreshToken) error
+	RotateRefreshToken(oldId string, newToken *RefreshToken) error
+	IsRefreshTokenFamilyActive(familyId string) (bool, error)
+	RevokeRefreshTokenFamily(familyId string, userId int, reason string) error
+	RevokeUserRefreshTokenFamilies(userId int, reason string) (int64, error)
+	UpdateUserRole(userId int, role string, entry *AuditEntry) error
+	CreateCompany(company *Company, representativeUserId int) error
+	CreateUserWithCompany(user *User, company *Company) error
+	GetCompany(id int) (*Company, error)
+	GetUserCompanies(userId int) ([]*Company, error)
+	IsCompanyRepresentative(companyId, userId int) (bool, error)
+	CreateCompanyInvitation(invitation *CompanyInvitation, entry *AuditEntry) error
+	GetCompanyInvitations(email string) ([]*CompanyInvitation, error)
+	AcceptCompanyInvitation(invitationId int, user *User, entry *AuditEntry) (*CompanyInvitation, error)
+	DeclineCompanyInvitation(invitationId int, email string) (*CompanyInvitation, error)
+	RemoveCompanyRepresentative(companyId, userId int, entry *AuditEntry) error
+	CreateBankAccount(account *BankAccount) error
+	GetBankAccount(id int) (*BankAccount, error)
+	GetBankByCciEntity(entityCode string) (string, error)
+	GetUserBankAccounts(userId int) ([]*BankAccount, error)
+	GetCompanyBankAccounts(companyId int) ([]*BankAccount, error)
+	DeleteBankAccount(id int) error
+	GetCatalog(catalog string, includeDisabled bool) ([]*CatalogEntry, error)
+	CreateCatalogEntry(catalog string, entry *CatalogEntry, audit *AuditEntry) error
+	SetCatalogEntryEnabled(catalog, code string, enabled bool, audit *AuditEntry) error
+	GetRates() ([]*ExchangeRate, error)
+	GetRate(pair string) (*ExchangeRate, error)
+	UpdateRate(rate *ExchangeRate, previous *ExchangeRate, entry *AuditEntry) error
+	GetRateHistory(pair string, from, to time.Time, limit int) ([]*RatePoint, error)
+	GetRateCandles(pair, interval string, from, to time.Time) ([]*RateCandle, error)
+	CreateQuote(quote *Quote) error
+	GetQuote(id string) (*Quote, error)
+	CreateOrder(order *Order) error
+	GetOrder(id int) (*Order, error)
+	GetOrders(filter *OrderFilter) (*OrderPage, error)
+	UpdateOrderState(orderId int, from, to string, actorUserId *int, reason string) error
+	ExpirePendingOrders(now time.Time, reason string) (int64, error)
+	GetOrderQueue(filter *OrderQueueFilter) ([]*Order, error)
+	ClaimNextOrder(operatorUserId int) (*Order, error)
+	ClaimOrder(orderId, operatorUserId int) (*Order, error)
+	ConfirmOrderTransfer(orderId, operatorUserId int) (*Order, error)
+	RecordOrderPayout(orderId, operatorUserId int, reference string, entry *AuditEntry) (*Order, error)
+	FinishOrder(orderId, operatorUserId int) (*Order, error)
+	RejectOrder(orderId, operatorUserId int, reason string) (*Order, error)
+	CreateTransferProof(proof *TransferProof) error
+	GetTransferProofs(orderId int) ([]*TransferProof, error)
+	GetTransferProof(orderId, proofId int) (*TransferProof, error)
+	UpdateUserKycTier(userId int, tier string, entry *AuditEntry) error
+	GetUserLimits(userId int, now time.Time) ([]*LimitUsage, error)
+	UpdateCompanyKycTier(companyId int, tier string, entry *AuditEntry) error
+	GetCompanyLimits(companyId int, now time.Time) ([]*LimitUsage, error)
+	CreateAuditEntry(entry *AuditEntry) error
+	UpdateUser(user *User, expectedVersion int, entry *AuditEntry) error
 }
 
 func NewPgStore(db *pgxpool.Pool) Store {
@@ -39,9 +106,20 @@ type storePostgres struct {
 	db *pgxpool.Pool
 }
 
-func (s *storePostgres) GetUser(email string) (*User, error) {
+// userColumns are the columns scanned by scanUser
+const userColumns = "id, email, role, document_type, dni, name, lastname_main, lastname_secondary, address, created_at, state, version, kyc_tier"
+
+func scanUser(row pgx.Row) (*User, error) {
 	var user User
-	err := s.db.QueryRow(context.Background(), "select id, email, role, dni, name, lastname_main, lastname_secondary, address, created_at from users where email = $1", email).Scan(&user.Id, &user.Email, &user.Role, &user.Dni, &user.Name, &user.LastnameMain, &user.LastnameSecondary, &user.Address, &user.CreatedAt)
+	err := row.Scan(&user.Id, &user.Email, &user.Role, &user.DocumentType, &user.Dni, &user.Name, &user.LastnameMain, &user.LastnameSecondary, &user.Address, &user.CreatedAt, &user.State, &user.Version, &user.KycTier)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *storePostgres) GetUser(email string) (*User, error) {
+	user, err := scanUser(s.db.QueryRow(context.Background(), "select "+userColumns+" from users where email = $1 and deleted_at is null", email))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			log.Println("db layer: User not found")
@@ -49,14 +127,24 @@ func (s *storePostgres) GetUser(email string) (*User, error) {
 		}
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
+}
+
+func (s *storePostgres) GetUserByID(id int) (*User, error) {
+	user, err := scanUser(s.db.QueryRow(context.Background(), "select "+userColumns+" from users where id = $1 and deleted_at is null", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Println("db layer: User not found")
+			return nil, nil
+		}
+		return nil, err
+	}
+	return user, nil
 }
 
 func (s *storePostgres) CreateUser(user *User) error {
 	log.Println("Creating a user record in DB")
-	var userId int
-	var created_at time.Time
-	err := s.db.QueryRow(context.Background(), "insert into users (email, role, dni, name, lastname_main, lastname_secondary, address) values ($1, $2, $3, $4, $5, $6, $7) returning id, created_at", user.Email, user.Role, user.Dni, user.Name, user.LastnameMain, user.LastnameSecondary, user.Address).Scan(&userId, &created_at)
+	err := insertUser(context.Background(), s.db, user)
 	if err != nil {
 		log.Println("Error captured from database layer in CreateUser")
 		if createUserError := s.userPgError(err); createUserError != nil {
@@ -65,13 +153,14 @@ func (s *storePostgres) CreateUser(user *User) error {
 		log.Println(err)
 		return errors.New("internal database error")
 	}
-	log.Println("User successfully created with id:", userId)
-	log.Printf("User with email %v successfully created at %v with id %v", user.Email, created_at, userId)
-	user.Id = userId
-	user.CreatedAt = created_at
+	log.Printf("User with email %v successfully created at %v with id %v", user.Email, user.CreatedAt, user.Id)
 	return nil
 }
 
+func insertUser(ctx context.Context, db queryRower, user *User) error {
+	return db.QueryRow(ctx, "insert into users (email, role, document_type, dni, name, lastname_main, lastname_secondary, address, state) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id, created_at, version, kyc_tier", user.Email, user.Role, user.DocumentType, user.Dni, user.Name, user.LastnameMain, user.LastnameSecondary, user.Address, user.State).Scan(&user.Id, &user.CreatedAt, &user.Version, &user.KycTier)
+}
+
 func (s *storePostgres) userPgError(err error) error {
 	var pgErr *pgconn.PgError
 	if !errors.As(err, &pgErr) {
@@ -83,32 +172,160 @@ func (s *storePostgres) userPgError(err error) error {
 		case "users_email_key":
 			return errors.New("A user already exists using the same email")
 		case "users_dni_key":
-			return errors.New("A user already exists using the same DNI")
+			return errors.New("A user already exists using the same identity document")
 		}
 	}
 	// We can handle more Postgres errors about constraints
 	return nil
 }
 
-func (s *storePostgres) DeleteUser(id int) error {
-	commandTag, err := s.db.Exec(context.Background(), "delete from users where id = $1", id)
+// DeleteUser closes the account of a user: it is soft deleted (state 'deleted' and deleted_at) to keep its history,
+// its sessions are revoked by the users_state_revoke_sessions trigger and its email and DNI become available for a
+// new account.
+// Users with pending or in progress orders cannot be deleted, it returns ErrUserHasOpenOrders.
+func (s *storePostgres) DeleteUser(id int, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in DeleteUser:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	commandTag, err := tx.Exec(ctx, "update users set state = 'deleted', deleted_at = now(), version = version + 1 where id = $1 and deleted_at is null", id)
 	if err != nil {
 		log.Println("Error captured from database layer in DeleteUser")
 		log.Println(err)
-		return errors.New("internal server error")
+		return errors.New("internal database error")
 	}
 	if commandTag.RowsAffected() != 1 {
-		return errors.New("user not found")
+		return ErrUserNotFound
+	}
+	// The user row is locked by the update, so CreateOrder cannot place a new order until this transaction ends
+	var hasOpenOrders bool
+	err = tx.QueryRow(ctx, "select exists (select 1 from orders where user_id = $1 and state in ('pending', 'inprogress'))", id).Scan(&hasOpenOrders)
+	if err != nil {
+		log.Println("Error checking open orders in DeleteUser:", err)
+		return errors.New("internal database error")
+	}
+	if hasOpenOrders {
+		return ErrUserHasOpenOrders
+	}
+	if entry != nil {
+		if err := insertAuditEntry(ctx, tx, entry); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing user deletion:", err)
+		return errors.New("internal database error")
 	}
 	return nil
 }
 
-func (s *storePostgres) UpdateUser(id int) error {
-	_, err := s.db.Exec(context.Background(), "update users set email = $1, role = $2, dni = $3, name = $4, lastname_main = $5, lastname_secondary = $6, address = $7 where id = $8", id)
+// UpdateUserState moves the user from state from to state to, returning ErrUserStateConflict if it is no longer in from.
+// Moving to 'blocked' revokes every session of the user, through the users_state_revoke_sessions trigger.
+// The audit entry is optional.
+func (s *storePostgres) UpdateUserState(userId int, from, to string, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
+		log.Println("Error starting transaction in UpdateUserState:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	commandTag, err := tx.Exec(ctx, "update users set state = $3, version = version + 1 where id = $1 and state = $2 and deleted_at is null", userId, from, to)
+	if err != nil {
+		log.Println("Error captured from database layer in UpdateUserState")
+		log.Println(err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		var exists bool
+		if err := tx.QueryRow(ctx, "select exists (select 1 from users where id = $1 and deleted_at is null)", userId).Scan(&exists); err != nil {
+			log.Println("Error captured from database layer in UpdateUserState:", err)
+			return errors.New("internal database error")
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+		return ErrUserStateConflict
+	}
+	if entry != nil {
+		if err := insertAuditEntry(ctx, tx, entry); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing user state change:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// UserHasBankAccounts reports whether the user has registered at least one personal bank account still in use
+func (s *storePostgres) UserHasBankAccounts(userId int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(context.Background(), "select exists (select 1 from bank_accounts where user_id = $1 and company_id is null and deleted_at is null)", userId).Scan(&exists)
+	if err != nil {
+		log.Println("Error captured from database layer in UserHasBankAccounts:", err)
+		return false, errors.New("internal database error")
+	}
+	return exists, nil
+}
+
+// AnonymizeDeletedUsers erases the personal data of the users deleted before deletedBefore and returns how many were anonymized
+func (s *storePostgres) AnonymizeDeletedUsers(deletedBefore time.Time) (int64, error) {
+	commandTag, err := s.db.Exec(context.Background(), "update users set email = 'anonymized-' || id, dni = '', name = '', lastname_main = '', lastname_secondary = '', address = '', anonymized_at = now() where deleted_at is not null and deleted_at < $1 and anonymized_at is null", deletedBefore.UTC())
+	if err != nil {
+		log.Println("Error captured from database layer in AnonymizeDeletedUsers")
+		log.Println(err)
+		return 0, errors.New("internal database error")
+	}
+	return commandTag.RowsAffected(), nil
+}
+
+// UpdateUser saves the fields of user only if its version is still expectedVersion, returning ErrVersionConflict otherwise.
+// The audit entry is optional and recorded in the same transaction.
+func (s *storePostgres) UpdateUser(user *User, expectedVersion int, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateUser:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, "update users set email = $2, role = $3, document_type = $4, dni = $5, name = $6, lastname_main = $7, lastname_secondary = $8, address = $9, version = version + 1 where id = $1 and version = $10 returning version", user.Id, user.Email, user.Role, user.DocumentType, user.Dni, user.Name, user.LastnameMain, user.LastnameSecondary, user.Address, expectedVersion).Scan(&user.Version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			if err := tx.QueryRow(ctx, "select exists (select 1 from users where id = $1)", user.Id).Scan(&exists); err != nil {
+				log.Println("Error captured from database layer in UpdateUser:", err)
+				return errors.New("internal database error")
+			}
+			if !exists {
+				return ErrUserNotFound
+			}
+			return ErrVersionConflict
+		}
 		log.Println("Error captured from database layer in UpdateUser")
+		if updateUserError := s.userPgError(err); updateUserError != nil {
+			return updateUserError
+		}
 		log.Println(err)
-		return errors.New("internal server error")
+		return errors.New("internal database error")
+	}
+
+	if entry != nil {
+		if err := insertAuditEntry(ctx, tx, entry); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing user update:", err)
+		return errors.New("internal database error")
 	}
 	return nil
 }
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+	"time"
+)
+
+var (
+	ErrLimitExceeded  = errors.New("transaction limit exceeded")
+	ErrUnknownKycTier = errors.New("unknown KYC tier")
+)
+
+// limitsLocation sets when days and months start for daily and monthly limits, Peru has no daylight saving time
+var limitsLocation = time.FixedZone("America/Lima", -5*60*60)
+
+// Limit periods, LimitExceededError.Period
+const (
+	LimitPeriodOrder   = "order"
+	LimitPeriodDaily   = "daily"
+	LimitPeriodMonthly = "monthly"
+)
+
+// LimitExceededError reports the limit an order would exceed
+type LimitExceededError struct {
+	Period    string // order, daily or monthly
+	Currency  string
+	Limit     money.Amount
+	Used      money.Amount // exchanged in the period before the order, zero for per order limits
+	Requested money.Amount
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Period == LimitPeriodOrder {
+		return fmt.Sprintf("%v: the limit per order is %v %v, requested %v %v", ErrLimitExceeded, e.Limit, e.Currency, e.Requested, e.Currency)
+	}
+	return fmt.Sprintf("%v: the %v limit is %v %v, %v %v already used, requested %v %v", ErrLimitExceeded, e.Period, e.Limit, e.Currency, e.Used, e.Currency, e.Requested, e.Currency)
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Code is a stable identifier of the exceeded limit for clients, e.g. daily_limit_exceeded
+func (e *LimitExceededError) Code() string {
+	return e.Period + "_limit_exceeded"
+}
+
+// LimitUsage is a limit of a currency applying to a user and how much of it the user used, nil limits are unbounded
+type LimitUsage struct {
+	KycTier       string        `json:"kyc_tier,omitempty"` // empty for global limits, applying to every tier
+	Currency      string        `json:"currency"`
+	PerOrder      *money.Amount `json:"per_order"`
+	Daily         *money.Amount `json:"daily"`
+	Monthly       *money.Amount `json:"monthly"`
+	UsedToday     money.Amount  `json:"used_today"`
+	UsedThisMonth money.Amount  `json:"used_this_month"`
+}
+
+// querier is implemented by both the pool and transactions
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// GetUserLimits returns the limits of the tier of the user and the global limits, with the amounts used at now by
+// the personal orders of the user
+func (s *storePostgres) GetUserLimits(userId int, now time.Time) ([]*LimitUsage, error) {
+	var tier string
+	err := s.db.QueryRow(context.Background(), "select kyc_tier from users where id = $1 and deleted_at is null", userId).Scan(&tier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		log.Println("Error getting KYC tier of user:", err)
+		return nil, errors.New("internal database error")
+	}
+	return limitUsages(context.Background(), s.db, userId, nil, tier, now)
+}
+
+// GetCompanyLimits returns the limits of the tier of the company and the global limits, with the amounts used at now
+// by the orders of the company, placed by any of its representatives
+func (s *storePostgres) GetCompanyLimits(companyId int, now time.Time) ([]*LimitUsage, error) {
+	var tier string
+	err := s.db.QueryRow(context.Background(), "select kyc_tier from companies where id = $1", companyId).Scan(&tier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCompanyNotFound
+		}
+		log.Println("Error getting KYC tier of company:", err)
+		return nil, errors.New("internal database error")
+	}
+	return limitUsages(context.Background(), s.db, 0, &companyId, tier, now)
+}
+
+// limitUsages sums, per currency, the orders since the start of the day and of the month of now: the orders of the
+// company if companyId is set, otherwise the personal orders of the user. Orders are counted in both of their
+// currencies, cancelled, expired and rejected orders are not counted.
+func limitUsages(ctx context.Context, db querier, userId int, companyId *int, tier string, now time.Time) ([]*LimitUsage, error) {
+	owner := "o.user_id = $2 and o.company_id is null"
+	var ownerId interface{} = userId
+	if companyId != nil {
+		owner = "o.company_id = $2"
+		ownerId = *companyId
+	}
+	local := now.In(limitsLocation)
+	dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, limitsLocation)
+	monthStart := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, limitsLocation)
+	query := fmt.Sprintf(`select coalesce(l.kyc_tier, ''), l.currency_type,
+			(l.per_order_limit * 100)::bigint, (l.daily_limit * 100)::bigint, (l.monthly_limit * 100)::bigint,
+			(coalesce(sum(u.amount) filter (where u.created_at >= $3), 0) * 100)::bigint,
+			(coalesce(sum(u.amount), 0) * 100)::bigint
+		from transaction_limits l
+		left join (
+			select o.created_at, e.currency_main as currency_type, o.amount_main as amount
+			from orders o join exchange_currency e on e.exchange_id = o.exchange_id
+			where %[1]s and o.created_at >= $4 and o.state not in ('cancelled', 'expired', 'rejected')
+			union all
+			select o.created_at, e.currency_secondary, o.amount_secondary
+			from orders o join exchange_currency e on e.exchange_id = o.exchange_id
+			where %[1]s and o.created_at >= $4 and o.state not in ('cancelled', 'expired', 'rejected')
+		) u on u.currency_type = l.currency_type
+		where l.kyc_tier = $1 or l.kyc_tier is null
+		group by l.id
+		order by l.currency_type, l.kyc_tier nulls last`, owner)
+	rows, err := db.Query(ctx, query, tier, ownerId, dayStart.UTC(), monthStart.UTC())
+	if err != nil {
+		log.Println("Error getting limit usage:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	usages := []*LimitUsage{}
+	for rows.Next() {
+		u := &LimitUsage{}
+		if err := rows.Scan(&u.KycTier, &u.Currency, &u.PerOrder, &u.Daily, &u.Monthly, &u.UsedToday, &u.UsedThisMonth); err != nil {
+			log.Println("Error scanning limit usage:", err)
+			return nil, errors.New("internal database error")
+		}
+		usages = append(usages, u)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error getting limit usage:", err)
+		return nil, errors.New("internal database error")
+	}
+	return usages, nil
+}
+
+// checkLimits returns a LimitExceededError if exchanging amounts, keyed by currency, exceeds any of the usages
+func checkLimits(usages []*LimitUsage, amounts map[string]money.Amount) error {
+	for _, u := range usages {
+		amount, ok := amounts[u.Currency]
+		if !ok {
+			continue
+		}
+		if u.PerOrder != nil && amount > *u.PerOrder {
+			return &LimitExceededError{Period: LimitPeriodOrder, Currency: u.Currency, Limit: *u.PerOrder, Requested: amount}
+		}
+		if u.Daily != nil && u.UsedToday+amount > *u.Daily {
+			return &LimitExceededError{Period: LimitPeriodDaily, Currency: u.Currency, Limit: *u.Daily, Used: u.UsedToday, Requested: amount}
+		}
+		if u.Monthly != nil && u.UsedThisMonth+amount > *u.Monthly {
+			return &LimitExceededError{Period: LimitPeriodMonthly, Currency: u.Currency, Limit: *u.Monthly, Used: u.UsedThisMonth, Requested: amount}
+		}
+	}
+	return nil
+}
+
+// UpdateUserKycTier sets the KYC tier of the user, the previous and new tiers are added to the audit entry
+func (s *storePostgres) UpdateUserKycTier(userId int, tier string, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateUserKycTier:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	var previousTier string
+	err = tx.QueryRow(ctx, "select kyc_tier from users where id = $1 and deleted_at is null for update", userId).Scan(&previousTier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		log.Println("Error reading KYC tier of user:", err)
+		return errors.New("internal database error")
+	}
+	_, err = tx.Exec(ctx, "update users set kyc_tier = $2, version = version + 1 where id = $1", userId, tier)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "users_kyc_tier_fkey" {
+			return ErrUnknownKycTier
+		}
+		log.Println("Error updating KYC tier of user:", err)
+		return errors.New("internal database error")
+	}
+
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["previous_kyc_tier"] = previousTier
+	entry.Details["new_kyc_tier"] = tier
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing KYC tier change:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// UpdateCompanyKycTier sets the KYC tier of the company, the previous and new tiers are added to the audit entry
+func (s *storePostgres) UpdateCompanyKycTier(companyId int, tier string, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateCompanyKycTier:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	var previousTier string
+	err = tx.QueryRow(ctx, "select kyc_tier from companies where id = $1 for update", companyId).Scan(&previousTier)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrCompanyNotFound
+		}
+		log.Println("Error reading KYC tier of company:", err)
+		return errors.New("internal database error")
+	}
+	_, err = tx.Exec(ctx, "update companies set kyc_tier = $2 where id = $1", companyId, tier)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == "companies_kyc_tier_fkey" {
+			return ErrUnknownKycTier
+		}
+		log.Println("Error updating KYC tier of company:", err)
+		return errors.New("internal database error")
+	}
+
+	if entry.Details == nil {
+		entry.Details = map[string]interface{}{}
+	}
+	entry.Details["company_id"] = companyId
+	entry.Details["previous_kyc_tier"] = previousTier
+	entry.Details["new_kyc_tier"] = tier
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing KYC tier change:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"testing"
+	"time"
+)
+
+func amountPtr(a money.Amount) *money.Amount {
+	return &a
+}
+
+func TestCheckLimits(t *testing.T) {
+	usages := []*LimitUsage{
+		{
+			KycTier:       "basic",
+			Currency:      "USD",
+			PerOrder:      amountPtr(100000), // 1,000.00
+			Daily:         amountPtr(300000),
+			Monthly:       amountPtr(1000000),
+			UsedToday:     160000,
+			UsedThisMonth: 850000,
+		},
+		{
+			// Global limit, applying on top of the limits of the tier
+			Currency:      "USD",
+			Daily:         amountPtr(250000),
+			UsedToday:     160000,
+			UsedThisMonth: 850000,
+		},
+		{
+			KycTier:  "basic",
+			Currency: "PEN",
+			PerOrder: amountPtr(370000),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		amounts map[string]money.Amount
+		want    *LimitExceededError // nil when the amounts are within the limits
+	}{
+		{
+			name:    "within every limit",
+			amounts: map[string]money.Amount{"USD": 50000, "PEN": 186350},
+		},
+		{
+			name:    "exactly the global daily limit",
+			amounts: map[string]money.Amount{"USD": 90000},
+		},
+		{
+			name:    "above the global daily limit",
+			amounts: map[string]money.Amount{"USD": 90001},
+			want:    &LimitExceededError{Period: LimitPeriodDaily, Currency: "USD", Limit: 250000, Used: 160000, Requested: 90001},
+		},
+		{
+			name:    "above the per order limit",
+			amounts: map[string]money.Amount{"USD": 100001},
+			want:    &LimitExceededError{Period: LimitPeriodOrder, Currency: "USD", Limit: 100000, Requested: 100001},
+		},
+		{
+			name:    "second currency of the order",
+			amounts: map[string]money.Amount{"USD": 10000, "PEN": 370001},
+			want:    &LimitExceededError{Period: LimitPeriodOrder, Currency: "PEN", Limit: 370000, Requested: 370001},
+		},
+		{
+			name:    "currency without limits",
+			amounts: map[string]money.Amount{"EUR": 99999999},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLimits(usages, tt.amounts)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var limitErr *LimitExceededError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got %v, want a LimitExceededError", err)
+			}
+			if *limitErr != *tt.want {
+				t.Errorf("got %+v, want %+v", limitErr, tt.want)
+			}
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("%v does not match ErrLimitExceeded", err)
+			}
+		})
+	}
+}
+
+func TestCheckLimitsPeriods(t *testing.T) {
+	usages := []*LimitUsage{{
+		Currency:      "USD",
+		Daily:         amountPtr(500000),
+		Monthly:       amountPtr(1000000),
+		UsedToday:     450000,
+		UsedThisMonth: 900000,
+	}}
+	tests := []struct {
+		amount money.Amount
+		period string // empty when the amount is within the limits
+	}{
+		{50000, ""}, // reaches the daily limit exactly
+		{50001, LimitPeriodDaily},
+		{100001, LimitPeriodDaily}, // the daily limit is checked before the monthly one
+	}
+	for _, tt := range tests {
+		err := checkLimits(usages, map[string]money.Amount{"USD": tt.amount})
+		var limitErr *LimitExceededError
+		if tt.period == "" {
+			if err != nil {
+				t.Errorf("checkLimits(%v) returned %v", tt.amount, err)
+			}
+		} else if !errors.As(err, &limitErr) || limitErr.Period != tt.period {
+			t.Errorf("checkLimits(%v) = %v, want the %v limit to be exceeded", tt.amount, err, tt.period)
+		}
+	}
+
+	usages[0].UsedToday = 0
+	err := checkLimits(usages, map[string]money.Amount{"USD": 100001})
+	var limitErr *LimitExceededError
+	if !errors.As(err, &limitErr) || limitErr.Code() != "monthly_limit_exceeded" {
+		t.Errorf("got %v, want the monthly limit to be exceeded", err)
+	}
+	if err := checkLimits(usages, map[string]money.Amount{"USD": 100000}); err != nil {
+		t.Errorf("reaching the monthly limit exactly returned %v", err)
+	}
+}
+
+// argsQuerier records the arguments of the query and fails it, for tests of the arguments sent to the database
+type argsQuerier struct {
+	args []interface{}
+}
+
+func (q *argsQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	q.args = args
+	return nil, errors.New("not a database")
+}
+
+func TestLimitUsagesPeriods(t *testing.T) {
+	tests := []struct {
+		name       string
+		now        time.Time
+		dayStart   time.Time
+		monthStart time.Time
+	}{
+		{
+			// 22:00 of September 30 in Lima, the day and the month are the ones of Lima
+			name:       "evening in Lima, next day in UTC",
+			now:        time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC),
+			dayStart:   time.Date(2026, 9, 30, 5, 0, 0, 0, time.UTC),
+			monthStart: time.Date(2026, 9, 1, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "midnight in Lima",
+			now:        time.Date(2026, 10, 1, 5, 0, 0, 0, time.UTC),
+			dayStart:   time.Date(2026, 10, 1, 5, 0, 0, 0, time.UTC),
+			monthStart: time.Date(2026, 10, 1, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "last minute of the year in Lima",
+			now:        time.Date(2027, 1, 1, 4, 59, 0, 0, time.UTC),
+			dayStart:   time.Date(2026, 12, 31, 5, 0, 0, 0, time.UTC),
+			monthStart: time.Date(2026, 12, 1, 5, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &argsQuerier{}
+			if _, err := limitUsages(context.Background(), q, 7, nil, "basic", tt.now); err == nil {
+				t.Fatal("the error of the query was not returned")
+			}
+			if len(q.args) != 4 {
+				t.Fatalf("got %v query arguments, want 4", len(q.args))
+			}
+			if q.args[0] != "basic" || q.args[1] != 7 {
+				t.Errorf("got tier %v and owner %v, want basic and 7", q.args[0], q.args[1])
+			}
+			if dayStart := q.args[2].(time.Time); !dayStart.Equal(tt.dayStart) {
+				t.Errorf("got day start %v, want %v", dayStart, tt.dayStart)
+			}
+			if monthStart := q.args[3].(time.Time); !monthStart.Equal(tt.monthStart) {
+				t.Errorf("got month start %v, want %v", monthStart, tt.monthStart)
+			}
+		})
+	}
+
+	// The orders of a company are selected by the company, whoever placed them
+	q := &argsQuerier{}
+	companyId := 3
+	limitUsages(context.Background(), q, 0, &companyId, "enhanced", time.Now())
+	if len(q.args) != 4 || q.args[1] != 3 {
+		t.Errorf("got query arguments %v, want company 3 as the owner", q.args)
+	}
+}
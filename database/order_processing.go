@@ -0,0 +1,309 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"strings"
+)
+
+var (
+	ErrOrderLocked         = errors.New("order is being processed by another operator")
+	ErrOrderNotAssigned    = errors.New("order is assigned to another operator")
+	ErrOwnOrder            = errors.New("operators cannot process their own orders")
+	ErrTransferNotReceived = errors.New("the transfer of the customer has not been confirmed")
+	ErrPayoutMissing       = errors.New("the reference of the outgoing transfer has not been recorded")
+)
+
+// orderQueueSorts maps the sort keys accepted by GetOrderQueue to their order by clause
+var orderQueueSorts = map[string]string{
+	"age":    "created_at, id",                   // oldest first
+	"amount": "amount_main desc, created_at, id", // largest first, requires a pair as amounts are in its main currency
+}
+
+// OrderQueueFilter describes the orders waiting for, or being worked by, operators
+type OrderQueueFilter struct {
+	State          string // pending or inprogress
+	Pair           string
+	Side           string
+	OperatorUserId *int   // inprogress orders claimed by the operator
+	SortBy         string // age or amount
+	Limit          int
+}
+
+func IsValidOrderQueueSort(sortBy string) bool {
+	_, ok := orderQueueSorts[sortBy]
+	return ok
+}
+
+// GetOrderQueue returns the pending or in progress orders matching the filter, pending orders past their transfer
+// window are left out as the expiry job is about to expire them, unless the customer sent a transfer proof
+func (s *storePostgres) GetOrderQueue(filter *OrderQueueFilter) ([]*Order, error) {
+	sort, ok := orderQueueSorts[filter.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid queue sort %q", filter.SortBy)
+	}
+	if filter.State != "pending" && filter.State != "inprogress" {
+		return nil, fmt.Errorf("invalid queue state %q", filter.State)
+	}
+	if filter.SortBy == "amount" && filter.Pair == "" {
+		return nil, errors.New("sorting the queue by amount requires a pair")
+	}
+	conditions := []string{"state = $1"}
+	args := []interface{}{filter.State}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(args))))
+	}
+	if filter.State == "pending" {
+		conditions = append(conditions, "(expires_at > now() or transfer_proof_at is not null)")
+	}
+	if filter.Pair != "" {
+		addCondition("exchange_id = %s", filter.Pair)
+	}
+	if filter.Side != "" {
+		addCondition("side = %s", filter.Side)
+	}
+	if filter.OperatorUserId != nil {
+		addCondition("operator_user_id = %s", *filter.OperatorUserId)
+	}
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf("select %s from orders where %s order by %s limit $%d", orderColumns, strings.Join(conditions, " and "), sort, len(args))
+
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Println("Error getting order queue:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	orders := []*Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			log.Println("Error scanning order:", err)
+			return nil, errors.New("internal database error")
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error getting order queue:", err)
+		return nil, errors.New("internal database error")
+	}
+	return orders, nil
+}
+
+// ClaimNextOrder assigns the oldest pending order to the operator, moving it to in progress. Orders locked by other
+// operators are skipped, so concurrent claims never return the same order. It returns nil if the queue is empty.
+func (s *storePostgres) ClaimNextOrder(operatorUserId int) (*Order, error) {
+	order, err := s.withLockedOrder("select "+orderColumns+" from orders where state = 'pending' and (expires_at > now() or transfer_proof_at is not null) and user_id <> $1 order by created_at, id limit 1 for update skip locked", []interface{}{operatorUserId},
+		func(ctx context.Context, tx pgx.Tx, order *Order) error {
+			return claimOrder(ctx, tx, order, operatorUserId)
+		})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return order, err
+}
+
+// ClaimOrder assigns the pending order to the operator, moving it to in progress.
+// It returns ErrOrderLocked if another operator is working on it.
+func (s *storePostgres) ClaimOrder(orderId, operatorUserId int) (*Order, error) {
+	return s.withOrder(orderId, func(ctx context.Context, tx pgx.Tx, order *Order) error {
+		if err := checkOrderClaim(order, operatorUserId); err != nil {
+			return err
+		}
+		return claimOrder(ctx, tx, order, operatorUserId)
+	})
+}
+
+func claimOrder(ctx context.Context, tx pgx.Tx, order *Order, operatorUserId int) error {
+	commandTag, err := tx.Exec(ctx, "update orders set state = 'inprogress', operator_user_id = $2, claimed_at = now(), updated_at = now() where id = $1 and state = 'pending' and (expires_at > now() or transfer_proof_at is not null)", order.Id, operatorUserId)
+	if err != nil {
+		log.Println("Error claiming order:", err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		// The transfer window ended, the expiry job is about to expire it
+		return ErrOrderStateConflict
+	}
+	from := order.State
+	return insertOrderEvent(ctx, tx, order.Id, &from, "inprogress", &operatorUserId, "")
+}
+
+// ConfirmOrderTransfer records that the transfer of the customer arrived, only the operator who claimed the order can
+func (s *storePostgres) ConfirmOrderTransfer(orderId, operatorUserId int) (*Order, error) {
+	return s.withOrder(orderId, func(ctx context.Context, tx pgx.Tx, order *Order) error {
+		if err := checkOrderAssignment(order, operatorUserId); err != nil {
+			return err
+		}
+		return execOrderUpdate(ctx, tx, "update orders set transfer_received_at = coalesce(transfer_received_at, now()), updated_at = now() where id = $1", order.Id)
+	})
+}
+
+// RecordOrderPayout records the reference of the transfer paying the counter amount to the customer, once its transfer
+// arrived. The reference is money leaving the house, so it is recorded in the order events and in the audit log.
+func (s *storePostgres) RecordOrderPayout(orderId, operatorUserId int, reference string, entry *AuditEntry) (*Order, error) {
+	return s.withOrder(orderId, func(ctx context.Context, tx pgx.Tx, order *Order) error {
+		if err := checkOrderPayout(order, operatorUserId); err != nil {
+			return err
+		}
+		if err := execOrderUpdate(ctx, tx, "update orders set payout_reference = $2, updated_at = now() where id = $1", order.Id, reference); err != nil {
+			return err
+		}
+		// The state does not change, the event keeps the payout in the history of the order
+		if err := insertOrderEvent(ctx, tx, order.Id, &order.State, order.State, &operatorUserId, "payout reference "+reference); err != nil {
+			return err
+		}
+		if entry.Details == nil {
+			entry.Details = map[string]interface{}{}
+		}
+		entry.TargetUserId = &order.UserId
+		if order.PayoutReference != "" {
+			entry.Details["previous_reference"] = order.PayoutReference
+		}
+		return insertAuditEntry(ctx, tx, entry)
+	})
+}
+
+// FinishOrder moves the order to finished once the transfer of the customer and the payout are recorded
+func (s *storePostgres) FinishOrder(orderId, operatorUserId int) (*Order, error) {
+	return s.withOrder(orderId, func(ctx context.Context, tx pgx.Tx, order *Order) error {
+		if err := checkOrderFinish(order, operatorUserId); err != nil {
+			return err
+		}
+		if err := execOrderUpdate(ctx, tx, "update orders set state = 'finished', updated_at = now() where id = $1", order.Id); err != nil {
+			return err
+		}
+		from := order.State
+		return insertOrderEvent(ctx, tx, order.Id, &from, "finished", &operatorUserId, "")
+	})
+}
+
+// RejectOrder moves the order to rejected: pending orders can be rejected by any operator, in progress orders only
+// by the operator who claimed them
+func (s *storePostgres) RejectOrder(orderId, operatorUserId int, reason string) (*Order, error) {
+	return s.withOrder(orderId, func(ctx context.Context, tx pgx.Tx, order *Order) error {
+		switch order.State {
+		case "pending":
+			if order.UserId == operatorUserId {
+				return ErrOwnOrder
+			}
+		case "inprogress":
+			if err := checkOrderAssignment(order, operatorUserId); err != nil {
+				return err
+			}
+		default:
+			return ErrOrderStateConflict
+		}
+		if err := execOrderUpdate(ctx, tx, "update orders set state = 'rejected', state_reason = $2, updated_at = now() where id = $1", order.Id, reason); err != nil {
+			return err
+		}
+		from := order.State
+		return insertOrderEvent(ctx, tx, order.Id, &from, "rejected", &operatorUserId, reason)
+	})
+}
+
+// checkOrderClaim allows claiming pending orders placed by someone else
+func checkOrderClaim(order *Order, operatorUserId int) error {
+	if order.UserId == operatorUserId {
+		return ErrOwnOrder
+	}
+	if order.State != "pending" {
+		return ErrOrderStateConflict
+	}
+	return nil
+}
+
+// checkOrderPayout allows recording the payout of an order once the transfer of the customer arrived
+func checkOrderPayout(order *Order, operatorUserId int) error {
+	if err := checkOrderAssignment(order, operatorUserId); err != nil {
+		return err
+	}
+	if order.TransferReceivedAt == nil {
+		return ErrTransferNotReceived
+	}
+	return nil
+}
+
+// checkOrderFinish allows finishing an order once the transfer of the customer and the payout are recorded
+func checkOrderFinish(order *Order, operatorUserId int) error {
+	if err := checkOrderPayout(order, operatorUserId); err != nil {
+		return err
+	}
+	if order.PayoutReference == "" {
+		return ErrPayoutMissing
+	}
+	return nil
+}
+
+// checkOrderAssignment allows working an in progress order only to the operator who claimed it
+func checkOrderAssignment(order *Order, operatorUserId int) error {
+	if order.State != "inprogress" {
+		return ErrOrderStateConflict
+	}
+	if order.OperatorUserId == nil || *order.OperatorUserId != operatorUserId {
+		return ErrOrderNotAssigned
+	}
+	return nil
+}
+
+func execOrderUpdate(ctx context.Context, tx pgx.Tx, query string, args ...interface{}) error {
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		log.Println("Error updating order:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// withOrder locks the order skipping it if another operator holds it, in which case it returns ErrOrderLocked
+func (s *storePostgres) withOrder(orderId int, update func(ctx context.Context, tx pgx.Tx, order *Order) error) (*Order, error) {
+	order, err := s.withLockedOrder("select "+orderColumns+" from orders where id = $1 for update skip locked", []interface{}{orderId}, update)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Either the order does not exist or its row is locked by another transaction
+		existing, err := s.GetOrder(orderId)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			return nil, ErrOrderNotFound
+		}
+		return nil, ErrOrderLocked
+	}
+	return order, err
+}
+
+// withLockedOrder runs update in a transaction on the order locked by lockQuery and returns the updated order.
+// It returns pgx.ErrNoRows if lockQuery locks no order.
+func (s *storePostgres) withLockedOrder(lockQuery string, args []interface{}, update func(ctx context.Context, tx pgx.Tx, order *Order) error) (*Order, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting order transaction:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	order, err := scanOrder(tx.QueryRow(ctx, lockQuery, args...))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		log.Println("Error locking order:", err)
+		return nil, errors.New("internal database error")
+	}
+	if err := update(ctx, tx, order); err != nil {
+		return nil, err
+	}
+	order, err = scanOrder(tx.QueryRow(ctx, "select "+orderColumns+" from orders where id = $1", order.Id))
+	if err != nil {
+		log.Println("Error reading updated order:", err)
+		return nil, errors.New("internal database error")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing order transaction:", err)
+		return nil, errors.New("internal database error")
+	}
+	return order, nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOrderProcessingChecks(t *testing.T) {
+	operator, other := 2, 3
+	received := time.Now()
+	pending := &Order{UserId: 7, State: "pending"}
+	ownPending := &Order{UserId: operator, State: "pending"}
+	claimed := &Order{UserId: 7, State: "inprogress", OperatorUserId: &operator}
+	claimedByOther := &Order{UserId: 7, State: "inprogress", OperatorUserId: &other}
+	transferred := &Order{UserId: 7, State: "inprogress", OperatorUserId: &operator, TransferReceivedAt: &received}
+	paid := &Order{UserId: 7, State: "inprogress", OperatorUserId: &operator, TransferReceivedAt: &received, PayoutReference: "OP-123"}
+	finished := &Order{UserId: 7, State: "finished", OperatorUserId: &operator, TransferReceivedAt: &received, PayoutReference: "OP-123"}
+
+	tests := []struct {
+		name  string
+		check func(order *Order, operatorUserId int) error
+		order *Order
+		want  error
+	}{
+		{"claim pending", checkOrderClaim, pending, nil},
+		{"claim own order", checkOrderClaim, ownPending, ErrOwnOrder},
+		{"claim claimed order", checkOrderClaim, claimed, ErrOrderStateConflict},
+		{"payout before the transfer", checkOrderPayout, claimed, ErrTransferNotReceived},
+		{"payout of an order of another operator", checkOrderPayout, claimedByOther, ErrOrderNotAssigned},
+		{"payout of a pending order", checkOrderPayout, pending, ErrOrderStateConflict},
+		{"payout", checkOrderPayout, transferred, nil},
+		{"finish before the transfer", checkOrderFinish, claimed, ErrTransferNotReceived},
+		{"finish before the payout", checkOrderFinish, transferred, ErrPayoutMissing},
+		{"finish of an order of another operator", checkOrderFinish, claimedByOther, ErrOrderNotAssigned},
+		{"finish", checkOrderFinish, paid, nil},
+		{"finish a finished order", checkOrderFinish, finished, ErrOrderStateConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check(tt.order, operator); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
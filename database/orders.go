@@ -0,0 +1,324 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrOrderStateConflict = errors.New("order state was changed concurrently")
+	ErrQuoteUnavailable   = errors.New("quote has expired or was already used")
+)
+
+// Order is an exchange of the locked terms of a quote: the customer transfers from its source account and
+// the exchange house pays the counter amount into its destination account
+type Order struct {
+	Id                       int          `json:"id"`
+	UserId                   int          `json:"user_id"`              // customer, or representative who placed a company order
+	CompanyId                *int         `json:"company_id,omitempty"` // set for company orders
+	QuoteId                  string       `json:"quote_id"`
+	Pair                     string       `json:"pair"`
+	Side                     string       `json:"side"` // order_type: buy or sell, from the point of view of the exchange house
+	Rate                     money.Rate   `json:"rate"`
+	AmountMain               money.Amount `json:"amount_main"`
+	AmountSecondary          money.Amount `json:"amount_secondary"`
+	SourceBankAccountId      int          `json:"source_bank_account_id"`      // account the customer sends the money from
+	DestinationBankAccountId int          `json:"destination_bank_account_id"` // account the house pays into
+	State                    string       `json:"state"`
+	StateReason              string       `json:"state_reason,omitempty"` // why the order was cancelled, expired or rejected
+	CreatedAt                time.Time    `json:"created_at"`
+	UpdatedAt                time.Time    `json:"updated_at"`
+	ExpiresAt                time.Time    `json:"expires_at"`                 // pending orders expire when the customer does not transfer in time
+	OperatorUserId           *int         `json:"operator_user_id,omitempty"` // operator who claimed the order
+	ClaimedAt                *time.Time   `json:"claimed_at,omitempty"`
+	TransferReceivedAt       *time.Time   `json:"transfer_received_at,omitempty"` // when the operator confirmed the transfer of the customer
+	PayoutReference          string       `json:"payout_reference,omitempty"`     // reference of the transfer paying the counter amount
+	TransferProofAt          *time.Time   `json:"transfer_proof_at,omitempty"`    // when the customer sent its first transfer proof
+	// UifReport flags operations the UIF (Unidad de Inteligencia Financiera) must be reported, it is never sent to
+	// customers as they must not be tipped off
+	UifReport bool `json:"-"`
+}
+
+// Rates and amounts are scanned in thousandths and cents to keep them exact
+const orderColumns = "id, user_id, company_id, quote_id, exchange_id, side, (rate * 1000)::bigint, (amount_main * 100)::bigint, (amount_secondary * 100)::bigint, source_bank_account_id, destination_bank_account_id, state, coalesce(state_reason, ''), created_at, updated_at, expires_at, operator_user_id, claimed_at, transfer_received_at, coalesce(payout_reference, ''), transfer_proof_at, uif_report"
+
+func scanOrder(row pgx.Row) (*Order, error) {
+	var order Order
+	err := row.Scan(&order.Id, &order.UserId, &order.CompanyId, &order.QuoteId, &order.Pair, &order.Side, &order.Rate, &order.AmountMain, &order.AmountSecondary,
+		&order.SourceBankAccountId, &order.DestinationBankAccountId, &order.State, &order.StateReason, &order.CreatedAt, &order.UpdatedAt, &order.ExpiresAt,
+		&order.OperatorUserId, &order.ClaimedAt, &order.TransferReceivedAt, &order.PayoutReference, &order.TransferProofAt, &order.UifReport)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// OrderFilter describes a page of the orders listing, newest first
+type OrderFilter struct {
+	UserId      *int // orders placed by the user
+	CompanyId   *int // orders of the company, placed by any of its representatives
+	State       string
+	UifReport   bool // only orders flagged for the UIF
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Limit       int
+	BeforeId    int // id of the last order of the previous page, 0 for the first page
+}
+
+type OrderPage struct {
+	Orders       []*Order
+	NextBeforeId int // 0 on the last page
+}
+
+// CreateOrder places the order consuming its quote, whose terms are copied into the order. In the same transaction
+// the customer must still be active and both bank accounts must still exist, they are locked until commit so they
+// cannot be closed concurrently. The order must fit the global limits and the limits of the KYC tier of the user, or
+// of the company for company orders. The user or company row is locked so concurrent orders charged to the same
+// limits are checked one after the other.
+// It returns ErrQuoteUnavailable, ErrUserStateConflict, ErrBankAccountNotFound or a LimitExceededError.
+func (s *storePostgres) CreateOrder(order *Order) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	var state, tier string
+	err = tx.QueryRow(ctx, "select state, kyc_tier from users where id = $1 and deleted_at is null for update", order.UserId).Scan(&state, &tier)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Println("Error captured from database layer in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+	if state != "active" {
+		return ErrUserStateConflict
+	}
+	if order.CompanyId != nil {
+		// Company orders are charged to the company, whichever representative places them
+		err = tx.QueryRow(ctx, "select kyc_tier from companies where id = $1 for update", *order.CompanyId).Scan(&tier)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrCompanyNotFound
+			}
+			log.Println("Error locking company in CreateOrder:", err)
+			return errors.New("internal database error")
+		}
+	}
+
+	rows, err := tx.Query(ctx, "select id from bank_accounts where id = any($1) and deleted_at is null for share", []int{order.SourceBankAccountId, order.DestinationBankAccountId})
+	if err != nil {
+		log.Println("Error locking bank accounts in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+	locked := 0
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Println("Error locking bank accounts in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+	if locked != 2 {
+		return ErrBankAccountNotFound
+	}
+
+	// Consuming the quote is conditional so a quote places a single order even under concurrent requests
+	err = tx.QueryRow(ctx, "update quotes set consumed_at = now() where id = $1 and user_id = $2 and consumed_at is null and expires_at > now() returning exchange_id, side, (rate * 1000)::bigint, (amount_main * 100)::bigint, (amount_secondary * 100)::bigint",
+		order.QuoteId, order.UserId).Scan(&order.Pair, &order.Side, &order.Rate, &order.AmountMain, &order.AmountSecondary)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrQuoteUnavailable
+		}
+		log.Println("Error consuming quote in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+
+	var currencyMain, currencySecondary string
+	err = tx.QueryRow(ctx, "select currency_main, currency_secondary from exchange_currency where exchange_id = $1", order.Pair).Scan(&currencyMain, &currencySecondary)
+	if err != nil {
+		log.Println("Error getting currencies of pair in CreateOrder:", err)
+		return errors.New("internal database error")
+	}
+	usages, err := limitUsages(ctx, tx, order.UserId, order.CompanyId, tier, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := checkLimits(usages, map[string]money.Amount{currencyMain: order.AmountMain, currencySecondary: order.AmountSecondary}); err != nil {
+		return err
+	}
+
+	query := `insert into orders (user_id, company_id, quote_id, exchange_id, side, rate, amount_main, amount_secondary, source_bank_account_id, destination_bank_account_id, state, expires_at, uif_report)
+		values ($1, $2, $3, $4, $5, $6::numeric / 1000, $7::numeric / 100, $8::numeric / 100, $9, $10, 'pending', $11, $12)
+		returning id, state, created_at, updated_at`
+	err = tx.QueryRow(ctx, query, order.UserId, order.CompanyId, order.QuoteId, order.Pair, order.Side, int64(order.Rate), int64(order.AmountMain), int64(order.AmountSecondary),
+		order.SourceBankAccountId, order.DestinationBankAccountId, order.ExpiresAt.UTC(), order.UifReport).Scan(&order.Id, &order.State, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		log.Println("Error captured from database layer in CreateOrder")
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "orders_quote_id_key" {
+			return ErrQuoteUnavailable
+		}
+		log.Println(err)
+		return errors.New("internal database error")
+	}
+	if err := insertOrderEvent(ctx, tx, order.Id, nil, order.State, &order.UserId, ""); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing order creation:", err)
+		return errors.New("internal database error")
+	}
+	log.Printf("Order %v successfully created for user %v", order.Id, order.UserId)
+	return nil
+}
+
+// insertOrderEvent records a state change of an order, from is nil on creation and actorUserId is nil for system changes
+func insertOrderEvent(ctx context.Context, tx pgx.Tx, orderId int, from *string, to string, actorUserId *int, reason string) error {
+	_, err := tx.Exec(ctx, "insert into order_events (order_id, from_state, to_state, actor_user_id, reason) values ($1, $2, $3, $4, nullif($5, ''))", orderId, from, to, actorUserId, reason)
+	if err != nil {
+		log.Println("Error inserting order event:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// GetOrder returns the order, or nil if it does not exist
+func (s *storePostgres) GetOrder(id int) (*Order, error) {
+	order, err := scanOrder(s.db.QueryRow(context.Background(), "select "+orderColumns+" from orders where id = $1", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting order:", err)
+		return nil, errors.New("internal database error")
+	}
+	return order, nil
+}
+
+// GetOrders returns a page of the orders matching the filter using keyset pagination on the id
+func (s *storePostgres) GetOrders(filter *OrderFilter) (*OrderPage, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(format string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(args))))
+	}
+	if filter.UserId != nil {
+		addCondition("user_id = %s", *filter.UserId)
+	}
+	if filter.CompanyId != nil {
+		addCondition("company_id = %s", *filter.CompanyId)
+	}
+	if filter.State != "" {
+		addCondition("state = %s", filter.State)
+	}
+	if filter.UifReport {
+		conditions = append(conditions, "uif_report")
+	}
+	if filter.CreatedFrom != nil {
+		addCondition("created_at >= %s", filter.CreatedFrom.UTC())
+	}
+	if filter.CreatedTo != nil {
+		addCondition("created_at < %s", filter.CreatedTo.UTC())
+	}
+	if filter.BeforeId > 0 {
+		addCondition("id < %s", filter.BeforeId)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " where " + strings.Join(conditions, " and ")
+	}
+	// One extra row tells whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf("select %s from orders%s order by id desc limit $%d", orderColumns, where, len(args))
+
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Println("Error listing orders:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	page := &OrderPage{Orders: []*Order{}}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			log.Println("Error scanning order:", err)
+			return nil, errors.New("internal database error")
+		}
+		page.Orders = append(page.Orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing orders:", err)
+		return nil, errors.New("internal database error")
+	}
+	if len(page.Orders) > filter.Limit {
+		page.Orders = page.Orders[:filter.Limit]
+		page.NextBeforeId = page.Orders[filter.Limit-1].Id
+	}
+	return page, nil
+}
+
+// UpdateOrderState moves the order from state from to state to, returning ErrOrderStateConflict if it is no longer in from.
+// actorUserId is nil for changes made by the system.
+func (s *storePostgres) UpdateOrderState(orderId int, from, to string, actorUserId *int, reason string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateOrderState:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	commandTag, err := tx.Exec(ctx, "update orders set state = $3, state_reason = nullif($4, ''), updated_at = now() where id = $1 and state = $2", orderId, from, to, reason)
+	if err != nil {
+		log.Println("Error captured from database layer in UpdateOrderState:", err)
+		return errors.New("internal database error")
+	}
+	if commandTag.RowsAffected() != 1 {
+		var exists bool
+		if err := tx.QueryRow(ctx, "select exists (select 1 from orders where id = $1)", orderId).Scan(&exists); err != nil {
+			log.Println("Error captured from database layer in UpdateOrderState:", err)
+			return errors.New("internal database error")
+		}
+		if !exists {
+			return ErrOrderNotFound
+		}
+		return ErrOrderStateConflict
+	}
+	if err := insertOrderEvent(ctx, tx, orderId, &from, to, actorUserId, reason); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing order state change:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// ExpirePendingOrders expires the pending orders whose transfer window ended before now, returning how many were expired.
+// Orders with a transfer proof are left for the operators, the customer may have paid them.
+func (s *storePostgres) ExpirePendingOrders(now time.Time, reason string) (int64, error) {
+	query := `with expired as (
+			update orders set state = 'expired', state_reason = $2, updated_at = now()
+			where state = 'pending' and expires_at <= $1 and transfer_proof_at is null
+			returning id
+		)
+		insert into order_events (order_id, from_state, to_state, reason)
+		select id, 'pending', 'expired', $2 from expired`
+	commandTag, err := s.db.Exec(context.Background(), query, now.UTC(), reason)
+	if err != nil {
+		log.Println("Error captured from database layer in ExpirePendingOrders:", err)
+		return 0, errors.New("internal database error")
+	}
+	return commandTag.RowsAffected(), nil
+}
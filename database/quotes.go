@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+// Quote locks the price of an exchange for a user until ExpiresAt, an order can consume it only once
+type Quote struct {
+	Id              string       `json:"id"`
+	UserId          int          `json:"user_id"`
+	Pair            string       `json:"pair"`
+	Side            string       `json:"side"` // order_type: buy or sell, from the point of view of the exchange house
+	Rate            money.Rate   `json:"rate"`
+	AmountMain      money.Amount `json:"amount_main"`      // in the main currency of the pair
+	AmountSecondary money.Amount `json:"amount_secondary"` // in the secondary currency of the pair
+	CreatedAt       time.Time    `json:"created_at"`
+	ExpiresAt       time.Time    `json:"expires_at"`
+	ConsumedAt      *time.Time   `json:"consumed_at,omitempty"`
+}
+
+// Rates and amounts are scanned in thousandths and cents to keep them exact
+const quoteColumns = "id, user_id, exchange_id, side, (rate * 1000)::bigint, (amount_main * 100)::bigint, (amount_secondary * 100)::bigint, created_at, expires_at, consumed_at"
+
+func scanQuote(row pgx.Row) (*Quote, error) {
+	var quote Quote
+	err := row.Scan(&quote.Id, &quote.UserId, &quote.Pair, &quote.Side, &quote.Rate, &quote.AmountMain, &quote.AmountSecondary, &quote.CreatedAt, &quote.ExpiresAt, &quote.ConsumedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// CreateQuote persists the quote, CreatedAt and ExpiresAt are set by the caller as they are part of the signed token
+func (s *storePostgres) CreateQuote(quote *Quote) error {
+	_, err := s.db.Exec(context.Background(), "insert into quotes (id, user_id, exchange_id, side, rate, amount_main, amount_secondary, created_at, expires_at) values ($1, $2, $3, $4, $5::numeric / 1000, $6::numeric / 100, $7::numeric / 100, $8, $9)",
+		quote.Id, quote.UserId, quote.Pair, quote.Side, int64(quote.Rate), int64(quote.AmountMain), int64(quote.AmountSecondary), quote.CreatedAt.UTC(), quote.ExpiresAt.UTC())
+	if err != nil {
+		log.Println("Error captured from database layer in CreateQuote:", err)
+		return errors.New("internal database error")
+	}
+	log.Printf("Quote %v successfully created for user %v", quote.Id, quote.UserId)
+	return nil
+}
+
+// GetQuote returns the quote, or nil if it does not exist
+func (s *storePostgres) GetQuote(id string) (*Quote, error) {
+	quote, err := scanQuote(s.db.QueryRow(context.Background(), "select "+quoteColumns+" from quotes where id = $1", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting quote:", err)
+		return nil, errors.New("internal database error")
+	}
+	return quote, nil
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/money"
+	"log"
+	"time"
+)
+
+// RatePoint are the prices of a pair from RecordedAt until the next point
+type RatePoint struct {
+	BuyPrice   money.Rate `json:"buy_price"`
+	SellPrice  money.Rate `json:"sell_price"`
+	RecordedAt time.Time  `json:"recorded_at"`
+}
+
+// Ohlc are the open, high, low and close prices of a candle
+type Ohlc struct {
+	Open  money.Rate `json:"open"`
+	High  money.Rate `json:"high"`
+	Low   money.Rate `json:"low"`
+	Close money.Rate `json:"close"`
+}
+
+// RateCandle aggregates the price changes of a pair in the interval starting at Start
+type RateCandle struct {
+	Start  time.Time `json:"start"`
+	Buy    Ohlc      `json:"buy"`
+	Sell   Ohlc      `json:"sell"`
+	Points int       `json:"points"` // price changes in the interval
+}
+
+// RateCandleIntervals are the intervals accepted by GetRateCandles, candles start at UTC boundaries
+var RateCandleIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// rateHistoryQuery selects the prices of pair $1 in [$2, $3), oldest first. It starts with the last change at or
+// before $2, which holds the price at $2 when it was set earlier.
+const rateHistoryQuery = `select (buy_price * 1000)::bigint, (sale_price * 1000)::bigint, recorded_at from (
+		(select buy_price, sale_price, recorded_at, id from exchange_rate_history
+		where exchange_id = $1 and recorded_at <= $2 order by recorded_at desc, id desc limit 1)
+		union all
+		(select buy_price, sale_price, recorded_at, id from exchange_rate_history
+		where exchange_id = $1 and recorded_at > $2 and recorded_at < $3)
+	) as history order by recorded_at, id`
+
+// GetRateHistory returns up to limit prices of the pair in [from, to), oldest first. The first point is the last
+// change at or before from, so the price at from is known even when it was set earlier.
+func (s *storePostgres) GetRateHistory(pair string, from, to time.Time, limit int) ([]*RatePoint, error) {
+	return s.queryRateHistory(rateHistoryQuery+" limit $4", pair, from.UTC(), to.UTC(), limit)
+}
+
+func (s *storePostgres) queryRateHistory(query string, args ...interface{}) ([]*RatePoint, error) {
+	rows, err := s.db.Query(context.Background(), query, args...)
+	if err != nil {
+		log.Println("Error getting rate history:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	points := []*RatePoint{}
+	for rows.Next() {
+		point := &RatePoint{}
+		if err := rows.Scan(&point.BuyPrice, &point.SellPrice, &point.RecordedAt); err != nil {
+			log.Println("Error scanning rate history:", err)
+			return nil, errors.New("internal database error")
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error getting rate history:", err)
+		return nil, errors.New("internal database error")
+	}
+	return points, nil
+}
+
+// GetRateCandles aggregates the price changes of the pair recorded in [from, to) in candles of the interval,
+// intervals without changes have no candle
+func (s *storePostgres) GetRateCandles(pair, interval string, from, to time.Time) ([]*RateCandle, error) {
+	step, ok := RateCandleIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("invalid candle interval %q", interval)
+	}
+	points, err := s.queryRateHistory(rateHistoryQuery, pair, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return buildRateCandles(points, from.UTC(), step), nil
+}
+
+// buildRateCandles aggregates points, oldest first, in candles of step. A point before from only carries the price
+// in effect at from. Each candle opens at the price in effect at its start: the close of the previous candle, or the
+// carried price for the first one.
+func buildRateCandles(points []*RatePoint, from time.Time, step time.Duration) []*RateCandle {
+	candles := []*RateCandle{}
+	var candle *RateCandle
+	var previous *RatePoint
+	for _, point := range points {
+		if point.RecordedAt.Before(from) {
+			previous = point
+			continue
+		}
+		start := point.RecordedAt.Truncate(step)
+		if candle == nil || !candle.Start.Equal(start) {
+			open := point
+			if previous != nil {
+				open = previous
+			}
+			candle = &RateCandle{Start: start, Buy: newOhlc(open.BuyPrice), Sell: newOhlc(open.SellPrice)}
+			candles = append(candles, candle)
+		}
+		candle.Buy.add(point.BuyPrice)
+		candle.Sell.add(point.SellPrice)
+		candle.Points++
+		previous = point
+	}
+	return candles
+}
+
+func newOhlc(open money.Rate) Ohlc {
+	return Ohlc{Open: open, High: open, Low: open, Close: open}
+}
+
+// add updates the candle with a price change
+func (o *Ohlc) add(price money.Rate) {
+	if price > o.High {
+		o.High = price
+	}
+	if price < o.Low {
+		o.Low = price
+	}
+	o.Close = price
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/angelmotta/flow-api/internal/money"
+	"testing"
+	"time"
+)
+
+func TestBuildRateCandles(t *testing.T) {
+	from := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int, buy, sell money.Rate) *RatePoint {
+		return &RatePoint{BuyPrice: buy, SellPrice: sell, RecordedAt: from.Add(time.Duration(minutes) * time.Minute)}
+	}
+	points := []*RatePoint{
+		at(-90, 3700, 3750), // price in effect at from, set the day before
+		at(10, 3720, 3770),
+		at(40, 3690, 3740),
+		// No change between 11:00 and 12:00
+		at(130, 3710, 3760),
+	}
+
+	candles := buildRateCandles(points, from, time.Hour)
+	want := []RateCandle{
+		{Start: from, Buy: Ohlc{Open: 3700, High: 3720, Low: 3690, Close: 3690}, Sell: Ohlc{Open: 3750, High: 3770, Low: 3740, Close: 3740}, Points: 2},
+		{Start: from.Add(2 * time.Hour), Buy: Ohlc{Open: 3690, High: 3710, Low: 3690, Close: 3710}, Sell: Ohlc{Open: 3740, High: 3760, Low: 3740, Close: 3760}, Points: 1},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("got %d candles, want %d", len(candles), len(want))
+	}
+	for i := range want {
+		if *candles[i] != want[i] {
+			t.Errorf("candle %d = %+v, want %+v", i, *candles[i], want[i])
+		}
+	}
+}
+
+func TestBuildRateCandlesWithoutCarriedPrice(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	points := []*RatePoint{
+		{BuyPrice: 3700, SellPrice: 3750, RecordedAt: from.Add(5 * time.Hour)},
+		{BuyPrice: 3680, SellPrice: 3730, RecordedAt: from.Add(6 * time.Hour)},
+		{BuyPrice: 3690, SellPrice: 3740, RecordedAt: from.Add(30 * time.Hour)},
+	}
+
+	// The first change of a pair opens its first candle
+	candles := buildRateCandles(points, from, 24*time.Hour)
+	want := []RateCandle{
+		{Start: from, Buy: Ohlc{Open: 3700, High: 3700, Low: 3680, Close: 3680}, Sell: Ohlc{Open: 3750, High: 3750, Low: 3730, Close: 3730}, Points: 2},
+		{Start: from.Add(24 * time.Hour), Buy: Ohlc{Open: 3680, High: 3690, Low: 3680, Close: 3690}, Sell: Ohlc{Open: 3730, High: 3740, Low: 3730, Close: 3740}, Points: 1},
+	}
+	if len(candles) != len(want) {
+		t.Fatalf("got %d candles, want %d", len(candles), len(want))
+	}
+	for i := range want {
+		if *candles[i] != want[i] {
+			t.Errorf("candle %d = %+v, want %+v", i, *candles[i], want[i])
+		}
+	}
+
+	if candles := buildRateCandles(points[:0], from, time.Hour); len(candles) != 0 {
+		t.Errorf("got %d candles without points", len(candles))
+	}
+}
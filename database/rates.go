@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+var ErrRateConflict = errors.New("exchange rate was modified by another request")
+
+// ExchangeRate are the prices the exchange house buys and sells the main currency of a pair at, in the secondary currency
+type ExchangeRate struct {
+	Pair                 string     `json:"pair"` // e.g. USD-PEN
+	CurrencyMain         string     `json:"currency_main"`
+	CurrencySecondary    string     `json:"currency_secondary"`
+	BuyPrice             money.Rate `json:"buy_price"`
+	SellPrice            money.Rate `json:"sell_price"`
+	MinimumValidTimeMins int        `json:"minimum_valid_time_mins"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+	ValidUntil           time.Time  `json:"valid_until"` // prices are guaranteed at least until this time
+}
+
+// Prices are scanned in thousandths to keep them exact
+const exchangeRateColumns = "exchange_id, currency_main, currency_secondary, (buy_price_currency_main * 1000)::bigint, (sale_price_currency_main * 1000)::bigint, minimum_valid_time_mins, updated_at"
+
+func scanExchangeRate(row pgx.Row) (*ExchangeRate, error) {
+	var rate ExchangeRate
+	err := row.Scan(&rate.Pair, &rate.CurrencyMain, &rate.CurrencySecondary, &rate.BuyPrice, &rate.SellPrice, &rate.MinimumValidTimeMins, &rate.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rate.ValidUntil = rate.UpdatedAt.Add(time.Duration(rate.MinimumValidTimeMins) * time.Minute)
+	return &rate, nil
+}
+
+// GetRates returns the current prices of every pair
+func (s *storePostgres) GetRates() ([]*ExchangeRate, error) {
+	rows, err := s.db.Query(context.Background(), "select "+exchangeRateColumns+" from exchange_currency order by exchange_id")
+	if err != nil {
+		log.Println("Error listing exchange rates:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	rates := []*ExchangeRate{}
+	for rows.Next() {
+		rate, err := scanExchangeRate(rows)
+		if err != nil {
+			log.Println("Error scanning exchange rate:", err)
+			return nil, errors.New("internal database error")
+		}
+		rates = append(rates, rate)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing exchange rates:", err)
+		return nil, errors.New("internal database error")
+	}
+	return rates, nil
+}
+
+// GetRate returns the current prices of the pair, or nil if it does not exist
+func (s *storePostgres) GetRate(pair string) (*ExchangeRate, error) {
+	rate, err := scanExchangeRate(s.db.QueryRow(context.Background(), "select "+exchangeRateColumns+" from exchange_currency where exchange_id = $1", pair))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting exchange rate:", err)
+		return nil, errors.New("internal database error")
+	}
+	return rate, nil
+}
+
+// UpdateRate sets the prices of rate.Pair if they are still the ones of previous, otherwise it returns ErrRateConflict.
+// updated_at is bumped, starting a new validity window.
+func (s *storePostgres) UpdateRate(rate *ExchangeRate, previous *ExchangeRate, entry *AuditEntry) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in UpdateRate:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx, "update exchange_currency set buy_price_currency_main = $2::numeric / 1000, sale_price_currency_main = $3::numeric / 1000, updated_at = now() where exchange_id = $1 and buy_price_currency_main = $4::numeric / 1000 and sale_price_currency_main = $5::numeric / 1000 returning "+exchangeRateColumns, rate.Pair, int64(rate.BuyPrice), int64(rate.SellPrice), int64(previous.BuyPrice), int64(previous.SellPrice))
+	updated, err := scanExchangeRate(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrRateConflict
+		}
+		log.Println("Error updating exchange rate:", err)
+		return errors.New("internal database error")
+	}
+	if err := insertAuditEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing exchange rate:", err)
+		return errors.New("internal database error")
+	}
+	*rate = *updated
+	return nil
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenRevoked  = errors.New("refresh token family revoked")
+	ErrRefreshTokenReused   = errors.New("refresh token already used")
+)
+
+// RefreshToken is the server-side record of an issued refresh token.
+// Every refresh token belongs to a family started at login, rotating a token adds a new one to the same family.
+type RefreshToken struct {
+	Id        string
+	FamilyId  string
+	UserId    int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// CreateRefreshTokenFamily starts a new refresh token family with its first token
+func (s *storePostgres) CreateRefreshTokenFamily(familyId string, userId int, token *RefreshToken) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in CreateRefreshTokenFamily:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "insert into refresh_token_families (id, user_id) values ($1, $2)", familyId, userId)
+	if err != nil {
+		log.Println("Error creating refresh token family:", err)
+		return errors.New("internal database error")
+	}
+	err = insertRefreshToken(ctx, tx, token)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing refresh token family:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// RotateRefreshToken marks the refresh token oldId as used and stores its replacement.
+// Presenting a token that was already rotated means it leaked: the whole family is revoked and ErrRefreshTokenReused returned.
+func (s *storePostgres) RotateRefreshToken(oldId string, newToken *RefreshToken) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Println("Error starting transaction in RotateRefreshToken:", err)
+		return errors.New("internal database error")
+	}
+	defer tx.Rollback(ctx)
+
+	var familyId string
+	var usedAt, revokedAt *time.Time
+	err = tx.QueryRow(ctx, "select t.family_id, t.used_at, f.revoked_at from refresh_tokens t join refresh_token_families f on f.id = t.family_id where t.id = $1 for update", oldId).Scan(&familyId, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrRefreshTokenNotFound
+		}
+		log.Println("Error reading refresh token:", err)
+		return errors.New("internal database error")
+	}
+	if familyId != newToken.FamilyId {
+		return ErrRefreshTokenNotFound
+	}
+	if revokedAt != nil {
+		return ErrRefreshTokenRevoked
+	}
+	if usedAt != nil {
+		log.Printf("db layer: refresh token %v reused, revoking family %v", oldId, familyId)
+		_, err = tx.Exec(ctx, "update refresh_token_families set revoked_at = now(), revoked_reason = 'reuse' where id = $1", familyId)
+		if err != nil {
+			log.Println("Error revoking refresh token family:", err)
+			return errors.New("internal database error")
+		}
+		if err := tx.Commit(ctx); err != nil {
+			log.Println("Error committing refresh token family revocation:", err)
+			return errors.New("internal database error")
+		}
+		return ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(ctx, "update refresh_tokens set used_at = now(), replaced_by = $2 where id = $1", oldId, newToken.Id)
+	if err != nil {
+		log.Println("Error marking refresh token as used:", err)
+		return errors.New("internal database error")
+	}
+	err = insertRefreshToken(ctx, tx, newToken)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Println("Error committing refresh token rotation:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// IsRefreshTokenFamilyActive reports whether the family (login session) exists and has not been revoked
+func (s *storePostgres) IsRefreshTokenFamilyActive(familyId string) (bool, error) {
+	var active bool
+	err := s.db.QueryRow(context.Background(), "select revoked_at is null from refresh_token_families where id = $1", familyId).Scan(&active)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		log.Println("Error reading refresh token family:", err)
+		return false, errors.New("internal database error")
+	}
+	return active, nil
+}
+
+// RevokeRefreshTokenFamily revokes a single login session of the user
+func (s *storePostgres) RevokeRefreshTokenFamily(familyId string, userId int, reason string) error {
+	_, err := s.db.Exec(context.Background(), "update refresh_token_families set revoked_at = now(), revoked_reason = $3 where id = $1 and user_id = $2 and revoked_at is null", familyId, userId, reason)
+	if err != nil {
+		log.Println("Error revoking refresh token family:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokenFamilies revokes every login session of the user and returns how many were active
+func (s *storePostgres) RevokeUserRefreshTokenFamilies(userId int, reason string) (int64, error) {
+	commandTag, err := s.db.Exec(context.Background(), "update refresh_token_families set revoked_at = now(), revoked_reason = $2 where user_id = $1 and revoked_at is null", userId, reason)
+	if err != nil {
+		log.Println("Error revoking refresh token families:", err)
+		return 0, errors.New("internal database error")
+	}
+	return commandTag.RowsAffected(), nil
+}
+
+func insertRefreshToken(ctx context.Context, tx pgx.Tx, token *RefreshToken) error {
+	err := tx.QueryRow(ctx, "insert into refresh_tokens (id, family_id, expires_at) values ($1, $2, $3) returning created_at", token.Id, token.FamilyId, token.ExpiresAt.UTC()).Scan(&token.CreatedAt)
+	if err != nil {
+		log.Println("Error creating refresh token:", err)
+		return errors.New("internal database error")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/angelmotta/flow-api/internal/money"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log"
+	"time"
+)
+
+var (
+	ErrTransferProofNotFound = errors.New("transfer proof not found")
+	ErrTransferProofExists   = errors.New("a proof with the same operation number was already sent for the order")
+)
+
+// TransferProof is the evidence a customer sends of the transfer paying an order, the file is kept in the blob storage
+type TransferProof struct {
+	Id              int          `json:"id"`
+	OrderId         int          `json:"order_id"`
+	UserId          int          `json:"user_id"`          // who uploaded it
+	OperationNumber string       `json:"operation_number"` // operation number printed by the bank of the customer
+	Amount          money.Amount `json:"amount"`
+	TransferredAt   time.Time    `json:"transferred_at"`
+	FileKey         string       `json:"-"` // key of the file in the blob storage
+	FileName        string       `json:"file_name"`
+	ContentType     string       `json:"content_type"`
+	FileSize        int64        `json:"file_size"`
+	CreatedAt       time.Time    `json:"created_at"`
+}
+
+const transferProofColumns = "id, order_id, user_id, operation_number, (amount * 100)::bigint, transferred_at, file_key, file_name, content_type, file_size, created_at"
+
+func scanTransferProof(row pgx.Row) (*TransferProof, error) {
+	var proof TransferProof
+	err := row.Scan(&proof.Id, &proof.OrderId, &proof.UserId, &proof.OperationNumber, &proof.Amount, &proof.TransferredAt, &proof.FileKey, &proof.FileName, &proof.ContentType, &proof.FileSize, &proof.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
+// CreateTransferProof attaches the proof to its order, only in progress orders and pending orders within their transfer
+// window accept proofs, otherwise it returns ErrOrderStateConflict. The order is marked in the same statement, which
+// takes it out of the expiry job: a concurrent expiry either wins the row lock first, failing the proof, or sees the mark.
+func (s *storePostgres) CreateTransferProof(proof *TransferProof) error {
+	query := `with marked as (
+			update orders set transfer_proof_at = coalesce(transfer_proof_at, now()), updated_at = now()
+			where id = $1 and (state = 'inprogress' or (state = 'pending' and expires_at > now()))
+			returning id
+		)
+		insert into order_transfer_proofs (order_id, user_id, operation_number, amount, transferred_at, file_key, file_name, content_type, file_size)
+		select id, $2, $3, $4::numeric / 100, $5, $6, $7, $8, $9 from marked
+		returning id, created_at`
+	err := s.db.QueryRow(context.Background(), query, proof.OrderId, proof.UserId, proof.OperationNumber, int64(proof.Amount), proof.TransferredAt.UTC(),
+		proof.FileKey, proof.FileName, proof.ContentType, proof.FileSize).Scan(&proof.Id, &proof.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderStateConflict
+		}
+		log.Println("Error captured from database layer in CreateTransferProof")
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "order_transfer_proofs_order_id_operation_number_key" {
+			return ErrTransferProofExists
+		}
+		log.Println(err)
+		return errors.New("internal database error")
+	}
+	log.Printf("Transfer proof %v successfully attached to order %v", proof.Id, proof.OrderId)
+	return nil
+}
+
+// GetTransferProofs returns the proofs of the order, oldest first
+func (s *storePostgres) GetTransferProofs(orderId int) ([]*TransferProof, error) {
+	rows, err := s.db.Query(context.Background(), "select "+transferProofColumns+" from order_transfer_proofs where order_id = $1 order by id", orderId)
+	if err != nil {
+		log.Println("Error listing transfer proofs:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	proofs := []*TransferProof{}
+	for rows.Next() {
+		proof, err := scanTransferProof(rows)
+		if err != nil {
+			log.Println("Error scanning transfer proof:", err)
+			return nil, errors.New("internal database error")
+		}
+		proofs = append(proofs, proof)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing transfer proofs:", err)
+		return nil, errors.New("internal database error")
+	}
+	return proofs, nil
+}
+
+// GetTransferProof returns the proof of the order, or nil if it does not exist
+func (s *storePostgres) GetTransferProof(orderId, proofId int) (*TransferProof, error) {
+	proof, err := scanTransferProof(s.db.QueryRow(context.Background(), "select "+transferProofColumns+" from order_transfer_proofs where id = $1 and order_id = $2", proofId, orderId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		log.Println("Error getting transfer proof:", err)
+		return nil, errors.New("internal database error")
+	}
+	return proof, nil
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// userSortColumns maps the sort keys accepted by GetUsers to their column and the cast applied to cursor values
+var userSortColumns = map[string]struct{ column, cast string }{
+	"id":         {"id", "integer"},
+	"created_at": {"created_at", "timestamp"},
+	"email":      {"email", "text"},
+	"name":       {"name", "text"},
+}
+
+// cursorTimeLayout keeps the microsecond precision of Postgres timestamps in cursors
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+// UserFilter describes a page of the users listing
+type UserFilter struct {
+	State       string
+	Role        string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	Email       string // exact match, case insensitive
+	EmailPrefix string
+	DniPrefix   string
+	NamePrefix  string // matched against name and both lastnames
+	SortBy      string // id, created_at, email or name
+	SortDesc    bool
+	Limit       int
+	After       *UserCursor // position of the last user of the previous page
+}
+
+// UserCursor is the position of a user in a sorted listing: the value of the sort column and the id as tie-breaker
+type UserCursor struct {
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+type UserPage struct {
+	Users      []*User
+	Total      int         // users matching the filter in all pages
+	NextCursor *UserCursor // nil on the last page
+}
+
+func IsValidUserSort(sortBy string) bool {
+	_, ok := userSortColumns[sortBy]
+	return ok
+}
+
+// Validate checks the cursor value against the type of the sort column, so GetUsers never sends Postgres a value its
+// cast would reject
+func (c *UserCursor) Validate(sortBy string) error {
+	sort, ok := userSortColumns[sortBy]
+	if !ok {
+		return fmt.Errorf("invalid sort column %q", sortBy)
+	}
+	if c.Id < 1 || c.Id > math.MaxInt32 {
+		return errors.New("invalid cursor id")
+	}
+	// Sorting by id only uses the id of the cursor, values of text columns reach Postgres as they are
+	var err error
+	switch sort.cast {
+	case "timestamp":
+		_, err = time.Parse(cursorTimeLayout, c.Value)
+	case "text":
+		if !utf8.ValidString(c.Value) || strings.ContainsRune(c.Value, 0) {
+			err = errors.New("invalid text")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid cursor value for sort %v: %w", sortBy, err)
+	}
+	return nil
+}
+
+// GetUsers returns a page of users matching the filter using keyset pagination
+func (s *storePostgres) GetUsers(filter *UserFilter) (*UserPage, error) {
+	sort, ok := userSortColumns[filter.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort column %q", filter.SortBy)
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(format string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+	if filter.State != "" {
+		addCondition("state = %s", filter.State)
+	}
+	if filter.Role != "" {
+		addCondition("role = %s", filter.Role)
+	}
+	if filter.CreatedFrom != nil {
+		addCondition("created_at >= %s", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		addCondition("created_at < %s", *filter.CreatedTo)
+	}
+	if filter.Email != "" {
+		addCondition("lower(email) = lower(%s)", filter.Email)
+	}
+	if filter.EmailPrefix != "" {
+		addCondition("email ilike %s", likePrefix(filter.EmailPrefix))
+	}
+	if filter.DniPrefix != "" {
+		addCondition("dni like %s", likePrefix(filter.DniPrefix))
+	}
+	if filter.NamePrefix != "" {
+		pattern := likePrefix(filter.NamePrefix)
+		addCondition("(name ilike %s or lastname_main ilike %s or lastname_secondary ilike %s)", pattern, pattern, pattern)
+	}
+
+	ctx := context.Background()
+	where := ""
+	if len(conditions) > 0 {
+		where = " where " + strings.Join(conditions, " and ")
+	}
+	page := &UserPage{Users: []*User{}}
+	err := s.db.QueryRow(ctx, "select count(*) from users"+where, args...).Scan(&page.Total)
+	if err != nil {
+		log.Println("Error counting users:", err)
+		return nil, errors.New("internal database error")
+	}
+
+	// Keyset pagination: continue strictly after the (sort value, id) of the cursor
+	direction, comparison := "asc", ">"
+	if filter.SortDesc {
+		direction, comparison = "desc", "<"
+	}
+	if filter.After != nil {
+		if sort.column == "id" {
+			addCondition("id "+comparison+" %s", filter.After.Id)
+		} else {
+			addCondition("("+sort.column+", id) "+comparison+" (%s::"+sort.cast+", %s)", filter.After.Value, filter.After.Id)
+		}
+		where = " where " + strings.Join(conditions, " and ")
+	}
+	// Fetch one extra row to know whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf("select %s from users%s order by %s %s, id %s limit $%d", userColumns, where, sort.column, direction, direction, len(args))
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		log.Println("Error listing users:", err)
+		return nil, errors.New("internal database error")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Println("Error scanning user:", err)
+			return nil, errors.New("internal database error")
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println("Error listing users:", err)
+		return nil, errors.New("internal database error")
+	}
+
+	if len(page.Users) > filter.Limit {
+		page.Users = page.Users[:filter.Limit]
+		last := page.Users[len(page.Users)-1]
+		page.NextCursor = &UserCursor{Value: userSortValue(last, filter.SortBy), Id: last.Id}
+	}
+	return page, nil
+}
+
+func userSortValue(user *User, sortBy string) string {
+	switch sortBy {
+	case "created_at":
+		return user.CreatedAt.Format(cursorTimeLayout)
+	case "email":
+		return user.Email
+	case "name":
+		return user.Name
+	default:
+		return ""
+	}
+}
+
+// likePrefix escapes the LIKE wildcards of prefix and appends '%'
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(prefix) + "%"
+}
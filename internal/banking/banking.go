@@ -0,0 +1,92 @@
+// Package banking validates Peruvian bank account numbers and CCIs (Código de Cuenta Interbancario)
+package banking
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidCci           = errors.New("invalid CCI")
+	ErrUnknownCciEntity     = errors.New("unknown CCI entity code")
+	ErrInvalidAccountNumber = errors.New("invalid account number")
+)
+
+// accountLengths are the digits of the account numbers of each bank, banks missing here accept 8 to 20 digits
+var accountLengths = map[string][]int{
+	"BCP":        {13, 14},
+	"INTERBANK":  {13},
+	"SCOTIABANK": {10},
+	"BBVA":       {18},
+	"NACION":     {11},
+}
+
+// NormalizeNumber removes the dashes and spaces banks print in account numbers and CCIs
+func NormalizeNumber(number string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(number)
+}
+
+// ValidateCci checks the format and both check digits of a normalized CCI:
+// entity (3 digits), office (3), account (12), check digit of entity and office (1) and check digit of account (1)
+func ValidateCci(cci string) error {
+	if len(cci) != 20 || !isDigits(cci) {
+		return fmt.Errorf("%w: it must have 20 digits", ErrInvalidCci)
+	}
+	if CciCheckDigit(cci[0:6]) != int(cci[18]-'0') || CciCheckDigit(cci[6:18]) != int(cci[19]-'0') {
+		return fmt.Errorf("%w: check digits do not match", ErrInvalidCci)
+	}
+	return nil
+}
+
+// CciCheckDigit computes a CCI check digit: digits are weighted 1, 2, 1, 2... adding the digits of each product
+func CciCheckDigit(digits string) int {
+	sum := 0
+	for i, c := range digits {
+		product := int(c-'0') * (1 + i%2)
+		sum += product/10 + product%10
+	}
+	return (10 - sum%10) % 10
+}
+
+// CciEntity returns the entity code of a valid CCI, its first 3 digits, which identifies the bank in the banks table
+func CciEntity(cci string) string {
+	return cci[:3]
+}
+
+// IsCciEntityCode reports whether code has the format of a CCI entity code
+func IsCciEntityCode(code string) bool {
+	return len(code) == 3 && isDigits(code)
+}
+
+// ValidateAccountNumber checks a normalized account number against the format of the bank
+func ValidateAccountNumber(bankName, number string) error {
+	if !isDigits(number) {
+		return fmt.Errorf("%w: it must only have digits", ErrInvalidAccountNumber)
+	}
+	lengths, ok := accountLengths[bankName]
+	if !ok {
+		if len(number) < 8 || len(number) > 20 {
+			return fmt.Errorf("%w: it must have 8 to 20 digits", ErrInvalidAccountNumber)
+		}
+		return nil
+	}
+	for _, length := range lengths {
+		if len(number) == length {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v accounts have %v digits", ErrInvalidAccountNumber, bankName, lengths)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package banking
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCciCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   int
+	}{
+		{"002193", 1},       // 0 + 0 + 2 + 2 + 9 + 6 = 19
+		{"001234567890", 3}, // products of two digits add their digits: 6*2 = 12 counts 3
+		{"000000", 0},
+		{"550000", 4}, // 5 + (1 + 0) = 6, adding the product 10 instead would give 5
+	}
+	for _, tt := range tests {
+		if got := CciCheckDigit(tt.digits); got != tt.want {
+			t.Errorf("CciCheckDigit(%q) = %d, want %d", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCci(t *testing.T) {
+	tests := []struct {
+		name  string
+		cci   string
+		valid bool
+	}{
+		{"valid", "00219300123456789013", true},
+		{"all zeros", "00000000000000000000", true},
+		{"wrong entity check digit", "00219300123456789023", false},
+		{"wrong account check digit", "00219300123456789014", false},
+		{"swapped account digits", "00219300123456789103", false},
+		{"too short", "0021930012345678901", false},
+		{"too long", "002193001234567890130", false},
+		{"with dashes", "002-193-001234567890-13", false},
+		{"with letters", "0021930012345678901A", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateCci(tt.cci)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateCci(%q) returned %v", tt.cci, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidCci) {
+				t.Errorf("ValidateCci(%q) = %v, want %v", tt.cci, err, ErrInvalidCci)
+			}
+		})
+	}
+}
+
+func TestValidateCciNormalized(t *testing.T) {
+	if err := ValidateCci(NormalizeNumber("002-193 001234567890-13")); err != nil {
+		t.Errorf("ValidateCci of a normalized CCI returned %v", err)
+	}
+}
+
+func TestIsCciEntityCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"002", true},
+		{"011", true},
+		{"02", false},
+		{"0021", false},
+		{"0A2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsCciEntityCode(tt.code); got != tt.want {
+			t.Errorf("IsCciEntityCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAccountNumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		bank   string
+		number string
+		valid  bool
+	}{
+		{"bcp 13 digits", "BCP", "1931234567890", true},
+		{"bcp 14 digits", "BCP", "19312345678901", true},
+		{"bcp 12 digits", "BCP", "193123456789", false},
+		{"bbva", "BBVA", "001101230100012345", true},
+		{"unknown bank within bounds", "MIBANCO", "12345678", true},
+		{"unknown bank too short", "MIBANCO", "1234567", false},
+		{"letters", "BCP", "193123456789A", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAccountNumber(tt.bank, tt.number)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateAccountNumber(%q, %q) returned %v", tt.bank, tt.number, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidAccountNumber) {
+				t.Errorf("ValidateAccountNumber(%q, %q) = %v, want %v", tt.bank, tt.number, err, ErrInvalidAccountNumber)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package blob stores the files uploaded by users, e.g. the transfer proofs of orders, behind a pluggable Store
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/config"
+	"io"
+	"log"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store persists blobs under keys chosen by the caller, keys are slash separated paths like "proofs/12/3f2a.pdf"
+type Store interface {
+	// Put writes the content of r under key, replacing any previous blob
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the blob of key, it returns ErrNotFound if it does not exist. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob of key, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromConfig creates the Store selected by c.BlobStorage
+func NewFromConfig(c *config.Config) (Store, error) {
+	switch c.BlobStorage {
+	case "local":
+		store, err := NewLocalStore(c.BlobLocalDir)
+		if err != nil {
+			return nil, fmt.Errorf("creating local blob storage: %w", err)
+		}
+		log.Printf("Blob storage: local directory %v", c.BlobLocalDir)
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown blob storage %q", c.BlobStorage)
+	}
+}
+
+// ValidateKey rejects empty keys, absolute keys and keys escaping their root with ".." segments
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"proofs/12/3f2a.pdf", true},
+		{"proof.pdf", true},
+		{"proofs/..pdf", true}, // dots within a name are fine
+		{"", false},
+		{"/etc/passwd", false},
+		{"../secret", false},
+		{"proofs/../../secret", false},
+		{"proofs/12/..", false},
+		{"./proofs/12/3f2a.pdf", false},
+		{"proofs//3f2a.pdf", false},
+		{"proofs/12/", false},
+		{`proofs\..\..\secret`, false},
+		{`C:\secret`, false},
+	}
+	for _, tt := range tests {
+		err := ValidateKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateKey(%q) returned %v", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ValidateKey(%q) = %v, want %v", tt.key, err, ErrInvalidKey)
+		}
+	}
+}
+
+func TestLocalStoreStaysWithinItsDirectory(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "blobs")
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "../escaped", strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Put of a key escaping the directory returned %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside of the store directory: %v", err)
+	}
+
+	if err := store.Put(ctx, "proofs/1/a.pdf", strings.NewReader("proof")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := store.Get(ctx, "proofs/1/a.pdf")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "proof" {
+		t.Errorf("Get returned %q, %v", b, err)
+	}
+	if err := store.Delete(ctx, "proofs/1/a.pdf"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "proofs/1/a.pdf"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted blob returned %v, want %v", err, ErrNotFound)
+	}
+	if err := store.Delete(ctx, "proofs/1/a.pdf"); err != nil {
+		t.Errorf("Delete of a missing blob returned %v", err)
+	}
+}
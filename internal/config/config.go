@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,8 +16,51 @@ type Config struct {
 	PgPort           string
 	PgDatabase       string
 	PgSslMode        string
-	GOauthClientId   string
+	GOauthClientId   string // Google login is enabled when set
+	FbAppId          string // Facebook login is enabled when set
+	FbAppSecret      string
+	FbGraphUrl       string
+	DevIdpEnabled    bool   // enables the "dev" identity provider, never set it in production
+	DevIdpSecret     string // secret signing the tokens accepted by the "dev" identity provider
+	OidcProviders    []OidcProvider
 	HttpMaxBodyBytes int64
+	// UserRetentionDays is how long the personal data of deleted users is kept before being anonymized
+	UserRetentionDays int
+	JwtSigningAlg     string // HS256, RS256 or ES256
+	JwtSigningKeyId   string // 'kid' header stamped in every token
+	// JwtSigningKey holds the HS256 secret or the PEM encoded private key, JwtSigningKeyFile takes precedence when set
+	JwtSigningKey     string
+	JwtSigningKeyFile string
+	// JwtRetiredKeys are keys no longer used for signing, still accepted for verification until RetiredUntil
+	JwtRetiredKeys []JwtRetiredKey
+	// RateMaxChangePercent bounds how much an operator may move a price in a single update
+	RateMaxChangePercent int
+	// OrderTransferWindowMins is how long customers have to transfer the money of an order before it expires
+	OrderTransferWindowMins int
+	// BlobStorage selects where uploaded files are stored, only "local" is supported: files below BlobLocalDir
+	BlobStorage  string
+	BlobLocalDir string
+	// ProofMaxBytes bounds the file of a transfer proof, uploads are not subject to HttpMaxBodyBytes
+	ProofMaxBytes int64
+	// UifThresholdUsd flags single operations above this many dollars for reporting to the UIF
+	UifThresholdUsd int
+}
+
+// OidcProvider is an OpenID Connect issuer users can login with, clients select it by Name in the 'idp' field.
+// For multi-tenant issuers (e.g. Microsoft) IssuerUrl must be the tenant specific issuer.
+type OidcProvider struct {
+	Name      string
+	IssuerUrl string
+	ClientId  string
+}
+
+// JwtRetiredKey is a previous signing key kept around until the tokens it signed expire.
+// File is a PEM file (private or public key) for RS256/ES256, or a file holding the secret for HS256.
+type JwtRetiredKey struct {
+	Id           string
+	Alg          string
+	File         string
+	RetiredUntil time.Time
 }
 
 func Init() *Config {
@@ -31,7 +77,32 @@ func (c *Config) loadConfig() {
 	c.PgDatabase = getEnvStr("PGDATABASE")
 	c.PgSslMode = getEnvStr("PGSSLMODE") // disable
 	c.HttpMaxBodyBytes = 1024 * 1024
-	c.GOauthClientId = getEnvStr("GOAUTHCLIENTID")
+	c.UserRetentionDays = getEnvIntOrDefault("USERRETENTIONDAYS", 3650)
+	c.GOauthClientId = os.Getenv("GOAUTHCLIENTID")
+	c.FbAppId = os.Getenv("FBAPPID")
+	if c.FbAppId != "" {
+		c.FbAppSecret = getEnvStr("FBAPPSECRET")
+	}
+	c.FbGraphUrl = os.Getenv("FBGRAPHURL") // defaults to the public Graph API
+	c.DevIdpEnabled = os.Getenv("DEVIDPENABLED") == "true"
+	if c.DevIdpEnabled {
+		c.DevIdpSecret = getEnvStr("DEVIDPSECRET")
+	}
+	c.OidcProviders = loadOidcProviders(os.Getenv("OIDCPROVIDERS"))
+	c.JwtSigningAlg = getEnvStrOrDefault("JWTSIGNINGALG", "HS256")
+	c.JwtSigningKeyId = getEnvStrOrDefault("JWTSIGNINGKID", "flow-1")
+	c.JwtSigningKey = os.Getenv("JWTSIGNINGKEY")
+	c.JwtSigningKeyFile = os.Getenv("JWTSIGNINGKEYFILE")
+	if c.JwtSigningKey == "" && c.JwtSigningKeyFile == "" {
+		log.Panicf("Error loading Config: you must set 'JWTSIGNINGKEY' or 'JWTSIGNINGKEYFILE' Environment Variable")
+	}
+	c.JwtRetiredKeys = parseRetiredKeys(os.Getenv("JWTRETIREDKEYS"))
+	c.RateMaxChangePercent = getEnvIntOrDefault("RATEMAXCHANGEPERCENT", 5)
+	c.OrderTransferWindowMins = getEnvIntOrDefault("ORDERTRANSFERWINDOWMINS", 60)
+	c.BlobStorage = getEnvStrOrDefault("BLOBSTORAGE", "local")
+	c.BlobLocalDir = getEnvStrOrDefault("BLOBLOCALDIR", "./data/blobs")
+	c.ProofMaxBytes = int64(getEnvIntOrDefault("PROOFMAXBYTES", 5*1024*1024))
+	c.UifThresholdUsd = getEnvIntOrDefault("UIFTHRESHOLDUSD", 10000)
 }
 
 func (c *Config) GetPgDsn() string {
@@ -46,3 +117,72 @@ func getEnvStr(key string) string {
 	}
 	return value
 }
+
+func getEnvStrOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Panicf("Error loading Config: '%s' Environment Variable must be an integer", key)
+	}
+	return n
+}
+
+// loadOidcProviders reads the issuer and client id of each provider in the comma separated list of names,
+// e.g. OIDCPROVIDERS=microsoft requires OIDCMICROSOFTISSUER and OIDCMICROSOFTCLIENTID
+func loadOidcProviders(names string) []OidcProvider {
+	var providers []OidcProvider
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "google" || name == "facebook" || name == "dev" {
+			log.Panicf("Error loading Config: OIDC provider name '%s' is reserved", name)
+		}
+		prefix := "OIDC" + strings.ToUpper(name)
+		providers = append(providers, OidcProvider{
+			Name:      name,
+			IssuerUrl: getEnvStr(prefix + "ISSUER"),
+			ClientId:  getEnvStr(prefix + "CLIENTID"),
+		})
+	}
+	return providers
+}
+
+// parseRetiredKeys parses a comma separated list of 'kid|alg|file|retired_until' entries,
+// retired_until is an RFC 3339 timestamp, e.g. 'flow-1|HS256|/run/secrets/flow-1|2024-01-31T00:00:00Z'
+func parseRetiredKeys(value string) []JwtRetiredKey {
+	var keys []JwtRetiredKey
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, "|")
+		if len(fields) != 4 {
+			log.Panicf("Error loading Config: invalid 'JWTRETIREDKEYS' entry %q, expected 'kid|alg|file|retired_until'", entry)
+		}
+		retiredUntil, err := time.Parse(time.RFC3339, fields[3])
+		if err != nil {
+			log.Panicf("Error loading Config: invalid retired_until in 'JWTRETIREDKEYS' entry %q: %v", entry, err)
+		}
+		keys = append(keys, JwtRetiredKey{
+			Id:           fields[0],
+			Alg:          fields[1],
+			File:         fields[2],
+			RetiredUntil: retiredUntil,
+		})
+	}
+	return keys
+}
@@ -0,0 +1,124 @@
+// Package documents validates the Peruvian identity documents accepted to register users
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Document types, stored in users.document_type
+const (
+	TypeDni      = "dni"      // Documento Nacional de Identidad: 8 digits
+	TypeCe       = "ce"       // Carné de Extranjería: 9 to 12 alphanumeric characters
+	TypePassport = "passport" // Passport: 6 to 12 alphanumeric characters
+	TypeRuc      = "ruc"      // Registro Único de Contribuyentes: 11 digits with modulo 11 check digit
+)
+
+var (
+	ErrUnknownType   = errors.New("unknown document type")
+	ErrInvalidNumber = errors.New("invalid document number")
+)
+
+// rucWeights are the SUNAT weights applied to the first 10 digits of a RUC
+var rucWeights = [10]int{5, 4, 3, 2, 7, 6, 5, 4, 3, 2}
+
+// rucPrefixes are the taxpayer kinds a RUC may start with: 10 individuals, 15/16/17 other individuals, 20 companies
+var rucPrefixes = []string{"10", "15", "16", "17", "20"}
+
+// Types returns the supported document types
+func Types() []string {
+	return []string{TypeDni, TypeCe, TypePassport, TypeRuc}
+}
+
+func IsValidType(docType string) bool {
+	for _, t := range Types() {
+		if t == docType {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize removes the surrounding spaces of a document number and uppercases its letters
+func Normalize(number string) string {
+	return strings.ToUpper(strings.TrimSpace(number))
+}
+
+// Validate checks the format of a normalized document number, and its check digit for RUCs
+func Validate(docType, number string) error {
+	switch docType {
+	case TypeDni:
+		if len(number) != 8 || !isDigits(number) {
+			return fmt.Errorf("%w: DNI must have 8 digits", ErrInvalidNumber)
+		}
+	case TypeCe:
+		if len(number) < 9 || len(number) > 12 || !isAlphanumeric(number) {
+			return fmt.Errorf("%w: CE must have 9 to 12 letters or digits", ErrInvalidNumber)
+		}
+	case TypePassport:
+		if len(number) < 6 || len(number) > 12 || !isAlphanumeric(number) {
+			return fmt.Errorf("%w: passport must have 6 to 12 letters or digits", ErrInvalidNumber)
+		}
+	case TypeRuc:
+		return validateRuc(number)
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownType, docType)
+	}
+	return nil
+}
+
+func validateRuc(number string) error {
+	if len(number) != 11 || !isDigits(number) {
+		return fmt.Errorf("%w: RUC must have 11 digits", ErrInvalidNumber)
+	}
+	validPrefix := false
+	for _, prefix := range rucPrefixes {
+		if strings.HasPrefix(number, prefix) {
+			validPrefix = true
+			break
+		}
+	}
+	if !validPrefix {
+		return fmt.Errorf("%w: RUC must start with 10, 15, 16, 17 or 20", ErrInvalidNumber)
+	}
+	if RucCheckDigit(number[:10]) != int(number[10]-'0') {
+		return fmt.Errorf("%w: RUC check digit does not match", ErrInvalidNumber)
+	}
+	return nil
+}
+
+// RucCheckDigit computes the modulo 11 check digit of the first 10 digits of a RUC
+func RucCheckDigit(digits string) int {
+	sum := 0
+	for i, w := range rucWeights {
+		sum += int(digits[i]-'0') * w
+	}
+	digit := 11 - sum%11
+	switch digit {
+	case 10:
+		return 0
+	case 11:
+		return 1
+	default:
+		return digit
+	}
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package documents
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRucCheckDigit(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   int
+	}{
+		{"2013131295", 5}, // 11 - 94 % 11
+		{"2010007097", 0}, // 11 - 89 % 11 is 10, which maps to 0
+		{"1000000003", 1}, // 11 - 11 % 11 is 11, which maps to 1
+		{"1046525123", 4},
+	}
+	for _, tt := range tests {
+		if got := RucCheckDigit(tt.digits); got != tt.want {
+			t.Errorf("RucCheckDigit(%q) = %d, want %d", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		docType string
+		number  string
+		wantErr error
+	}{
+		{"dni", TypeDni, "46525123", nil},
+		{"dni too short", TypeDni, "4652512", ErrInvalidNumber},
+		{"dni with letters", TypeDni, "4652512A", ErrInvalidNumber},
+		{"ce", TypeCe, "001234567", nil},
+		{"ce too short", TypeCe, "00123456", ErrInvalidNumber},
+		{"ce with symbols", TypeCe, "00123-4567", ErrInvalidNumber},
+		{"passport", TypePassport, "AB12345", nil},
+		{"passport too long", TypePassport, "AB12345678901", ErrInvalidNumber},
+		{"passport lowercase", TypePassport, "ab12345", ErrInvalidNumber},
+		{"company ruc", TypeRuc, "20131312955", nil},
+		{"ruc with check digit 0", TypeRuc, "20100070970", nil},
+		{"ruc with check digit 1", TypeRuc, "10000000031", nil},
+		{"individual ruc", TypeRuc, "10465251234", nil},
+		{"ruc with wrong check digit", TypeRuc, "20131312956", ErrInvalidNumber},
+		{"ruc with unknown prefix", TypeRuc, "30131312955", ErrInvalidNumber},
+		{"ruc too short", TypeRuc, "2013131295", ErrInvalidNumber},
+		{"ruc with letters", TypeRuc, "2013131295A", ErrInvalidNumber},
+		{"unknown type", "cpp", "12345678", ErrUnknownType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.docType, tt.number)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Validate(%q, %q) returned %v", tt.docType, tt.number, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate(%q, %q) = %v, want %v", tt.docType, tt.number, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got := Normalize("  ab12345 \n"); got != "AB12345" {
+		t.Errorf("Normalize returned %q", got)
+	}
+}
@@ -0,0 +1,85 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+const testDevSecret = "a dev secret of at least 32 bytes"
+
+// signDevClaims signs claims as a dev token, letting tests build tokens IssueDevToken would never issue
+func signDevClaims(t *testing.T, secret string, claims devClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestNewDevProviderRejectsShortSecrets(t *testing.T) {
+	if _, err := NewDevProvider([]byte("short")); err == nil {
+		t.Error("NewDevProvider accepted a secret shorter than 32 bytes")
+	}
+}
+
+func TestDevProviderVerify(t *testing.T) {
+	provider, err := NewDevProvider([]byte(testDevSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := IssueDevToken([]byte(testDevSecret), "ana@example.com", "Ana Flores", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := IssueDevToken([]byte(testDevSecret), "ana@example.com", "Ana Flores", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := IssueDevToken([]byte("another dev secret of at least 32 bytes"), "ana@example.com", "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered := func(issuer, audience string, expires bool) jwt.RegisteredClaims {
+		c := jwt.RegisteredClaims{Issuer: issuer, Audience: jwt.ClaimStrings{audience}}
+		if expires {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  *Identity // nil when the token must be rejected
+	}{
+		{"valid token", valid, &Identity{Email: "ana@example.com", Subject: "ana@example.com", Name: "Ana Flores"}},
+		{"expired token", expired, nil},
+		{"signed with another secret", otherSecret, nil},
+		{"other issuer", signDevClaims(t, testDevSecret, devClaims{RegisteredClaims: registered("someone-else", DevAudience, true), Email: "ana@example.com"}), nil},
+		{"other audience", signDevClaims(t, testDevSecret, devClaims{RegisteredClaims: registered(DevIssuer, "other-api", true), Email: "ana@example.com"}), nil},
+		{"no expiration", signDevClaims(t, testDevSecret, devClaims{RegisteredClaims: registered(DevIssuer, DevAudience, false), Email: "ana@example.com"}), nil},
+		{"no email", signDevClaims(t, testDevSecret, devClaims{RegisteredClaims: registered(DevIssuer, DevAudience, true)}), nil},
+		{"malformed token", "not-a-jwt", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.Verify(context.Background(), tt.token)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("got %+v, %v, want %v", identity, err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *identity != *tt.want {
+				t.Errorf("got identity %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package idp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultFacebookGraphUrl = "https://graph.facebook.com/v18.0"
+
+// FacebookProvider verifies Facebook Login user access tokens using the Graph API
+type FacebookProvider struct {
+	appId     string
+	appSecret string
+	graphUrl  string
+	client    *http.Client
+}
+
+// NewFacebookProvider creates a provider for the app appId, graphUrl allows pointing it to a fake Graph API server
+func NewFacebookProvider(appId, appSecret, graphUrl string, client *http.Client) *FacebookProvider {
+	if graphUrl == "" {
+		graphUrl = DefaultFacebookGraphUrl
+	}
+	return &FacebookProvider{
+		appId:     appId,
+		appSecret: appSecret,
+		graphUrl:  strings.TrimSuffix(graphUrl, "/"),
+		client:    client,
+	}
+}
+
+func (f *FacebookProvider) Name() string {
+	return "facebook"
+}
+
+type fbGraphError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type fbDebugTokenResponse struct {
+	Data struct {
+		AppId   string        `json:"app_id"`
+		IsValid bool          `json:"is_valid"`
+		UserId  string        `json:"user_id"`
+		Error   *fbGraphError `json:"error"`
+	} `json:"data"`
+	Error *fbGraphError `json:"error"`
+}
+
+type fbMeResponse struct {
+	Id    string        `json:"id"`
+	Name  string        `json:"name"`
+	Email string        `json:"email"`
+	Error *fbGraphError `json:"error"`
+}
+
+func (f *FacebookProvider) Verify(ctx context.Context, token string) (*Identity, error) {
+	// Inspect the token with our app access token: it must be valid and issued for our app
+	debug := &fbDebugTokenResponse{}
+	query := url.Values{
+		"input_token":  {token},
+		"access_token": {f.appId + "|" + f.appSecret},
+	}
+	if err := f.get(ctx, "/debug_token", query, debug); err != nil {
+		return nil, err
+	}
+	if debug.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, debug.Error.Message)
+	}
+	if !debug.Data.IsValid {
+		reason := "token is not valid"
+		if debug.Data.Error != nil {
+			reason = debug.Data.Error.Message
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, reason)
+	}
+	if debug.Data.AppId != f.appId {
+		return nil, fmt.Errorf("%w: token was issued for another app", ErrInvalidToken)
+	}
+
+	// Read the profile of the token owner
+	me := &fbMeResponse{}
+	query = url.Values{
+		"fields":          {"id,name,email"},
+		"access_token":    {token},
+		"appsecret_proof": {f.appSecretProof(token)},
+	}
+	if err := f.get(ctx, "/me", query, me); err != nil {
+		return nil, err
+	}
+	if me.Error != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, me.Error.Message)
+	}
+	if me.Id == "" || me.Id != debug.Data.UserId {
+		return nil, fmt.Errorf("%w: token owner does not match", ErrInvalidToken)
+	}
+	// Facebook only returns confirmed emails, it is missing when the user denied the permission
+	if me.Email == "" {
+		return nil, fmt.Errorf("%w: email permission not granted", ErrInvalidToken)
+	}
+	log.Println("Facebook token successfully verified.")
+	return &Identity{
+		Email:   me.Email,
+		Subject: me.Id,
+		Name:    me.Name,
+	}, nil
+}
+
+// appSecretProof signs the access token with the app secret as required by 'Require App Secret' apps
+func (f *FacebookProvider) appSecretProof(token string) string {
+	mac := hmac.New(sha256.New, []byte(f.appSecret))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// get calls a Graph API endpoint and decodes its JSON response into out, Graph API errors are decoded as well
+func (f *FacebookProvider) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.graphUrl+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		log.Printf("Error calling Facebook Graph API %v: %v", path, err)
+		return fmt.Errorf("calling facebook graph api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading facebook graph api response: %w", err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		log.Printf("Unexpected Facebook Graph API response (%v): %s", resp.StatusCode, body)
+		return fmt.Errorf("decoding facebook graph api response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("facebook graph api returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package idp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testFbAppId     = "1234"
+	testFbAppSecret = "app-secret"
+)
+
+// fakeFbToken is what the fake Graph API knows about a user access token
+type fakeFbToken struct {
+	appId  string
+	valid  bool
+	userId string
+	me     map[string]interface{}
+}
+
+// newFakeGraphServer serves /debug_token and /me for the tokens, checking the app access token and the appsecret_proof
+func newFakeGraphServer(t *testing.T, tokens map[string]fakeFbToken) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != testFbAppId+"|"+testFbAppSecret {
+			writeJson(w, http.StatusBadRequest, map[string]interface{}{
+				"error": map[string]interface{}{"message": "Invalid OAuth access token", "code": 190},
+			})
+			return
+		}
+		data := map[string]interface{}{"is_valid": false}
+		if tok, ok := tokens[r.URL.Query().Get("input_token")]; ok {
+			data = map[string]interface{}{"app_id": tok.appId, "is_valid": tok.valid, "user_id": tok.userId}
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"data": data})
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		mac := hmac.New(sha256.New, []byte(testFbAppSecret))
+		mac.Write([]byte(token))
+		tok, ok := tokens[token]
+		if !ok || r.URL.Query().Get("appsecret_proof") != hex.EncodeToString(mac.Sum(nil)) {
+			writeJson(w, http.StatusBadRequest, map[string]interface{}{
+				"error": map[string]interface{}{"message": "Invalid appsecret_proof", "code": 100},
+			})
+			return
+		}
+		writeJson(w, http.StatusOK, tok.me)
+	})
+	mux.HandleFunc("/broken/debug_token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>Bad Gateway</html>"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestFacebookProviderVerify(t *testing.T) {
+	server := newFakeGraphServer(t, map[string]fakeFbToken{
+		"good": {appId: testFbAppId, valid: true, userId: "10", me: map[string]interface{}{
+			"id": "10", "name": "Ana Flores", "email": "ana@example.com",
+		}},
+		"expired":    {appId: testFbAppId, valid: false, userId: "10"},
+		"other-app":  {appId: "9999", valid: true, userId: "10", me: map[string]interface{}{"id": "10", "email": "ana@example.com"}},
+		"no-email":   {appId: testFbAppId, valid: true, userId: "11", me: map[string]interface{}{"id": "11", "name": "Luis"}},
+		"other-user": {appId: testFbAppId, valid: true, userId: "12", me: map[string]interface{}{"id": "13", "email": "x@example.com"}},
+	})
+
+	tests := []struct {
+		name      string
+		graphUrl  string
+		appSecret string
+		token     string
+		want      *Identity
+		wantErr   error // ErrInvalidToken, or nil when any other error is expected
+	}{
+		{name: "valid token", token: "good", want: &Identity{Email: "ana@example.com", Subject: "10", Name: "Ana Flores"}},
+		{name: "unknown token", token: "forged", wantErr: ErrInvalidToken},
+		{name: "invalid token", token: "expired", wantErr: ErrInvalidToken},
+		{name: "token of another app", token: "other-app", wantErr: ErrInvalidToken},
+		{name: "email permission denied", token: "no-email", wantErr: ErrInvalidToken},
+		{name: "profile of another user", token: "other-user", wantErr: ErrInvalidToken},
+		{name: "wrong app secret", appSecret: "wrong", token: "good", wantErr: ErrInvalidToken},
+		{name: "graph api unavailable", graphUrl: server.URL + "/broken", token: "good"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graphUrl, appSecret := server.URL+"/", testFbAppSecret
+			if tt.graphUrl != "" {
+				graphUrl = tt.graphUrl
+			}
+			if tt.appSecret != "" {
+				appSecret = tt.appSecret
+			}
+			provider := NewFacebookProvider(testFbAppId, appSecret, graphUrl, server.Client())
+			identity, err := provider.Verify(context.Background(), tt.token)
+			if tt.want != nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if *identity != *tt.want {
+					t.Errorf("got identity %+v, want %+v", identity, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error, got identity %+v", identity)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrInvalidToken) {
+				t.Errorf("an unavailable Graph API must not be reported as an invalid token: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package idp
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/api/idtoken"
+	"log"
+	"net/http"
+)
+
+// GoogleProvider verifies Google Sign-In ID tokens
+type GoogleProvider struct {
+	clientId  string
+	validator *idtoken.Validator
+}
+
+func NewGoogleProvider(ctx context.Context, clientId string, client *http.Client) (*GoogleProvider, error) {
+	validator, err := idtoken.NewValidator(ctx, idtoken.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	return &GoogleProvider{
+		clientId:  clientId,
+		validator: validator,
+	}, nil
+}
+
+func (g *GoogleProvider) Name() string {
+	return "google"
+}
+
+func (g *GoogleProvider) Verify(ctx context.Context, token string) (*Identity, error) {
+	// Verify the ID token, including the expiry, signature, issuer, and audience.
+	tokenPayload, err := g.validator.Validate(ctx, token, g.clientId)
+	if err != nil {
+		log.Printf("idtoken.Validate() error -> %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	email, _ := tokenPayload.Claims["email"].(string)
+	if email == "" {
+		return nil, fmt.Errorf("%w: token has no email", ErrInvalidToken)
+	}
+	if verified, _ := tokenPayload.Claims["email_verified"].(bool); !verified {
+		return nil, fmt.Errorf("%w: email is not verified", ErrInvalidToken)
+	}
+	name, _ := tokenPayload.Claims["name"].(string)
+	log.Println("Google token successfully verified.")
+	return &Identity{
+		Email:   email,
+		Subject: tokenPayload.Subject,
+		Name:    name,
+	}, nil
+}
@@ -0,0 +1,112 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/config"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidToken    = errors.New("invalid identity provider token")
+)
+
+// Identity is the verified information an identity provider returns for a token
+type Identity struct {
+	Email   string
+	Subject string // user id at the identity provider
+	Name    string
+}
+
+// IdentityProvider verifies the tokens that clients obtain from an external identity provider
+type IdentityProvider interface {
+	// Name is the value clients send in the 'idp' field of login and signup requests
+	Name() string
+	// Verify validates the token and returns the identity of its owner
+	Verify(ctx context.Context, token string) (*Identity, error)
+}
+
+// Registry holds the identity providers enabled in the configuration
+type Registry struct {
+	providers map[string]IdentityProvider
+}
+
+func NewRegistry(providers ...IdentityProvider) *Registry {
+	r := &Registry{providers: map[string]IdentityProvider{}}
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+// NewRegistryFromConfig creates the providers configured in c, all of them using client for their HTTP calls
+func NewRegistryFromConfig(c *config.Config, client *http.Client) (*Registry, error) {
+	var providers []IdentityProvider
+
+	if c.GOauthClientId != "" {
+		google, err := NewGoogleProvider(context.Background(), c.GOauthClientId, client)
+		if err != nil {
+			return nil, fmt.Errorf("creating google identity provider: %w", err)
+		}
+		providers = append(providers, google)
+	}
+
+	if c.FbAppId != "" {
+		providers = append(providers, NewFacebookProvider(c.FbAppId, c.FbAppSecret, c.FbGraphUrl, client))
+	}
+
+	for _, oc := range c.OidcProviders {
+		providers = append(providers, NewOidcProvider(oc.Name, oc.IssuerUrl, oc.ClientId, client))
+	}
+
+	if c.DevIdpEnabled {
+		dev, err := NewDevProvider([]byte(c.DevIdpSecret))
+		if err != nil {
+			return nil, fmt.Errorf("creating dev identity provider: %w", err)
+		}
+		log.Println("WARNING: the 'dev' identity provider is enabled, anyone holding DEVIDPSECRET can login as any email")
+		providers = append(providers, dev)
+	}
+
+	if len(providers) == 0 {
+		return nil, errors.New("no identity provider is configured, users could not login")
+	}
+	r := NewRegistry(providers...)
+	log.Printf("Identity providers enabled: %v", r.Names())
+	return r, nil
+}
+
+// NewHttpClient returns the HTTP client used by default to reach identity providers
+func NewHttpClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Get returns the provider registered with name
+func (r *Registry) Get(name string) (IdentityProvider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the sorted names of the registered providers
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Verify validates token with the provider registered with name
+func (r *Registry) Verify(ctx context.Context, name, token string) (*Identity, error) {
+	p, ok := r.Get(name)
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p.Verify(ctx, token)
+}
@@ -0,0 +1,238 @@
+package idp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/keys"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcDiscoveryTTL = 24 * time.Hour
+	// oidcJwksTTL is how long the signing keys of an issuer are cached before being fetched again
+	oidcJwksTTL = time.Hour
+	// oidcJwksMinRefresh rate limits the refreshes triggered by tokens signed with an unknown key
+	oidcJwksMinRefresh = time.Minute
+)
+
+var oidcValidMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// OidcProvider verifies the ID tokens of any OpenID Connect issuer (Microsoft, Apple, Keycloak...)
+// using its discovery document and its published JWKS.
+type OidcProvider struct {
+	name      string
+	issuerUrl string
+	clientId  string
+	client    *http.Client
+	now       func() time.Time
+
+	mu                  sync.Mutex // guards the caches below, never held during HTTP calls
+	discovery           *oidcDiscovery
+	discoveryFetchedAt  time.Time
+	jwks                map[string]interface{}
+	jwksFetchedAt       time.Time
+	jwksForcedRefreshAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JwksUri string `json:"jwks_uri"`
+}
+
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+	// EmailVerified is a boolean, but some issuers (Apple) send it as the string "true"
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+// NewOidcProvider creates a provider named name for the issuer issuerUrl, accepting tokens issued for clientId
+func NewOidcProvider(name, issuerUrl, clientId string, client *http.Client) *OidcProvider {
+	return &OidcProvider{
+		name:      name,
+		issuerUrl: strings.TrimSuffix(issuerUrl, "/"),
+		clientId:  clientId,
+		client:    client,
+		now:       time.Now,
+	}
+}
+
+func (o *OidcProvider) Name() string {
+	return o.name
+}
+
+func (o *OidcProvider) Verify(ctx context.Context, token string) (*Identity, error) {
+	discovery, err := o.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &oidcClaims{}
+	keyfunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.getKey(ctx, discovery, kid)
+	}
+	_, err = jwt.ParseWithClaims(token, claims, keyfunc,
+		jwt.WithValidMethods(oidcValidMethods), jwt.WithIssuer(discovery.Issuer), jwt.WithAudience(o.clientId))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: token has no expiration time", ErrInvalidToken)
+	}
+	if claims.Email == "" {
+		return nil, fmt.Errorf("%w: token has no email", ErrInvalidToken)
+	}
+	if !isEmailVerified(claims.EmailVerified) {
+		return nil, fmt.Errorf("%w: email is not verified", ErrInvalidToken)
+	}
+	log.Printf("OIDC token of %v successfully verified.", o.name)
+	return &Identity{
+		Email:   claims.Email,
+		Subject: claims.Subject,
+		Name:    claims.Name,
+	}, nil
+}
+
+func isEmailVerified(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
+
+// getDiscovery returns the cached discovery document of the issuer, fetching it when missing or stale.
+// The document is fetched without holding o.mu, so verifications keep using the cache meanwhile.
+func (o *OidcProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	cached, fetchedAt := o.discovery, o.discoveryFetchedAt
+	o.mu.Unlock()
+	if cached != nil && o.now().Sub(fetchedAt) < oidcDiscoveryTTL {
+		return cached, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := o.getJson(ctx, o.issuerUrl+"/.well-known/openid-configuration", discovery); err != nil {
+		if cached != nil {
+			// Keep working with the stale document while the issuer is unreachable
+			log.Printf("Error refreshing discovery document of %v, using cached one: %v", o.name, err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	// OpenID Connect Discovery 1.0 section 4.3: the issuer must match the URL used to retrieve the document
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.issuerUrl {
+		return nil, fmt.Errorf("issuer %q of %v discovery document does not match %q", discovery.Issuer, o.name, o.issuerUrl)
+	}
+	if discovery.JwksUri == "" {
+		return nil, fmt.Errorf("discovery document of %v has no jwks_uri", o.name)
+	}
+	o.mu.Lock()
+	o.discovery = discovery
+	o.discoveryFetchedAt = o.now()
+	o.mu.Unlock()
+	return discovery, nil
+}
+
+// getKey returns the public key kid of the issuer, refreshing the cached JWKS when it is stale or the key is unknown.
+// o.mu is only held to read and swap the cache, never while fetching.
+func (o *OidcProvider) getKey(ctx context.Context, discovery *oidcDiscovery, kid string) (interface{}, error) {
+	o.mu.Lock()
+	jwks, fetchedAt := o.jwks, o.jwksFetchedAt
+	o.mu.Unlock()
+
+	now := o.now()
+	if jwks == nil || now.Sub(fetchedAt) >= oidcJwksTTL {
+		fetched, err := o.refreshJwks(ctx, discovery)
+		if err != nil && jwks == nil {
+			return nil, err
+		}
+		if err == nil {
+			jwks = fetched
+		}
+	}
+	if key, ok := jwks[kid]; ok {
+		return key, nil
+	}
+	// The issuer may have rotated its keys since the last fetch
+	o.mu.Lock()
+	forceRefresh := now.Sub(o.jwksForcedRefreshAt) >= oidcJwksMinRefresh
+	if forceRefresh {
+		o.jwksForcedRefreshAt = now
+	}
+	o.mu.Unlock()
+	if forceRefresh {
+		fetched, err := o.refreshJwks(ctx, discovery)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := fetched[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// refreshJwks fetches the JWKS of the issuer and swaps it into the cache, it must be called without holding o.mu
+func (o *OidcProvider) refreshJwks(ctx context.Context, discovery *oidcDiscovery) (map[string]interface{}, error) {
+	set := &keys.JWKSet{}
+	if err := o.getJson(ctx, discovery.JwksUri, set); err != nil {
+		log.Printf("Error fetching JWKS of %v: %v", o.name, err)
+		return nil, err
+	}
+	jwks := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping key of %v: %v", o.name, err)
+			continue
+		}
+		jwks[jwk.Kid] = key
+	}
+	if len(jwks) == 0 {
+		return nil, fmt.Errorf("JWKS of %v has no usable signing keys", o.name)
+	}
+	o.mu.Lock()
+	o.jwks = jwks
+	o.jwksFetchedAt = o.now()
+	o.mu.Unlock()
+	return jwks, nil
+}
+
+func (o *OidcProvider) getJson(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling %v: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned status %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading %v: %w", url, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding %v: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package idp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"github.com/angelmotta/flow-api/internal/keys"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testOidcClientId = "flow-test"
+
+// fakeIssuer is an OpenID Connect issuer publishing a single ES256 key, its JWKS endpoint blocks while hold is set
+type fakeIssuer struct {
+	server  *httptest.Server
+	key     *ecdsa.PrivateKey
+	kid     string
+	hold    chan struct{} // closed to release the held JWKS requests
+	holding chan struct{} // receives a value when a JWKS request is held
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{key: key, kid: "k1", holding: make(chan struct{}, 1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, map[string]string{"issuer": issuer.server.URL, "jwks_uri": issuer.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		if hold := issuer.hold; hold != nil {
+			issuer.holding <- struct{}{}
+			<-hold
+		}
+		jwk, err := keys.NewJWK(issuer.kid, "ES256", &issuer.key.PublicKey)
+		if err != nil {
+			t.Error(err)
+		}
+		writeJson(w, http.StatusOK, keys.JWKSet{Keys: []keys.JWK{jwk}})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// idToken signs an ID token for Flow with the key of the issuer
+func (i *fakeIssuer) idToken(t *testing.T, kid string, emailVerified interface{}) string {
+	t.Helper()
+	claims := oidcClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.server.URL,
+			Audience:  jwt.ClaimStrings{testOidcClientId},
+			Subject:   "abc-123",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Email:         "ana@example.com",
+		EmailVerified: emailVerified,
+		Name:          "Ana Flores",
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOidcProviderVerify(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	provider := NewOidcProvider("keycloak", issuer.server.URL+"/", testOidcClientId, issuer.server.Client())
+
+	identity, err := provider.Verify(context.Background(), issuer.idToken(t, "k1", "true"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Identity{Email: "ana@example.com", Subject: "abc-123", Name: "Ana Flores"}
+	if *identity != want {
+		t.Errorf("got identity %+v, want %+v", identity, want)
+	}
+	for name, token := range map[string]string{
+		"email not verified": issuer.idToken(t, "k1", false),
+		"unknown key":        issuer.idToken(t, "k2", true),
+	} {
+		if _, err := provider.Verify(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%v: got %v, want %v", name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestOidcProviderDoesNotHoldItsLockWhileFetching(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	provider := NewOidcProvider("keycloak", issuer.server.URL, testOidcClientId, issuer.server.Client())
+	ctx := context.Background()
+	discovery, err := provider.getDiscovery(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.getKey(ctx, discovery, "k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed with an unknown key forces a refresh of the JWKS, which the issuer holds
+	issuer.hold = make(chan struct{})
+	defer close(issuer.hold)
+	go provider.getKey(ctx, discovery, "rotated")
+	select {
+	case <-issuer.holding:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the JWKS refresh did not start")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := provider.getKey(ctx, discovery, "k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("getKey of a cached key returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("getKey of a cached key waited for the JWKS refresh in flight")
+	}
+}
@@ -0,0 +1,195 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"github.com/angelmotta/flow-api/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// minHmacKeyBytes is the minimum length accepted for HS256 secrets (RFC 7518 section 3.2)
+const minHmacKeyBytes = 32
+
+// Key is a signing key identified by its 'kid'
+type Key struct {
+	Id     string
+	Method jwt.SigningMethod
+	// RetiredUntil is zero for the active key, retired keys only verify tokens until that time
+	RetiredUntil time.Time
+
+	signKey   interface{} // []byte, *rsa.PrivateKey or *ecdsa.PrivateKey, nil for verification-only keys
+	verifyKey interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// isSymmetric reports whether the key is an HMAC secret, which must never be published
+func (k *Key) isSymmetric() bool {
+	_, ok := k.verifyKey.([]byte)
+	return ok
+}
+
+// Manager signs tokens with the active key and verifies them with any key it knows (active or retired)
+type Manager struct {
+	active *Key
+	keys   map[string]*Key
+	now    func() time.Time
+}
+
+// NewManager loads the active signing key and the retired keys configured in c
+func NewManager(c *config.Config) (*Manager, error) {
+	material := []byte(c.JwtSigningKey)
+	if c.JwtSigningKeyFile != "" {
+		b, err := os.ReadFile(c.JwtSigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading signing key file: %w", err)
+		}
+		material = b
+	}
+	active, err := loadKey(c.JwtSigningKeyId, c.JwtSigningAlg, material, true)
+	if err != nil {
+		return nil, fmt.Errorf("loading signing key %q: %w", c.JwtSigningKeyId, err)
+	}
+
+	m := &Manager{
+		active: active,
+		keys:   map[string]*Key{active.Id: active},
+		now:    time.Now,
+	}
+	for _, rk := range c.JwtRetiredKeys {
+		if _, exists := m.keys[rk.Id]; exists {
+			return nil, fmt.Errorf("duplicated key id %q", rk.Id)
+		}
+		b, err := os.ReadFile(rk.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading retired key file of %q: %w", rk.Id, err)
+		}
+		k, err := loadKey(rk.Id, rk.Alg, b, false)
+		if err != nil {
+			return nil, fmt.Errorf("loading retired key %q: %w", rk.Id, err)
+		}
+		k.RetiredUntil = rk.RetiredUntil
+		m.keys[k.Id] = k
+	}
+	log.Printf("Loaded signing key %q (%s) and %d retired keys", active.Id, active.Method.Alg(), len(c.JwtRetiredKeys))
+	return m, nil
+}
+
+// loadKey builds a key from its material: the secret for HS256, a PEM encoded key for RS256 and ES256.
+// When signing is false a public key PEM is accepted as well.
+func loadKey(kid, alg string, material []byte, signing bool) (*Key, error) {
+	if kid == "" {
+		return nil, errors.New("missing key id")
+	}
+	k := &Key{Id: kid}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		if len(material) < minHmacKeyBytes {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHmacKeyBytes)
+		}
+		k.Method = jwt.SigningMethodHS256
+		k.signKey = material
+		k.verifyKey = material
+	case jwt.SigningMethodRS256.Alg():
+		k.Method = jwt.SigningMethodRS256
+		if privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(material); err == nil {
+			k.signKey = privateKey
+			k.verifyKey = &privateKey.PublicKey
+		} else if signing {
+			return nil, err
+		} else {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(material)
+			if err != nil {
+				return nil, err
+			}
+			k.verifyKey = publicKey
+		}
+	case jwt.SigningMethodES256.Alg():
+		k.Method = jwt.SigningMethodES256
+		var publicKey *ecdsa.PublicKey
+		if privateKey, err := jwt.ParseECPrivateKeyFromPEM(material); err == nil {
+			k.signKey = privateKey
+			publicKey = &privateKey.PublicKey
+		} else if signing {
+			return nil, err
+		} else {
+			publicKey, err = jwt.ParseECPublicKeyFromPEM(material)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if publicKey.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		k.verifyKey = publicKey
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return k, nil
+}
+
+// Sign signs the claims with the active key, stamping its id in the 'kid' header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.active.Method, claims)
+	token.Header["kid"] = m.active.Id
+	return token.SignedString(m.active.signKey)
+}
+
+// Keyfunc resolves the verification key of a token from its 'kid' header, to be used with jwt.Parse
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, errors.New("token has no 'kid' header")
+	}
+	k, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	// Prevent algorithm confusion: a key only verifies tokens of its own algorithm
+	if token.Method.Alg() != k.Method.Alg() {
+		return nil, fmt.Errorf("algorithm %q does not match key %q", token.Method.Alg(), kid)
+	}
+	if !k.RetiredUntil.IsZero() && m.now().After(k.RetiredUntil) {
+		return nil, fmt.Errorf("key %q has been retired", kid)
+	}
+	return k.verifyKey, nil
+}
+
+// ValidMethods returns the algorithms of the known keys, to be used with jwt.WithValidMethods
+func (m *Manager) ValidMethods() []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, k := range m.keys {
+		if !seen[k.Method.Alg()] {
+			seen[k.Method.Alg()] = true
+			methods = append(methods, k.Method.Alg())
+		}
+	}
+	return methods
+}
+
+// JWKS returns the public keys still valid for verification, HMAC secrets are never included
+func (m *Manager) JWKS() *JWKSet {
+	set := &JWKSet{Keys: []JWK{}}
+	now := m.now()
+	for _, k := range m.keys {
+		if k.isSymmetric() {
+			continue
+		}
+		if !k.RetiredUntil.IsZero() && now.After(k.RetiredUntil) {
+			continue
+		}
+		jwk, err := NewJWK(k.Id, k.Method.Alg(), k.verifyKey)
+		if err != nil {
+			log.Printf("Error encoding key %q as JWK: %v", k.Id, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,141 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"github.com/angelmotta/flow-api/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	secretOld     = strings.Repeat("o", minHmacKeyBytes)
+	secretRetired = strings.Repeat("r", minHmacKeyBytes)
+	secretActive  = strings.Repeat("a", minHmacKeyBytes)
+)
+
+// newHmacManager returns a manager whose active HS256 key is kid, verifying with the retired keys as well
+func newHmacManager(t *testing.T, kid, secret string, retired ...config.JwtRetiredKey) *Manager {
+	t.Helper()
+	m, err := NewManager(&config.Config{
+		JwtSigningKeyId: kid,
+		JwtSigningAlg:   "HS256",
+		JwtSigningKey:   secret,
+		JwtRetiredKeys:  retired,
+	})
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m
+}
+
+// retiredHmacKey writes the secret to a temporary file as NewManager expects for retired keys
+func retiredHmacKey(t *testing.T, kid, secret string, until time.Time) config.JwtRetiredKey {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), kid)
+	if err := os.WriteFile(file, []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return config.JwtRetiredKey{Id: kid, Alg: "HS256", File: file, RetiredUntil: until}
+}
+
+func sign(t *testing.T, m *Manager) string {
+	t.Helper()
+	token, err := m.Sign(jwt.RegisteredClaims{Subject: "1"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+func TestManagerVerifiesWithRetiredKeys(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	m := newHmacManager(t, "flow-3", secretActive,
+		retiredHmacKey(t, "flow-2", secretRetired, now.Add(time.Hour)),
+		retiredHmacKey(t, "flow-1", secretOld, now.Add(-time.Hour)),
+	)
+	m.now = func() time.Time { return now }
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An ES256 token claiming the HS256 key id must not be verified with it
+	confused := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{Subject: "1"})
+	confused.Header["kid"] = "flow-3"
+	confusedToken, err := confused.SignedString(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noKid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"}).SignedString([]byte(secretActive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"active key", sign(t, m), true},
+		{"retired key within its window", sign(t, newHmacManager(t, "flow-2", secretRetired)), true},
+		{"retired key past its window", sign(t, newHmacManager(t, "flow-1", secretOld)), false},
+		{"unknown key id", sign(t, newHmacManager(t, "flow-9", secretActive)), false},
+		{"known key id with another secret", sign(t, newHmacManager(t, "flow-2", secretActive)), false},
+		{"algorithm confusion", confusedToken, false},
+		{"missing key id", noKid, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwt.Parse(tt.token, m.Keyfunc, jwt.WithValidMethods(m.ValidMethods()))
+			if tt.valid && err != nil {
+				t.Errorf("expected token to verify, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestNewManagerRejectsDuplicatedKeyIds(t *testing.T) {
+	_, err := NewManager(&config.Config{
+		JwtSigningKeyId: "flow-1",
+		JwtSigningAlg:   "HS256",
+		JwtSigningKey:   secretActive,
+		JwtRetiredKeys:  []config.JwtRetiredKey{retiredHmacKey(t, "flow-1", secretOld, time.Now())},
+	})
+	if err == nil {
+		t.Error("expected an error for a retired key reusing the active key id")
+	}
+}
+
+func TestJWKSOmitsSecretsAndExpiredKeys(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	m := newHmacManager(t, "flow-3", secretActive, retiredHmacKey(t, "flow-2", secretRetired, now.Add(time.Hour)))
+	m.now = func() time.Time { return now }
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.keys["ec-1"] = &Key{Id: "ec-1", Method: jwt.SigningMethodES256, RetiredUntil: now.Add(time.Hour), verifyKey: &ecKey.PublicKey}
+	m.keys["ec-0"] = &Key{Id: "ec-0", Method: jwt.SigningMethodES256, RetiredUntil: now.Add(-time.Hour), verifyKey: &ecKey.PublicKey}
+
+	set := m.JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "ec-1" {
+		t.Fatalf("expected only key 'ec-1' to be published, got %+v", set.Keys)
+	}
+	publicKey, err := set.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	if !ecKey.PublicKey.Equal(publicKey) {
+		t.Error("published key does not round trip")
+	}
+}
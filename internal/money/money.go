@@ -0,0 +1,147 @@
+// Package money implements the fixed-point numbers used for exchange rates and amounts, floats are never used for money
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Rate is an exchange rate in thousandths, the precision of the NUMERIC(6, 3) prices of exchange_currency: 3.727 is 3727
+type Rate int64
+
+const rateDecimals = 3
+
+// Amount is an amount of money in cents: 100.50 is 10050
+type Amount int64
+
+const amountDecimals = 2
+
+var (
+	ErrInvalidNumber = errors.New("invalid decimal number")
+	ErrOverflow      = errors.New("amount out of range")
+)
+
+// ParseRate parses a decimal with at most 3 decimals, e.g. "3.727"
+func ParseRate(s string) (Rate, error) {
+	value, err := parseFixed(s, rateDecimals)
+	return Rate(value), err
+}
+
+func (r Rate) String() string {
+	return formatFixed(int64(r), rateDecimals)
+}
+
+// MarshalJSON encodes the rate as a JSON number keeping its 3 decimals
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts JSON numbers and strings, parsing their text without going through float64
+func (r *Rate) UnmarshalJSON(b []byte) error {
+	value, err := ParseRate(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*r = value
+	return nil
+}
+
+// ParseAmount parses a decimal with at most 2 decimals, e.g. "100.50"
+func ParseAmount(s string) (Amount, error) {
+	value, err := parseFixed(s, amountDecimals)
+	return Amount(value), err
+}
+
+func (a Amount) String() string {
+	return formatFixed(int64(a), amountDecimals)
+}
+
+// MarshalJSON encodes the amount as a JSON number keeping its 2 decimals
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts JSON numbers and strings, parsing their text without going through float64
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	value, err := ParseAmount(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*a = value
+	return nil
+}
+
+// MulRate converts an amount of the main currency of a pair to the secondary currency, rounding up or down to the cent.
+// It returns ErrOverflow if the result does not fit in an Amount.
+func (a Amount) MulRate(r Rate, roundUp bool) (Amount, error) {
+	value, err := mulDiv(int64(a), int64(r), 1000, roundUp)
+	return Amount(value), err
+}
+
+// DivRate converts an amount of the secondary currency of a pair to the main currency, rounding up or down to the cent.
+// It returns ErrOverflow if the result does not fit in an Amount.
+func (a Amount) DivRate(r Rate, roundUp bool) (Amount, error) {
+	value, err := mulDiv(int64(a), 1000, int64(r), roundUp)
+	return Amount(value), err
+}
+
+// mulDiv computes x * y / d for non negative x and y and positive d, rounding the quotient up or down. The product
+// is kept in 128 bits so it cannot wrap around.
+func mulDiv(x, y, d int64, roundUp bool) (int64, error) {
+	if x < 0 || y < 0 || d <= 0 {
+		return 0, ErrOverflow
+	}
+	hi, lo := bits.Mul64(uint64(x), uint64(y))
+	if hi >= uint64(d) {
+		// The quotient needs more than 64 bits
+		return 0, ErrOverflow
+	}
+	q, rem := bits.Div64(hi, lo, uint64(d))
+	if roundUp && rem != 0 {
+		q++
+	}
+	if q > math.MaxInt64 {
+		return 0, ErrOverflow
+	}
+	return int64(q), nil
+}
+
+// parseFixed parses a non negative decimal into an integer scaled by 10^decimals
+func parseFixed(s string, decimals int) (int64, error) {
+	s = strings.TrimSpace(s)
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || len(fraction) > decimals || !isDigits(whole) || (fraction != "" && !isDigits(fraction)) {
+		return 0, fmt.Errorf("%w %q, at most %d decimals", ErrInvalidNumber, s, decimals)
+	}
+	fraction += strings.Repeat("0", decimals-len(fraction))
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w %q", ErrInvalidNumber, s)
+	}
+	return value, nil
+}
+
+func formatFixed(value int64, decimals int) string {
+	sign := ""
+	if value < 0 {
+		sign, value = "-", -value
+	}
+	scale := int64(1)
+	for i := 0; i < decimals; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, value/scale, decimals, value%scale)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
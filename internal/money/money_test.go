@@ -0,0 +1,165 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseFixed(t *testing.T) {
+	tests := []struct {
+		s        string
+		decimals int
+		want     int64
+		wantErr  bool
+	}{
+		{"3.727", 3, 3727, false},
+		{"3.7", 3, 3700, false},
+		{"3", 3, 3000, false},
+		{"3.", 3, 3000, false},
+		{" 0.001 ", 3, 1, false},
+		{"100.50", 2, 10050, false},
+		{"0", 2, 0, false},
+		{"92233720368547758.07", 2, 9223372036854775807, false},
+		{"92233720368547758.08", 2, 0, true}, // overflows int64
+		{"3.7275", 3, 0, true},               // more decimals than the precision
+		{"100.505", 2, 0, true},
+		{".5", 2, 0, true},
+		{"-1.00", 2, 0, true},
+		{"+1.00", 2, 0, true},
+		{"1e3", 2, 0, true},
+		{"1,000.00", 2, 0, true},
+		{"1.0.0", 2, 0, true},
+		{"", 2, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFixed(tt.s, tt.decimals)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidNumber) {
+				t.Errorf("parseFixed(%q, %d) = %d, %v, want %v", tt.s, tt.decimals, got, err, ErrInvalidNumber)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseFixed(%q, %d) = %d, %v, want %d", tt.s, tt.decimals, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{Rate(3727).String(), "3.727"},
+		{Rate(3700).String(), "3.700"},
+		{Rate(5).String(), "0.005"},
+		{Amount(10050).String(), "100.50"},
+		{Amount(7).String(), "0.07"},
+		{Amount(-150).String(), "-1.50"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Rate   Rate   `json:"rate"`
+		Amount Amount `json:"amount"`
+	}
+	// Numbers and strings are accepted, neither goes through float64
+	if err := json.Unmarshal([]byte(`{"rate": 3.727, "amount": "0.29"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Rate != 3727 || v.Amount != 29 {
+		t.Errorf("got rate %d and amount %d", v.Rate, v.Amount)
+	}
+	if err := json.Unmarshal([]byte(`{"rate": "3.7"}`), &v); err != nil || v.Rate != 3700 {
+		t.Errorf("got rate %d, %v", v.Rate, err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"rate":3.700,"amount":0.29}` {
+		t.Errorf("got %s", b)
+	}
+	if err := json.Unmarshal([]byte(`{"rate": 3.7271}`), &v); !errors.Is(err, ErrInvalidNumber) {
+		t.Errorf("expected %v, got %v", ErrInvalidNumber, err)
+	}
+}
+
+func TestMulRate(t *testing.T) {
+	tests := []struct {
+		amount  Amount
+		rate    Rate
+		roundUp bool
+		want    Amount
+	}{
+		{10000, 3727, false, 37270}, // 100.00 * 3.727 = 372.70, exact
+		{10000, 3727, true, 37270},
+		{101, 3727, false, 376}, // 1.01 * 3.727 = 3.76427
+		{101, 3727, true, 377},
+		{1, 1, false, 0}, // 0.01 * 0.001 rounds down to nothing
+		{1, 1, true, 1},
+		{0, 3727, true, 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.MulRate(tt.rate, tt.roundUp)
+		if err != nil || got != tt.want {
+			t.Errorf("%v.MulRate(%v, %v) = %v, %v, want %v", tt.amount, tt.rate, tt.roundUp, got, err, tt.want)
+		}
+	}
+}
+
+func TestDivRate(t *testing.T) {
+	tests := []struct {
+		amount  Amount
+		rate    Rate
+		roundUp bool
+		want    Amount
+	}{
+		{37270, 3727, false, 10000}, // 372.70 / 3.727 = 100.00, exact
+		{37270, 3727, true, 10000},
+		{10000, 3727, false, 2683}, // 100.00 / 3.727 = 26.831...
+		{10000, 3727, true, 2684},
+		{10000, 3000, false, 3333}, // 100.00 / 3.000 = 33.333...
+		{10000, 3000, true, 3334},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.DivRate(tt.rate, tt.roundUp)
+		if err != nil || got != tt.want {
+			t.Errorf("%v.DivRate(%v, %v) = %v, %v, want %v", tt.amount, tt.rate, tt.roundUp, got, err, tt.want)
+		}
+	}
+}
+
+func TestConversionOverflow(t *testing.T) {
+	const maxAmount = Amount(math.MaxInt64)
+	tests := []struct {
+		name    string
+		convert func() (Amount, error)
+	}{
+		// The products fit in 128 bits but the results do not fit in an Amount
+		{"mul", func() (Amount, error) { return maxAmount.MulRate(1001, false) }},
+		{"div", func() (Amount, error) { return maxAmount.DivRate(999, false) }},
+		{"division by zero", func() (Amount, error) { return Amount(100).DivRate(0, false) }},
+		{"negative amount", func() (Amount, error) { return Amount(-100).MulRate(3727, false) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.convert()
+			if !errors.Is(err, ErrOverflow) {
+				t.Errorf("got %v, %v, want %v", got, err, ErrOverflow)
+			}
+		})
+	}
+	// The largest amount converts at a rate of 1 without wrapping around
+	if got, err := maxAmount.MulRate(1000, true); err != nil || got != maxAmount {
+		t.Errorf("MulRate(1.000) = %v, %v, want %v", got, err, maxAmount)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
@@ -30,30 +31,103 @@ func main() {
 	// Create a store Object using the database pool
 	store := database.NewPgStore(dbpool) // store Object implements the Store interface
 	// Create a server by injecting the store as a dependency
-	server := api.NewServer(store, c)
+	server, err := api.NewServer(store, c)
+	if err != nil {
+		log.Fatalf("Error creating API server: %v\n", err)
+	}
+
+	// Background jobs
+	go server.RunRetentionJob(context.Background(), 24*time.Hour)
+	go server.RunOrderExpiryJob(context.Background(), time.Minute)
 
 	// Chi router
 	r := chi.NewRouter()
-	r.Use(middleware.AllowContentType("application/json"))
-	r.Use(middleware.RequestSize(server.Config.HttpMaxBodyBytes))
 	r.Use(cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		//AllowedOrigins: []string{"https://*", "http://*"},
 		AllowedOrigins: []string{"http://localhost:1234"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
-	r.Get("/api/v1/users", server.GetUsersHandler)
-	r.Get("/api/v1/users/{email}", server.GetUserHandler)
-	r.Post("/api/v1/users", server.CreateUserHandler)
-	r.Post("/api/v1/users/signup", server.UserSignupHandler)
-	r.Put("/api/v1/users/{id}", server.UpdateUserHandler)
-	r.Delete("/api/v1/users/{id}", server.DeleteUserHandler)
-	r.Post("/api/v1/auth/login", server.LoginHandler)
+
+	// JSON API: request bodies are JSON documents bounded by HttpMaxBodyBytes
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AllowContentType("application/json", "application/merge-patch+json"))
+		r.Use(middleware.RequestSize(server.Config.HttpMaxBodyBytes))
+		// Public routes
+		r.Get("/.well-known/jwks.json", server.JWKSHandler)
+		r.Get("/api/v1/catalog/{catalog}", server.GetCatalogHandler)
+		r.Get("/api/v1/rates", server.GetRatesHandler)
+		r.Get("/api/v1/rates/{pair}", server.GetRateHandler)
+		r.Get("/api/v1/rates/{pair}/history", server.GetRateHistoryHandler)
+		r.Post("/api/v1/users/signup", server.UserSignupHandler)
+		r.Post("/api/v1/auth/login", server.LoginHandler)
+		r.Post("/api/v1/auth/refresh", server.RefreshTokenHandler)
+
+		// Routes requiring a valid access token
+		r.Group(func(r chi.Router) {
+			r.Use(server.AuthMiddleware)
+			r.With(api.RequirePermission(api.PermUsersList)).Get("/api/v1/users", server.GetUsersHandler)
+			r.Get("/api/v1/users/me", server.GetMeHandler)
+			r.Get("/api/v1/users/{id}", server.GetUserHandler)
+			r.With(api.RequirePermission(api.PermUsersCreate)).Post("/api/v1/users", server.CreateUserHandler)
+			r.Patch("/api/v1/users/{id}", server.UpdateUserHandler)
+			r.Delete("/api/v1/users/{id}", server.DeleteUserHandler)
+			r.With(api.RequirePermission(api.PermUsersManageRoles)).Put("/api/v1/users/{id}/role", server.ChangeUserRoleHandler)
+			r.With(api.RequirePermission(api.PermUsersBlock)).Post("/api/v1/users/{id}/block", server.BlockUserHandler)
+			r.With(api.RequirePermission(api.PermUsersBlock)).Post("/api/v1/users/{id}/unblock", server.UnblockUserHandler)
+			r.With(api.RequirePermission(api.PermUsersKyc)).Put("/api/v1/users/{id}/kyc-tier", server.ChangeUserKycTierHandler)
+			r.Get("/api/v1/users/{id}/limits", server.GetUserLimitsHandler)
+			r.Get("/api/v1/users/{id}/bank-accounts", server.GetUserBankAccountsHandler)
+			r.Post("/api/v1/users/{id}/bank-accounts", server.CreateUserBankAccountHandler)
+			r.Delete("/api/v1/users/{id}/bank-accounts/{accountId}", server.DeleteUserBankAccountHandler)
+			r.Post("/api/v1/companies", server.CreateCompanyHandler)
+			r.Get("/api/v1/companies", server.GetCompaniesHandler)
+			r.Get("/api/v1/companies/{companyId}", server.GetCompanyHandler)
+			r.Post("/api/v1/companies/{companyId}/representatives", server.AddCompanyRepresentativeHandler)
+			r.Delete("/api/v1/companies/{companyId}/representatives/{userId}", server.RemoveCompanyRepresentativeHandler)
+			r.Get("/api/v1/company-invitations", server.GetCompanyInvitationsHandler)
+			r.Post("/api/v1/company-invitations/{invitationId}/accept", server.AcceptCompanyInvitationHandler)
+			r.Post("/api/v1/company-invitations/{invitationId}/decline", server.DeclineCompanyInvitationHandler)
+			r.With(api.RequirePermission(api.PermUsersKyc)).Put("/api/v1/companies/{companyId}/kyc-tier", server.ChangeCompanyKycTierHandler)
+			r.Get("/api/v1/companies/{companyId}/limits", server.GetCompanyLimitsHandler)
+			r.Get("/api/v1/companies/{companyId}/bank-accounts", server.GetCompanyBankAccountsHandler)
+			r.Post("/api/v1/companies/{companyId}/bank-accounts", server.CreateCompanyBankAccountHandler)
+			r.Delete("/api/v1/companies/{companyId}/bank-accounts/{accountId}", server.DeleteCompanyBankAccountHandler)
+			r.With(api.RequirePermission(api.PermCatalogManage)).Post("/api/v1/catalog/{catalog}", server.CreateCatalogEntryHandler)
+			r.With(api.RequirePermission(api.PermCatalogManage)).Patch("/api/v1/catalog/{catalog}/{code}", server.UpdateCatalogEntryHandler)
+			r.With(api.RequirePermission(api.PermRatesUpdate)).Put("/api/v1/rates/{pair}", server.UpdateRateHandler)
+			r.Post("/api/v1/quotes", server.CreateQuoteHandler)
+			r.Get("/api/v1/quotes/{quoteId}", server.GetQuoteHandler)
+			r.Post("/api/v1/orders", server.CreateOrderHandler)
+			r.Get("/api/v1/orders", server.GetOrdersHandler)
+			r.With(api.RequirePermission(api.PermUifReportsRead)).Get("/api/v1/orders/uif-reports", server.GetUifReportsHandler)
+			r.Get("/api/v1/orders/{orderId}", server.GetOrderHandler)
+			r.Post("/api/v1/orders/{orderId}/cancel", server.CancelOrderHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Get("/api/v1/orders/queue", server.GetOrderQueueHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/claim", server.ClaimNextOrderHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/{orderId}/claim", server.ClaimOrderHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/{orderId}/transfer-received", server.ConfirmOrderTransferHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/{orderId}/payout", server.RecordOrderPayoutHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/{orderId}/finish", server.FinishOrderHandler)
+			r.With(api.RequirePermission(api.PermOrdersProcess)).Post("/api/v1/orders/{orderId}/reject", server.RejectOrderHandler)
+			r.Get("/api/v1/orders/{orderId}/proofs", server.GetTransferProofsHandler)
+			r.Get("/api/v1/orders/{orderId}/proofs/{proofId}/file", server.DownloadTransferProofHandler)
+			r.Post("/api/v1/auth/logout", server.LogoutHandler)
+			r.Post("/api/v1/auth/logout-all", server.LogoutAllHandler)
+		})
+	})
+
+	// File uploads: multipart forms, their size is bounded by each handler
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AllowContentType("multipart/form-data"))
+		r.Use(server.AuthMiddleware)
+		r.Post("/api/v1/orders/{orderId}/proofs", server.UploadTransferProofHandler)
+	})
 	log.Println("API server at port 8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
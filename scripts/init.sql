@@ -108,4 +108,374 @@ values
     ('inprogress', 'Orden siendo actualmente atendida'),
     ('finished', 'Orden terminada. La casa depositó el dinero solicitado en la orden')
 
-select * from order_state
\ No newline at end of file
+select * from order_state
+
+-- Refresh tokens
+CREATE TABLE refresh_token_families (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP,
+    revoked_reason VARCHAR(30)
+);
+
+CREATE INDEX refresh_token_families_user_id_idx ON refresh_token_families (user_id);
+
+CREATE TABLE refresh_tokens (
+    id VARCHAR(64) PRIMARY KEY,
+    family_id VARCHAR(64) NOT NULL REFERENCES refresh_token_families ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP,
+    replaced_by VARCHAR(64)
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- Revoke every session of a user as soon as it is blocked or deleted
+CREATE FUNCTION revoke_sessions_on_user_state() RETURNS trigger AS $$
+BEGIN
+    IF NEW.state IN ('blocked', 'deleted') AND NEW.state IS DISTINCT FROM OLD.state THEN
+        UPDATE refresh_token_families
+        SET revoked_at = now(), revoked_reason = 'user_' || NEW.state
+        WHERE user_id = NEW.id AND revoked_at IS NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_state_revoke_sessions
+    AFTER UPDATE OF state ON users
+    FOR EACH ROW EXECUTE FUNCTION revoke_sessions_on_user_state();
+
+-- Roles
+CREATE TABLE user_roles (
+    role VARCHAR(20) PRIMARY KEY,
+    description TEXT
+);
+
+insert into user_roles (role, description)
+values
+    ('customer', 'Cliente de la casa de cambio'),
+    ('operator', 'Operador que atiende las ordenes'),
+    ('auditor', 'Acceso de solo lectura para auditoria'),
+    ('admin', 'Administrador de la plataforma');
+
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES user_roles ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- Audit log
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    action VARCHAR(50) NOT NULL,
+    target_user_id INTEGER REFERENCES users ON DELETE RESTRICT,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_log_target_user_id_idx ON audit_log (target_user_id);
+
+-- Optimistic concurrency for user updates (ETag / If-Match)
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Account closure: deleted users are kept (soft delete) and anonymized after the retention window,
+-- their email and DNI can be used again by a new account
+ALTER TABLE users ADD COLUMN anonymized_at TIMESTAMP;
+
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (email) WHERE deleted_at IS NULL;
+
+ALTER TABLE users DROP CONSTRAINT users_dni_key;
+CREATE UNIQUE INDEX users_dni_key ON users (dni) WHERE deleted_at IS NULL;
+
+-- User lifecycle: every new user starts as registered
+ALTER TABLE users ALTER COLUMN state SET DEFAULT 'registered';
+
+-- Identity documents: DNI, Carné de Extranjería, passport and RUC, the number is kept in users.dni
+CREATE TABLE document_types (
+    document_type VARCHAR(10) PRIMARY KEY,
+    description TEXT
+);
+
+insert into document_types(document_type, description)
+values ('dni', 'Documento Nacional de Identidad'),
+       ('ce', 'Carné de Extranjería'),
+       ('passport', 'Pasaporte'),
+       ('ruc', 'Registro Único de Contribuyentes');
+
+ALTER TABLE users ADD COLUMN document_type VARCHAR(10) NOT NULL DEFAULT 'dni' REFERENCES document_types ON DELETE RESTRICT ON UPDATE CASCADE;
+ALTER TABLE users ALTER COLUMN dni TYPE VARCHAR(12);
+DROP INDEX users_dni_key;
+CREATE UNIQUE INDEX users_dni_key ON users (document_type, dni) WHERE deleted_at IS NULL;
+
+-- Business customers: companies operating under a RUC through authorized representatives (existing users)
+CREATE TABLE companies (
+    id SERIAL PRIMARY KEY,
+    legal_name VARCHAR(150) NOT NULL,
+    ruc VARCHAR(11) NOT NULL,
+    fiscal_address VARCHAR(150) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT companies_ruc_key UNIQUE (ruc)
+);
+
+CREATE TABLE company_representatives (
+    company_id INTEGER NOT NULL REFERENCES companies ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (company_id, user_id)
+);
+
+-- Representatives are invited by email and added when the invited user accepts, an email has one open invitation per company
+CREATE TABLE company_invitations (
+    id SERIAL PRIMARY KEY,
+    company_id INTEGER NOT NULL REFERENCES companies ON DELETE CASCADE,
+    email VARCHAR(100) NOT NULL,
+    invited_by_user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    declined_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX company_invitations_company_id_email_key ON company_invitations (company_id, lower(email)) WHERE accepted_at IS NULL AND declined_at IS NULL;
+CREATE INDEX company_invitations_email_idx ON company_invitations (lower(email));
+
+CREATE INDEX company_representatives_user_id_idx ON company_representatives (user_id);
+
+-- Bank accounts: currencies was referenced but never created, bank_accounts is recreated with soft delete aware uniqueness
+CREATE TABLE IF NOT EXISTS currencies (
+    currency_type VARCHAR(5) PRIMARY KEY,
+    description TEXT
+);
+
+insert into currencies (currency_type, description)
+values ('PEN', 'Sol peruano'),
+       ('USD', 'Dólar estadounidense')
+on conflict do nothing;
+
+DROP TABLE IF EXISTS bank_accounts;
+
+CREATE TABLE bank_accounts (
+    id SERIAL PRIMARY KEY,
+    account_number VARCHAR(50) NOT NULL,
+    currency_type VARCHAR(5) NOT NULL,
+    bank_name VARCHAR(20) NOT NULL,
+    user_id INTEGER NOT NULL,
+    company_id INTEGER,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    CONSTRAINT bank_accounts_currency_type_fkey FOREIGN KEY (currency_type) REFERENCES currencies ON DELETE RESTRICT ON UPDATE CASCADE,
+    CONSTRAINT bank_accounts_bank_name_fkey FOREIGN KEY (bank_name) REFERENCES banks ON DELETE RESTRICT ON UPDATE CASCADE,
+    CONSTRAINT bank_accounts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE RESTRICT ON UPDATE CASCADE,
+    CONSTRAINT bank_accounts_company_id_fkey FOREIGN KEY (company_id) REFERENCES companies ON DELETE RESTRICT
+);
+
+-- Deleted accounts can be registered again
+CREATE UNIQUE INDEX bank_accounts_user_id_account_number_key ON bank_accounts (user_id, account_number) WHERE deleted_at IS NULL AND company_id IS NULL;
+CREATE UNIQUE INDEX bank_accounts_company_id_account_number_key ON bank_accounts (company_id, account_number) WHERE deleted_at IS NULL AND company_id IS NOT NULL;
+
+-- CCI (Código de Cuenta Interbancario) of bank accounts, banks are identified by the entity code of the CCI
+insert into banks (bank_name, full_name)
+values ('INTERBANK', 'Interbank'),
+       ('SCOTIABANK', 'Scotiabank Perú'),
+       ('NACION', 'Banco de la Nación'),
+       ('COMERCIO', 'Banco de Comercio'),
+       ('PICHINCHA', 'Banco Pichincha'),
+       ('BANBIF', 'Banco Interamericano de Finanzas'),
+       ('MIBANCO', 'Mibanco')
+on conflict do nothing;
+
+ALTER TABLE bank_accounts ADD COLUMN cci VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE bank_accounts ALTER COLUMN cci DROP DEFAULT;
+
+-- Catalog: banks and currencies can be disabled by admins without breaking the records referencing them
+ALTER TABLE banks ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE currencies ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT true;
+
+-- Bank accounts are matched to their bank by the entity code of their CCI, banks added through the catalog carry theirs
+ALTER TABLE banks ADD COLUMN cci_entity_code VARCHAR(3) CONSTRAINT banks_cci_entity_code_key UNIQUE;
+
+update banks set cci_entity_code = e.cci_entity_code
+from (values ('BCP', '002'), ('INTERBANK', '003'), ('SCOTIABANK', '009'), ('BBVA', '011'), ('NACION', '018'),
+             ('COMERCIO', '023'), ('PICHINCHA', '035'), ('BANBIF', '038'), ('MIBANCO', '049')) as e (bank_name, cci_entity_code)
+where banks.bank_name = e.bank_name;
+
+ALTER TABLE banks ALTER COLUMN cci_entity_code SET NOT NULL;
+
+-- Exchange rate history: every price change of exchange_currency is appended by a trigger, rows are never modified
+CREATE TABLE exchange_rate_history (
+    id BIGSERIAL PRIMARY KEY,
+    exchange_id VARCHAR(10) NOT NULL REFERENCES exchange_currency ON DELETE RESTRICT ON UPDATE CASCADE,
+    buy_price NUMERIC(6, 3) NOT NULL,
+    sale_price NUMERIC(6, 3) NOT NULL,
+    recorded_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX exchange_rate_history_exchange_id_recorded_at_idx ON exchange_rate_history (exchange_id, recorded_at);
+
+CREATE FUNCTION record_exchange_rate() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT'
+        OR NEW.buy_price_currency_main IS DISTINCT FROM OLD.buy_price_currency_main
+        OR NEW.sale_price_currency_main IS DISTINCT FROM OLD.sale_price_currency_main THEN
+        INSERT INTO exchange_rate_history (exchange_id, buy_price, sale_price, recorded_at)
+        VALUES (NEW.exchange_id, NEW.buy_price_currency_main, NEW.sale_price_currency_main, NEW.updated_at);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER exchange_currency_history
+    AFTER INSERT OR UPDATE ON exchange_currency
+    FOR EACH ROW EXECUTE FUNCTION record_exchange_rate();
+
+CREATE FUNCTION reject_history_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'exchange_rate_history is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER exchange_rate_history_append_only
+    BEFORE UPDATE OR DELETE ON exchange_rate_history
+    FOR EACH ROW EXECUTE FUNCTION reject_history_change();
+
+-- Current prices are the first point of the history
+INSERT INTO exchange_rate_history (exchange_id, buy_price, sale_price, recorded_at)
+SELECT exchange_id, buy_price_currency_main, sale_price_currency_main, updated_at FROM exchange_currency;
+
+-- Quotes: the price of a pair locked for a user during minimum_valid_time_mins, consumed by a single order
+CREATE TABLE quotes (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    exchange_id VARCHAR(10) NOT NULL REFERENCES exchange_currency ON DELETE RESTRICT ON UPDATE CASCADE,
+    side VARCHAR(10) NOT NULL REFERENCES order_type ON DELETE RESTRICT ON UPDATE CASCADE,
+    rate NUMERIC(6, 3) NOT NULL,
+    amount_main NUMERIC(14, 2) NOT NULL,
+    amount_secondary NUMERIC(14, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    consumed_at TIMESTAMP
+);
+
+CREATE INDEX quotes_user_id_idx ON quotes (user_id);
+
+-- Orders: placed from a quote, the customer transfers from its source account and the house pays into the destination one
+insert into order_state (order_state, description)
+values ('cancelled', 'Orden cancelada por el cliente antes de ser atendida'),
+       ('expired', 'Orden expirada. El cliente no transfirió el dinero a tiempo'),
+       ('rejected', 'Orden rechazada por un operador')
+on conflict do nothing;
+
+CREATE TABLE orders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    company_id INTEGER REFERENCES companies ON DELETE RESTRICT,
+    quote_id VARCHAR(64) NOT NULL,
+    exchange_id VARCHAR(10) NOT NULL REFERENCES exchange_currency ON DELETE RESTRICT ON UPDATE CASCADE,
+    side VARCHAR(10) NOT NULL REFERENCES order_type ON DELETE RESTRICT ON UPDATE CASCADE,
+    rate NUMERIC(6, 3) NOT NULL,
+    amount_main NUMERIC(14, 2) NOT NULL,
+    amount_secondary NUMERIC(14, 2) NOT NULL,
+    source_bank_account_id INTEGER NOT NULL REFERENCES bank_accounts ON DELETE RESTRICT,
+    destination_bank_account_id INTEGER NOT NULL REFERENCES bank_accounts ON DELETE RESTRICT,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending' REFERENCES order_state ON DELETE RESTRICT ON UPDATE CASCADE,
+    state_reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    CONSTRAINT orders_quote_id_key UNIQUE (quote_id),
+    CONSTRAINT orders_quote_id_fkey FOREIGN KEY (quote_id) REFERENCES quotes ON DELETE RESTRICT
+);
+
+CREATE INDEX orders_user_id_id_idx ON orders (user_id, id);
+CREATE INDEX orders_company_id_id_idx ON orders (company_id, id) WHERE company_id IS NOT NULL;
+CREATE INDEX orders_state_expires_at_idx ON orders (state, expires_at);
+
+-- State changes of orders, from_state is null on creation and actor_user_id is null for changes made by the system
+CREATE TABLE order_events (
+    id BIGSERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders ON DELETE CASCADE,
+    from_state VARCHAR(20) REFERENCES order_state ON DELETE RESTRICT ON UPDATE CASCADE,
+    to_state VARCHAR(20) NOT NULL REFERENCES order_state ON DELETE RESTRICT ON UPDATE CASCADE,
+    actor_user_id INTEGER REFERENCES users ON DELETE RESTRICT,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id);
+
+-- Operator workflow: the operator who claims an order works it until it is finished or rejected
+ALTER TABLE orders ADD COLUMN operator_user_id INTEGER REFERENCES users ON DELETE RESTRICT;
+ALTER TABLE orders ADD COLUMN claimed_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN transfer_received_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN payout_reference VARCHAR(100);
+
+CREATE INDEX orders_state_created_at_idx ON orders (state, created_at);
+CREATE INDEX orders_operator_user_id_idx ON orders (operator_user_id) WHERE state = 'inprogress';
+
+-- Transfer proofs: operation number and voucher of the transfer paying an order, files live in the blob storage
+CREATE TABLE order_transfer_proofs (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders ON DELETE RESTRICT,
+    user_id INTEGER NOT NULL REFERENCES users ON DELETE RESTRICT,
+    operation_number VARCHAR(50) NOT NULL,
+    amount NUMERIC(14, 2) NOT NULL,
+    transferred_at TIMESTAMP NOT NULL,
+    file_key VARCHAR(200) NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    file_size BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT order_transfer_proofs_order_id_operation_number_key UNIQUE (order_id, operation_number)
+);
+
+-- Pending orders with a transfer proof are not expired, operators verify the transfer instead
+ALTER TABLE orders ADD COLUMN transfer_proof_at TIMESTAMP;
+
+-- KYC tiers: the verification level of a user, selecting its transaction limits
+CREATE TABLE kyc_tiers (
+    kyc_tier VARCHAR(20) PRIMARY KEY,
+    description TEXT
+);
+
+insert into kyc_tiers (kyc_tier, description)
+values ('basic', 'Identidad validada con DNI'),
+       ('verified', 'Identidad y domicilio verificados por un operador'),
+       ('enhanced', 'Debida diligencia reforzada, origen de fondos sustentado')
+on conflict do nothing;
+
+ALTER TABLE users ADD COLUMN kyc_tier VARCHAR(20) NOT NULL DEFAULT 'basic'
+    CONSTRAINT users_kyc_tier_fkey REFERENCES kyc_tiers ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- Company orders are charged to the limits of the company, not of the representative placing them
+ALTER TABLE companies ADD COLUMN kyc_tier VARCHAR(20) NOT NULL DEFAULT 'basic'
+    CONSTRAINT companies_kyc_tier_fkey REFERENCES kyc_tiers ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- Transaction limits by tier and currency, a null kyc_tier applies to every tier and a null limit is unbounded.
+-- Daily and monthly limits count the orders not cancelled, expired or rejected, in Lima time: the personal orders of
+-- a user, or every order of a company.
+CREATE TABLE transaction_limits (
+    id SERIAL PRIMARY KEY,
+    kyc_tier VARCHAR(20) REFERENCES kyc_tiers ON DELETE CASCADE ON UPDATE CASCADE,
+    currency_type VARCHAR(5) NOT NULL REFERENCES currencies ON DELETE RESTRICT ON UPDATE CASCADE,
+    per_order_limit NUMERIC(14, 2),
+    daily_limit NUMERIC(14, 2),
+    monthly_limit NUMERIC(14, 2)
+);
+
+CREATE UNIQUE INDEX transaction_limits_kyc_tier_currency_type_key ON transaction_limits (coalesce(kyc_tier, ''), currency_type);
+
+insert into transaction_limits (kyc_tier, currency_type, per_order_limit, daily_limit, monthly_limit)
+values ('basic', 'USD', 1000, 2000, 5000),
+       ('basic', 'PEN', 3800, 7600, 19000),
+       ('verified', 'USD', 10000, 20000, 50000),
+       ('verified', 'PEN', 38000, 76000, 190000),
+       ('enhanced', 'USD', 50000, 100000, 500000),
+       ('enhanced', 'PEN', 190000, 380000, 1900000),
+       (null, 'USD', 100000, null, null)
+on conflict do nothing;
+
+-- Orders above the UIF threshold (UIFTHRESHOLDUSD) are flagged for the report to the UIF
+ALTER TABLE orders ADD COLUMN uif_report BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX orders_uif_report_created_at_idx ON orders (created_at) WHERE uif_report;